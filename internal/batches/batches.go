@@ -0,0 +1,304 @@
+package batches
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+const (
+	StatusValidating = "validating"
+	StatusFailed     = "failed"
+	StatusInProgress = "in_progress"
+	StatusFinalizing = "finalizing"
+	StatusCompleted  = "completed"
+	StatusCancelling = "cancelling"
+	StatusCancelled  = "cancelled"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type File struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+	// Owner is the virtual key which uploaded the file.
+	Owner string `json:"owner,omitempty"`
+}
+
+type Counts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line"`
+}
+
+type Errors struct {
+	Object string  `json:"object"`
+	Data   []Error `json:"data"`
+}
+
+type Batch struct {
+	ID               string            `json:"id"`
+	Object           string            `json:"object"`
+	Endpoint         string            `json:"endpoint"`
+	Errors           *Errors           `json:"errors"`
+	InputFileID      string            `json:"input_file_id"`
+	CompletionWindow string            `json:"completion_window"`
+	Status           string            `json:"status"`
+	OutputFileID     *string           `json:"output_file_id"`
+	ErrorFileID      *string           `json:"error_file_id"`
+	CreatedAt        int64             `json:"created_at"`
+	InProgressAt     *int64            `json:"in_progress_at"`
+	ExpiresAt        int64             `json:"expires_at"`
+	FinalizingAt     *int64            `json:"finalizing_at"`
+	CompletedAt      *int64            `json:"completed_at"`
+	FailedAt         *int64            `json:"failed_at"`
+	CancellingAt     *int64            `json:"cancelling_at"`
+	CancelledAt      *int64            `json:"cancelled_at"`
+	RequestCounts    Counts            `json:"request_counts"`
+	Metadata         map[string]string `json:"metadata"`
+	// Owner is the virtual key which created the batch.
+	Owner string `json:"owner,omitempty"`
+}
+
+func (b *Batch) Fail(errs ...Error) {
+	b.Status = StatusFailed
+	b.FailedAt = Now()
+	b.Errors = &Errors{Object: "list", Data: errs}
+}
+
+// Store keeps files and batches as JSON in a directory: files/{id}.json
+// with metadata next to files/{id}.jsonl content and batches/{id}.json.
+type Store struct {
+	dir string
+
+	mu      sync.Mutex
+	files   map[string]File
+	batches map[string]Batch
+}
+
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir, files: map[string]File{}, batches: map[string]Batch{}}
+	for _, sub := range []string{"files", "batches"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	if err := load(filepath.Join(dir, "files"), s.files); err != nil {
+		return nil, err
+	}
+	if err := load(filepath.Join(dir, "batches"), s.batches); err != nil {
+		return nil, err
+	}
+	for id, b := range s.batches {
+		switch b.Status {
+		case StatusValidating, StatusInProgress, StatusFinalizing:
+			b.Fail(Error{Code: "interrupted", Message: "Batch was interrupted by server restart"})
+		case StatusCancelling:
+			b.Status, b.CancelledAt = StatusCancelled, Now()
+		default:
+			continue
+		}
+		if err := s.save(b); err != nil {
+			return nil, err
+		}
+		s.batches[id] = b
+	}
+	return s, nil
+}
+
+func load[T any](dir string, into map[string]T) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var v T
+		if err := json.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		into[strings.TrimSuffix(filepath.Base(path), ".json")] = v
+	}
+	return nil
+}
+
+func (s *Store) CreateFile(owner, filename, purpose string, content io.Reader) (File, error) {
+	f := File{
+		Owner:     owner,
+		ID:        randomID("file-", 24),
+		Object:    "file",
+		CreatedAt: time.Now().Unix(),
+		Filename:  filename,
+		Purpose:   purpose,
+	}
+	out, err := os.OpenFile(s.filePath(f.ID, ".jsonl"), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return File{}, err
+	}
+	f.Bytes, err = io.Copy(out, content)
+	if err := cmp.Or(err, out.Close()); err != nil {
+		os.Remove(out.Name())
+		return File{}, err
+	}
+	if err := writeJSON(s.filePath(f.ID, ".json"), f); err != nil {
+		os.Remove(out.Name())
+		return File{}, err
+	}
+	s.mu.Lock()
+	s.files[f.ID] = f
+	s.mu.Unlock()
+	return f, nil
+}
+
+func (s *Store) File(id string) (File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.files[id]
+	if !ok {
+		return File{}, ErrNotFound
+	}
+	return f, nil
+}
+
+// Files returns files sorted from the newest.
+func (s *Store) Files() []File {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return newest(s.files, func(f File) int64 { return f.CreatedAt })
+}
+
+func (s *Store) Content(id string) (*os.File, error) {
+	if _, err := s.File(id); err != nil {
+		return nil, err
+	}
+	return os.Open(s.filePath(id, ".jsonl"))
+}
+
+func (s *Store) DeleteFile(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.files, id)
+	os.Remove(s.filePath(id, ".jsonl"))
+	return os.Remove(s.filePath(id, ".json"))
+}
+
+// CreateBatch fills id, object, status and timestamps of the batch.
+func (s *Store) CreateBatch(b Batch) (Batch, error) {
+	now := time.Now()
+	b.ID = randomID("batch_", 24)
+	b.Object = "batch"
+	b.Status = StatusValidating
+	b.CreatedAt = now.Unix()
+	b.ExpiresAt = now.Add(24 * time.Hour).Unix()
+	if b.Metadata == nil {
+		b.Metadata = map[string]string{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.save(b); err != nil {
+		return Batch{}, err
+	}
+	s.batches[b.ID] = b
+	return b, nil
+}
+
+func (s *Store) Batch(id string) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	if !ok {
+		return Batch{}, ErrNotFound
+	}
+	return b, nil
+}
+
+// Batches returns batches sorted from the newest.
+func (s *Store) Batches() []Batch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return newest(s.batches, func(b Batch) int64 { return b.CreatedAt })
+}
+
+// Update changes the batch with fn and saves it.
+func (s *Store) Update(id string, fn func(*Batch)) (Batch, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.batches[id]
+	if !ok {
+		return Batch{}, ErrNotFound
+	}
+	fn(&b)
+	if err := s.save(b); err != nil {
+		return Batch{}, err
+	}
+	s.batches[id] = b
+	return b, nil
+}
+
+func (s *Store) save(b Batch) error {
+	return writeJSON(filepath.Join(s.dir, "batches", b.ID+".json"), b)
+}
+
+func (s *Store) filePath(id, ext string) string {
+	return filepath.Join(s.dir, "files", id+ext)
+}
+
+func writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newest[T any](m map[string]T, created func(T) int64) []T {
+	out := make([]T, 0, len(m))
+	for _, v := range m {
+		out = append(out, v)
+	}
+	slices.SortFunc(out, func(a, b T) int { return cmp.Compare(created(b), created(a)) })
+	return out
+}
+
+func Now() *int64 {
+	now := time.Now().Unix()
+	return &now
+}
+
+func randomID(prefix string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.IntN(len(letters))]
+	}
+	return prefix + string(b)
+}
@@ -0,0 +1,147 @@
+package command
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"freeglm/internal/server"
+	"freeglm/pkg/freeglmclient"
+
+	"charm.land/lipgloss/v2"
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) ask() *cobra.Command {
+	var (
+		model     string
+		system    string
+		asJSON    bool
+		maxTokens int
+		target    string
+		apiKey    string
+	)
+	_ask := &cobra.Command{
+		Use:   "ask [PROMPT...]",
+		Short: "Send one prompt and print the answer",
+		Long: `Send one prompt through the proxy pipeline using configured keys without running
+the server (or to a running one with --target) and stream the answer to stdout
+
+Note:
+	- piped stdin is added after the prompt, or is the prompt when none is given
+	- reasoning is shown faint and usage is printed on stderr, stdout gets only the answer
+	- --json prints the whole chat completion response instead, for jq
+	- keys, providers and aliases are read from ZAI_API_KEY, --config and --keys-file
+`,
+		Example: `
+freeglm ask "What is the capital of France?"
+git diff | freeglm ask --model glm-4.7 "Write a commit message for this diff"
+freeglm ask --system "Answer with one word" --json "Is the sky blue?" | jq -r '.choices[0].message.content'
+freeglm ask --target http://127.0.0.1:5000/v1 "Hello"
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			prompt, err := askPrompt(strings.Join(args, " "), c.InOrStdin())
+			if err != nil {
+				return err
+			}
+			var client *freeglmclient.Client
+			if target != "" {
+				client = freeglmclient.New(freeglmclient.Config{BaseURL: target, APIKey: apiKey})
+			} else {
+				opts := server.Options{Model: model, Retries: 2, RetryBackoff: 500, KeyCooldown: 60}
+				if err := cmd.options(c, &opts); err != nil {
+					return err
+				}
+				// the embedded server logs every request, the answer is enough here
+				log.SetOutput(io.Discard)
+				if client, err = freeglmclient.NewEmbedded(opts, freeglmclient.Config{APIKey: os.Getenv("ZAI_API_KEY")}); err != nil {
+					return err
+				}
+			}
+			req := freeglmclient.ChatRequest{Model: model, MaxTokens: maxTokens}
+			if system != "" {
+				req.Messages = append(req.Messages, freeglmclient.Message{Role: "system", Content: system})
+			}
+			req.Messages = append(req.Messages, freeglmclient.Message{Role: "user", Content: prompt})
+			out, errOut := c.OutOrStdout(), c.ErrOrStderr()
+			if asJSON {
+				resp, err := client.ChatCompletion(c.Context(), req)
+				if err != nil {
+					return err
+				}
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(resp)
+			}
+			stream, err := client.Stream(c.Context(), req)
+			if err != nil {
+				return err
+			}
+			defer stream.Close()
+			faint := lipgloss.NewStyle().Faint(true)
+			var used *freeglmclient.Usage
+			answered := model
+			for {
+				chunk, err := stream.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					fmt.Fprintln(out)
+					return err
+				}
+				for _, choice := range chunk.Choices {
+					if reasoning := choice.Delta.ReasoningContent; reasoning != "" {
+						fmt.Fprint(errOut, faint.Render(reasoning))
+					}
+					fmt.Fprint(out, choice.Delta.Content)
+				}
+				if chunk.Usage != nil {
+					used = chunk.Usage
+				}
+				if chunk.Model != "" {
+					answered = chunk.Model
+				}
+			}
+			fmt.Fprintln(out)
+			if used != nil {
+				fmt.Fprintln(errOut, faint.Render(fmt.Sprintf("%s: %d prompt + %d completion = %d tokens",
+					answered, used.PromptTokens, used.CompletionTokens, used.TotalTokens)))
+			}
+			return nil
+		},
+	}
+	_ask.Flags().StringVarP(&model, "model", "m", "glm-4.7-flash", "Model name")
+	_ask.Flags().StringVarP(&system, "system", "s", "", "System prompt")
+	_ask.Flags().BoolVar(&asJSON, "json", false, "Print the chat completion response as JSON instead of streaming the answer")
+	_ask.Flags().IntVar(&maxTokens, "max-tokens", 0, "max_tokens of the answer (0 uses the server default)")
+	_ask.Flags().StringVar(&target, "target", "", "Base URL of a running proxy to ask instead of upstream")
+	_ask.Flags().StringVar(&apiKey, "api-key", "", "API key for --target")
+	return _ask
+}
+
+// askPrompt joins the prompt with stdin when it is piped.
+func askPrompt(prompt string, in io.Reader) (string, error) {
+	if f, ok := in.(*os.File); ok {
+		if info, err := f.Stat(); err != nil || info.Mode()&os.ModeCharDevice != 0 {
+			in = nil
+		}
+	}
+	if in != nil {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return "", err
+		}
+		if text := strings.TrimSpace(string(data)); text != "" {
+			prompt = strings.TrimSpace(prompt + "\n\n" + text)
+		}
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return "", errors.New("prompt is empty, pass it as argument or on stdin")
+	}
+	return prompt, nil
+}
@@ -0,0 +1,90 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"freeglm/internal/server"
+
+	"charm.land/lipgloss/v2"
+	"charm.land/lipgloss/v2/table"
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) bench() *cobra.Command {
+	var (
+		bench   server.BenchOptions
+		timeout int
+		proxy   string
+	)
+	_bench := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark latency and throughput of models and keys",
+		Long: `Send concurrent synthetic completions and report p50/p95 latency,
+tokens/sec and error rate for every model and key
+
+Note:
+	- keys are read from ZAI_API_KEY, --config and --keys-file
+	- --requests and --concurrency apply to every model and key
+	- with --target a running proxy is benchmarked instead of upstream,
+	  its key is taken from --api-key
+	- tokens/sec is completion tokens divided by request latency
+`,
+		Example: `
+freeglm bench
+freeglm bench --model glm-4.7 --model glm-4.7-flash --requests 50 --concurrency 10
+freeglm bench --keys-file db/keys.txt --max-tokens 256
+freeglm bench --target http://127.0.0.1:5000/v1 --api-key secret
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := server.Options{Timeout: timeout, UpstreamProxy: proxy}
+			if err := cmd.options(c, &opts); err != nil {
+				return err
+			}
+			results, err := server.Bench(c.Context(), opts, bench)
+			if err != nil {
+				return err
+			}
+			rows := make([][]string, 0, len(results))
+			for _, r := range results {
+				rows = append(rows, []string{
+					r.Provider, r.Model, r.Key,
+					strconv.Itoa(r.Requests),
+					fmt.Sprintf("%.0f%%", 100*float64(r.Errors)/float64(max(r.Requests, 1))),
+					r.P50.Round(time.Millisecond).String(),
+					r.P95.Round(time.Millisecond).String(),
+					fmt.Sprintf("%.1f", r.TokensPerSec),
+					r.Message,
+				})
+			}
+			t := table.New().
+				Border(lipgloss.NormalBorder()).
+				Headers("PROVIDER", "MODEL", "KEY", "REQUESTS", "ERRORS", "P50", "P95", "TOK/S", "LAST ERROR").
+				Rows(rows...).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					style := lipgloss.NewStyle().Padding(0, 1)
+					switch {
+					case row == table.HeaderRow:
+						return style.Bold(true)
+					case col == 4 && results[row].Errors != 0:
+						return style.Foreground(lipgloss.Color(keyStatusColor[server.KeyInvalid]))
+					}
+					return style
+				})
+			lipgloss.Println(t)
+			return nil
+		},
+	}
+	_bench.Flags().StringArrayVarP(&bench.Models, "model", "m", []string{"glm-4.7-flash"}, "Model to benchmark, repeat to compare models")
+	_bench.Flags().IntVarP(&bench.Requests, "requests", "n", 20, "Requests for every model and key")
+	_bench.Flags().IntVarP(&bench.Concurrency, "concurrency", "C", 4, "Concurrent requests for every model and key")
+	_bench.Flags().StringVarP(&bench.Prompt, "prompt", "p", "Write a haiku about the sea", "Prompt of synthetic requests")
+	_bench.Flags().IntVar(&bench.MaxTokens, "max-tokens", 64, "max_tokens of synthetic requests")
+	_bench.Flags().StringVar(&bench.Target, "target", "", "Base URL of a running proxy to benchmark instead of upstream")
+	_bench.Flags().StringVar(&bench.APIKey, "api-key", "", "API key for --target")
+	_bench.Flags().IntVarP(&timeout, "timeout", "t", 120, "Timeout for one request in sec.")
+	_bench.Flags().StringVar(&proxy, "upstream-proxy", "", "Proxy URL for upstream requests: http://, socks5:// (default from HTTPS_PROXY, ALL_PROXY)")
+	return _bench
+}
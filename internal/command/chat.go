@@ -0,0 +1,271 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"freeglm/internal/server"
+	"freeglm/pkg/freeglmclient"
+
+	"charm.land/lipgloss/v2"
+	"github.com/spf13/cobra"
+)
+
+const chatHelp = `/model [name]  show or switch model
+/models        list models
+/reset         forget the conversation
+/exit          quit (Ctrl-D)`
+
+type chatHistory struct {
+	Model    string                  `json:"model"`
+	Messages []freeglmclient.Message `json:"messages"`
+}
+
+type chatSession struct {
+	client  *freeglmclient.Client
+	path    string
+	system  string
+	history chatHistory
+	out     io.Writer
+}
+
+func (cmd *Command) chat() *cobra.Command {
+	var (
+		model   string
+		history string
+		system  string
+	)
+	_chat := &cobra.Command{
+		Use:   "chat",
+		Short: "Chat with upstream models in terminal",
+		Long: `Chat with upstream models using configured keys without running the server,
+answers are streamed with reasoning shown faint
+
+Note:
+	- keys, providers and aliases are read from ZAI_API_KEY, --config and --keys-file
+	- the conversation is kept in --history and continued on next start
+	- commands:
+` + indent(chatHelp, "\t  ") + `
+`,
+		Example: `
+freeglm chat
+freeglm chat --model glm-4.7 --system "Answer in one sentence"
+freeglm chat --history "" --config freeglm.json
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := server.Options{Model: model, Retries: 2, RetryBackoff: 500, KeyCooldown: 60}
+			if err := cmd.options(c, &opts); err != nil {
+				return err
+			}
+			// the embedded server logs every request, the answer is enough here
+			log.SetOutput(io.Discard)
+			client, err := freeglmclient.NewEmbedded(opts, freeglmclient.Config{APIKey: os.Getenv("ZAI_API_KEY")})
+			if err != nil {
+				return err
+			}
+			s := &chatSession{client: client, path: history, system: system, out: c.OutOrStdout()}
+			if err := s.load(); err != nil {
+				return err
+			}
+			if c.Flags().Changed("model") || s.history.Model == "" {
+				s.history.Model = model
+			}
+			return s.run(c.Context(), c.InOrStdin())
+		},
+	}
+	_chat.Flags().StringVarP(&model, "model", "m", "glm-4.7-flash", "Model name")
+	_chat.Flags().StringVar(&history, "history", chatHistoryPath(), "File keeping the conversation between runs (empty disables)")
+	_chat.Flags().StringVarP(&system, "system", "s", "", "System prompt sent before the conversation")
+	return _chat
+}
+
+func chatHistoryPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "freeglm", "chat.json")
+}
+
+func indent(text, prefix string) string {
+	return prefix + strings.ReplaceAll(text, "\n", "\n"+prefix)
+}
+
+func (s *chatSession) run(ctx context.Context, in io.Reader) error {
+	if n := len(s.history.Messages); n != 0 {
+		fmt.Fprintf(s.out, "continuing conversation of %d messages, /reset to start over\n", n)
+	}
+	fmt.Fprintln(s.out, "/help for commands")
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	prompt := lipgloss.NewStyle().Bold(true)
+	for {
+		fmt.Fprint(s.out, prompt.Render(s.history.Model+"> "))
+		if !scanner.Scan() {
+			fmt.Fprintln(s.out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "/"):
+			if quit := s.command(ctx, line); quit {
+				return nil
+			}
+			continue
+		}
+		s.history.Messages = append(s.history.Messages, freeglmclient.Message{Role: "user", Content: line})
+		answer, err := s.complete(ctx)
+		if err != nil {
+			// the question is kept out of history so it can be asked again
+			s.history.Messages = s.history.Messages[:len(s.history.Messages)-1]
+			fmt.Fprintln(s.out, lipgloss.NewStyle().Foreground(lipgloss.Red).Render("error: "+err.Error()))
+			continue
+		}
+		s.history.Messages = append(s.history.Messages, answer)
+		if err := s.save(); err != nil {
+			fmt.Fprintln(s.out, "history save error:", err)
+		}
+	}
+}
+
+// command runs a slash command and reports whether the REPL should quit.
+func (s *chatSession) command(ctx context.Context, line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/exit", "/quit":
+		return true
+	case "/reset":
+		s.history.Messages = []freeglmclient.Message{}
+		if err := s.save(); err != nil {
+			fmt.Fprintln(s.out, "history save error:", err)
+		}
+		fmt.Fprintln(s.out, "conversation cleared")
+	case "/model":
+		if arg == "" {
+			fmt.Fprintln(s.out, s.history.Model)
+			break
+		}
+		models, err := s.models(ctx)
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+			break
+		}
+		if !slices.Contains(models, arg) {
+			fmt.Fprintf(s.out, "model must be one of %v\n", models)
+			break
+		}
+		s.history.Model = arg
+		if err := s.save(); err != nil {
+			fmt.Fprintln(s.out, "history save error:", err)
+		}
+	case "/models":
+		models, err := s.models(ctx)
+		if err != nil {
+			fmt.Fprintln(s.out, "error:", err)
+			break
+		}
+		fmt.Fprintln(s.out, strings.Join(models, "\n"))
+	default:
+		fmt.Fprintln(s.out, chatHelp)
+	}
+	return false
+}
+
+func (s *chatSession) models(ctx context.Context) ([]string, error) {
+	list, err := s.client.Models(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(list))
+	for _, m := range list {
+		names = append(names, m.ID)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// complete streams the answer to the conversation and returns it.
+func (s *chatSession) complete(ctx context.Context) (freeglmclient.Message, error) {
+	messages := s.history.Messages
+	if s.system != "" {
+		messages = append([]freeglmclient.Message{{Role: "system", Content: s.system}}, messages...)
+	}
+	stream, err := s.client.Stream(ctx, freeglmclient.ChatRequest{Model: s.history.Model, Messages: messages})
+	if err != nil {
+		return freeglmclient.Message{}, err
+	}
+	defer stream.Close()
+	faint := lipgloss.NewStyle().Faint(true)
+	var content strings.Builder
+	thinking := false
+	for {
+		chunk, err := stream.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(s.out)
+			return freeglmclient.Message{}, err
+		}
+		for _, choice := range chunk.Choices {
+			if reasoning := choice.Delta.ReasoningContent; reasoning != "" {
+				thinking = true
+				fmt.Fprint(s.out, faint.Render(reasoning))
+			}
+			if text := choice.Delta.Content; text != "" {
+				if thinking {
+					fmt.Fprint(s.out, "\n\n")
+					thinking = false
+				}
+				content.WriteString(text)
+				fmt.Fprint(s.out, text)
+			}
+		}
+	}
+	fmt.Fprintln(s.out)
+	return freeglmclient.Message{Role: "assistant", Content: content.String()}, nil
+}
+
+func (s *chatSession) load() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &s.history); err != nil {
+		return fmt.Errorf("parse history %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *chatSession) save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s.history, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o600)
+}
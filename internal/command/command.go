@@ -2,41 +2,207 @@ package command
 
 import (
 	"context"
+	"fmt"
+	"maps"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"freeglm/internal/config"
 	"freeglm/internal/server"
+	"freeglm/internal/service"
+	"freeglm/internal/version"
 
 	"github.com/charmbracelet/fang"
 	"github.com/spf13/cobra"
 )
 
+type serverFlags struct {
+	pidFile      string
+	maxBody      int64
+	socketMode   string
+	drainTimeout int
+	validateKeys bool
+	strictKeys   bool
+}
+
+// warmKeys drops invalid keys from opts when --validate-keys or
+// --strict-keys is set.
+func (flags *serverFlags) warmKeys(c *cobra.Command, opts *server.Options) error {
+	if !flags.validateKeys && !flags.strictKeys {
+		return nil
+	}
+	return server.WarmKeys(c.Context(), opts, flags.strictKeys)
+}
+
 type Command struct {
-	cmd *cobra.Command
+	cmd      *cobra.Command
+	config   string
+	keysFile string
+	aliases  map[string]string
 }
 
-func (cmd *Command) server(model *string, listen *string, timeout *int) func(*cobra.Command, []string) error {
+func (cmd *Command) load(c *cobra.Command) (*config.Config, error) {
+	_config, warning := config.New()
+	if err := _config.Load(cmd.config); err != nil {
+		return nil, err
+	}
+	if err := _config.LoadKeys(cmd.keysFile); err != nil {
+		return nil, err
+	}
+	if warning != nil && len(_config.Keys) == 0 {
+		c.Println("config warning:", warning)
+	}
+	return _config, nil
+}
+
+func (cmd *Command) options(c *cobra.Command, opts *server.Options) error {
+	_config, err := cmd.load(c)
+	if err != nil {
+		return err
+	}
+	opts.Keys = _config.Keys
+	opts.Providers = nil
+	for _, p := range _config.Providers {
+		opts.Providers = append(opts.Providers, server.Provider(p))
+	}
+	opts.Aliases = maps.Clone(cmd.aliases)
+	if opts.Aliases == nil {
+		opts.Aliases = map[string]string{}
+	}
+	maps.Copy(opts.Aliases, _config.Aliases)
+	opts.Profiles = map[string]server.Profile{}
+	for name, p := range _config.Profiles {
+		opts.Profiles[name] = server.Profile(p)
+	}
+	opts.Rewrites = nil
+	for _, rw := range _config.Rewrites {
+		opts.Rewrites = append(opts.Rewrites, server.Rewrite{
+			Match:         server.RewriteMatch(rw.Match),
+			Set:           rw.Set,
+			Override:      rw.Override,
+			Remove:        rw.Remove,
+			PrependSystem: rw.PrependSystem,
+			AppendSystem:  rw.AppendSystem,
+		})
+	}
+	opts.Templates = map[string]server.Template{}
+	for name, t := range _config.Templates {
+		opts.Templates[name] = server.Template(t)
+	}
+	opts.Tenants = map[string]server.Tenant{}
+	for name, t := range _config.Tenants {
+		tenant := server.Tenant{
+			Hosts:       t.Hosts,
+			Prefix:      t.Prefix,
+			Keys:        t.Keys,
+			Aliases:     t.Aliases,
+			DailyTokens: t.DailyTokens,
+			RPM:         t.RPM,
+		}
+		for _, p := range t.Providers {
+			tenant.Providers = append(tenant.Providers, server.Provider(p))
+		}
+		opts.Tenants[name] = tenant
+	}
+	opts.Transport = server.Transport{}
+	if _config.Transport != nil {
+		opts.Transport = server.Transport(*_config.Transport)
+	}
+	if _config.Storage != "" && !c.Flags().Changed("storage") {
+		opts.Storage = _config.Storage
+	}
+	return nil
+}
+
+func (cmd *Command) watch(c *cobra.Command, _server *server.Server, opts server.Options, flags *serverFlags) {
+	var paths []string
+	for _, path := range []string{cmd.config, cmd.keysFile} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return
+	}
+	go config.Watch(c.Context(), 2*time.Second, func() {
+		if err := cmd.options(c, &opts); err != nil {
+			c.Println("reload error:", err)
+			return
+		}
+		if err := flags.warmKeys(c, &opts); err != nil {
+			c.Println("reload error:", err)
+			return
+		}
+		if err := _server.Reload(opts); err != nil {
+			c.Println("reload error:", err)
+			return
+		}
+		c.Println("reloaded:", strings.Join(paths, ", "))
+	}, paths...)
+}
+
+func (cmd *Command) server(opts *server.Options, flags *serverFlags) func(*cobra.Command, []string) error {
 	return func(c *cobra.Command, s []string) error {
-		_config, err := config.New()
+		mode, err := strconv.ParseUint(flags.socketMode, 8, 32)
 		if err != nil {
-			c.Println("config warning:", err)
+			return fmt.Errorf("invalid socket mode %q: %w", flags.socketMode, err)
+		}
+		opts.SocketMode = os.FileMode(mode)
+		if flags.pidFile != "" {
+			if err := os.WriteFile(flags.pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+				return err
+			}
+			defer func() {
+				// after an upgrade the file holds the PID of the new process
+				if data, err := os.ReadFile(flags.pidFile); err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(os.Getpid()) {
+					os.Remove(flags.pidFile)
+				}
+			}()
 		}
 
-		_server, err := server.New(
-			_config.Keys,
-			*model,
-			*listen,
-			*timeout,
-		)
+		if err := cmd.options(c, opts); err != nil {
+			return err
+		}
+		if err := flags.warmKeys(c, opts); err != nil {
+			return err
+		}
+		opts.MaxBodySize = flags.maxBody << 20
+		_server, err := server.New(*opts)
 		if err != nil {
 			return err
 		}
+		cmd.watch(c, _server, *opts, flags)
 
-		c.Println("start server:", *listen)
-		if err := _server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		scheme := "http"
+		if _server.TLS() {
+			scheme = "https"
+		}
+		c.Printf("start server: %s (%s)\n", opts.Listen, scheme)
+		drained := make(chan struct{})
+		serve := func() error {
+			err := _server.ListenAndServe()
+			if err == http.ErrServerClosed {
+				// Serve returns at once, active requests finish in Shutdown
+				<-drained
+				return nil
+			}
 			return err
 		}
-		return nil
+		shutdown := sync.OnceFunc(func() {
+			defer close(drained)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(flags.drainTimeout)*time.Second)
+			defer cancel()
+			_server.Shutdown(ctx)
+		})
+		handleSignals(c, _server, shutdown)
+		if ok, err := service.Serve(serve, shutdown); ok || err != nil {
+			return err
+		}
+		return serve()
 	}
 }
 
@@ -57,6 +223,24 @@ func New() *Command {
 Main commands:
 	freeglm server
 		Run freeglm server
+	freeglm service
+		Install freeglm server as systemd/launchd service
+	freeglm keys check
+		Check configured API keys against upstream
+	freeglm healthcheck
+		Check running server, exits non-zero when unhealthy
+	freeglm chat
+		Chat with upstream models in terminal
+	freeglm ask
+		Send one prompt and print the answer, for shell scripts
+	freeglm pipe
+		Answer one JSON request from stdin on stdout, for editors without a listener
+	freeglm bench
+		Benchmark latency and throughput of models and keys
+	freeglm usage
+		Report token usage per day or week, key and model
+	freeglm version
+		Print version of this binary or of a running proxy with its features
 `,
 			Example: `
 freeglm server
@@ -65,6 +249,7 @@ freeglm server --model glm-4.7-flash
 freeglm server --model glm-4.7
 freeglm server --timeout 120
 freeglm server --listen 0.0.0.0:5001
+freeglm server --config freeglm.json
 ZAI_API_KEY=275dd***************************.**************si freeglm server
 `,
 			RunE: func(c *cobra.Command, args []string) error {
@@ -73,10 +258,12 @@ ZAI_API_KEY=275dd***************************.**************si freeglm server
 		},
 	}
 
+	_command.cmd.PersistentFlags().StringVarP(&_command.config, "config", "c", "", "Path to JSON config file (reloaded on change)")
+	_command.cmd.PersistentFlags().StringVar(&_command.keysFile, "keys-file", "", "Path to file with API keys, one per line or \",\" separated (reloaded on change)")
+
 	var (
-		model   string
-		listen  string
-		timeout int
+		opts  server.Options
+		flags serverFlags
 	)
 
 	server := &cobra.Command{
@@ -88,62 +275,134 @@ ZAI_API_KEY=275dd***************************.**************si freeglm server
 By default:
 	- uses the "glm-4.7-flash" model
 	- timeout is disabled (see the --timeout flag)
+	- max_tokens is clamped to 8192 (see the --max-tokens and --no-clamp flags)
 
 Note:
 	- set ZAI_API_KEY in environment
 	- set many API keys via "," like ZAI_API_KEY=8*****X,c*****a,2*****7
-	- if ZAI_API_KEY env not set use config like this in agent (opencode)
-	{
-	  "$schema": "https://opencode.ai/config.json",
-	  "provider": {
-	    "FreeGLM": {
-	      "npm": "@ai-sdk/openai-compatible",
-	      "options": {
-	        "baseURL": "http://127.0.0.1:5000/v1",
-	        "apiKey": "{env:ZAI_API_KEY}", # this send ZAI_API_KEY with "Authorization: Beared {token}" header
-	      },
-	      "models": {
-	        "glm-4.7-flash": {
-	          "attachment": true,
-	          "tool_call": true,
-	          "reasoning": true,
-	        }
-	      }
-	    },
-	  },
-	}
+	- route models to other OpenAI compatible upstreams via "providers" in --config
+	- see README.md for all features and the config format
 `,
 		Example: `
 freeglm server
 Run server with default settings
 
-freeglm server --model glm-4.7-flash
-Run server with model "glm-4.7-flash" - reccomended (free to use)
-
 freeglm server --model glm-4.7
-Run server with model "glm-4.7" - need "condig plan" on z.ai
+Run server with model "glm-4.7" - need "coding plan" on z.ai
 
 freeglm server --timeout 120
 Run server with timeout for one request not more then 120 sec.
 
 freeglm server --listen 0.0.0.0:5001
 Run server and listen any host on port 5001
+
+freeglm server --config freeglm.json --keys-file db/keys.txt
+Run server with providers, profiles and tenants from config, reloaded on change
+
+freeglm server --storage redis://redis:6379/0 --cache-size 1000
+Keep cache, threads and key rotation in Redis shared by replicas
 `,
-		RunE: _command.server(
-			&model, &listen, &timeout,
-		),
+		RunE: _command.server(&opts, &flags),
 	}
-	server.Flags().StringVarP(&model, "model", "m", "glm-4.7-flash", "Model name")
-	server.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:5000", "Server listen")
-	server.Flags().IntVarP(&timeout, "timeout", "t", 0, "Seconds of timeout for one request")
+	server.Flags().StringVarP(&opts.Model, "model", "m", "glm-4.7-flash", "Model name")
+	server.Flags().StringVarP(&opts.Listen, "listen", "l", "127.0.0.1:5000", "Server listen address or unix:/path.sock")
+	server.Flags().IntVarP(&opts.Timeout, "timeout", "t", 0, "Seconds of timeout for one request")
+	server.Flags().IntVar(&opts.MaxTimeout, "max-timeout", 0, "Longest timeout in seconds clients may set with X-Freeglm-Timeout or \"timeout\" (0 is --timeout)")
+	server.Flags().IntVar(&opts.ConnectTimeout, "connect-timeout", 10, "Seconds to connect upstream (0 is no limit)")
+	server.Flags().IntVar(&opts.FirstByteTimeout, "first-byte-timeout", 0, "Seconds to wait for upstream response headers (0 is no limit)")
+	server.Flags().StringVar(&opts.UpstreamProxy, "upstream-proxy", "", "Proxy URL for upstream requests: http://, socks5:// (default from HTTPS_PROXY, ALL_PROXY)")
+	server.Flags().StringToStringVar(&_command.aliases, "alias", nil, "Serve model under another name (alias=model), e.g. gpt-4o=glm-4.7")
+	server.Flags().StringToIntVar(&opts.MaxTokens, "max-tokens", nil, "Override max_tokens limit per model (model=limit, 0 disables clamping)")
+	server.Flags().BoolVar(&opts.NoClamp, "no-clamp", false, "Do not clamp max_tokens for any model")
+	server.Flags().IntVar(&opts.DefaultTokens, "default-tokens", 4096, "max_tokens used when the client does not send one")
+	server.Flags().IntVar(&opts.MinTokens, "min-tokens", 0, "Raise client max_tokens below this floor")
+	server.Flags().BoolVar(&opts.TrustTokens, "trust-tokens", false, "Forward client max_tokens below the limit untouched (ignores --min-tokens)")
+	server.Flags().StringVar(&opts.KeyStrategy, "key-strategy", "round-robin", "API key selection strategy: round-robin, random, lru, weighted")
+	server.Flags().IntVar(&opts.KeyQuota, "key-quota", 0, "Token quota per API key used by the weighted strategy (0 weights by fewest tokens spent)")
+	server.Flags().StringVarP(&opts.Reasoning, "reasoning", "r", "passthrough", "reasoning_content handling: passthrough, strip, merge (<think> in content), openai (reasoning field)")
+	server.Flags().StringVarP(&opts.Profile, "profile", "p", "", "Default client profile: opencode, aider, openwebui or one from --config")
+	server.Flags().Float64Var(&opts.RateLimit, "rate-limit", 0, "Requests per second allowed per client (0 disables)")
+	server.Flags().IntVar(&opts.RateBurst, "rate-burst", 0, "Burst of requests allowed per client (default is --rate-limit rounded up)")
+	server.Flags().StringVar(&opts.RateLimitBy, "rate-limit-by", "ip", "Rate limit clients by: ip, key (Authorization token), user (OpenAI user field of chat requests), both fall back to ip")
+	server.Flags().StringVar(&opts.ContextStrategy, "context-strategy", "off", "On context overflow: off, error, truncate-oldest or summarize")
+	server.Flags().StringVar(&opts.ImageUpload, "image-upload", "", "Upload base64 images to /files of the model provider (\"provider\") or to this URL and send the returned reference")
+	server.Flags().IntVar(&opts.ImageUploadOver, "image-upload-over", 256, "Upload only base64 images larger than this many KiB with --image-upload")
+	server.Flags().IntVar(&opts.ImageUploadMax, "image-upload-max", 20, "Reject base64 images larger than this many MiB with 413 with --image-upload (0 disables)")
+	server.Flags().BoolVar(&opts.SplitTools, "split-tool-calls", false, "Send parallel tool calls one per turn and join them again for upstream (also per request with \"parallel_tool_calls\": false)")
+	server.Flags().StringVar(&opts.Prefill, "prefill", "auto", "Requests ending with an assistant message: auto (emulate when upstream answers 400), pass or emulate")
+	server.Flags().IntVar(&opts.CacheSize, "cache-size", 0, "Cache this many non-stream responses in LRU (0 disables caching)")
+	server.Flags().IntVar(&opts.CacheTTL, "cache-ttl", 3600, "Seconds a cached response is served (0 keeps until evicted)")
+	server.Flags().StringVar(&opts.CacheFile, "cache-file", "", "Path to keep cached responses across restarts")
+	server.Flags().StringVar(&opts.AdminToken, "admin-token", "", "Bearer token for /admin endpoints (empty disables them)")
+	server.Flags().StringVar(&opts.VirtualKeys, "virtual-keys", "", "Accept only virtual keys from this file made by \"freeglm keys create\" (empty disables)")
+	server.Flags().StringVar(&opts.BatchDir, "batch-dir", "", "Directory for /v1/files and /v1/batches (empty disables batches)")
+	server.Flags().IntVar(&opts.BatchWorkers, "batch-workers", 4, "Concurrent requests per batch")
+	server.Flags().StringToIntVar(&opts.Lanes, "lane", nil, "Concurrent upstream requests per X-Priority lane (lane=limit, \"normal\" lane is required)")
+	server.Flags().BoolVar(&opts.ReadyCheck, "ready-check", false, "Make /readyz check that at least one key gets a completion from upstream")
+	server.Flags().IntVar(&opts.ResumeWindow, "resume-window", 0, "Keep finished streams this many seconds for clients reconnecting with Last-Event-ID (0 disables)")
+	server.Flags().BoolVar(&opts.DiscoverModels, "discover-models", false, "Add models listed by upstream GET /models of every provider to routing and /v1/models")
+	server.Flags().IntVar(&opts.DiscoverInterval, "discover-interval", 60, "Minutes between model listings with --discover-models (0 lists only on start)")
+	server.Flags().BoolVar(&flags.validateKeys, "validate-keys", false, "Check all keys concurrently on start and reload, drop keys upstream rejects as invalid")
+	server.Flags().BoolVar(&flags.strictKeys, "strict-keys", false, "Like --validate-keys, but refuse to start when no key is usable")
+	server.Flags().IntVar(&opts.KeyCooldown, "key-cooldown", 60, "Skip API key for this many seconds after upstream 429 without Retry-After")
+	server.Flags().IntVar(&opts.DailyBudget, "daily-budget-tokens", 0, "Reject requests once all keys used this many tokens today (0 disables)")
+	server.Flags().IntVar(&opts.KeyBudget, "daily-budget-key-tokens", 0, "Skip a key once it used this many tokens today (0 disables)")
+	server.Flags().StringVar(&opts.BudgetAction, "budget-action", "reject", "When the daily budget is used up: reject with 429 or queue until midnight")
+	server.Flags().StringVar(&opts.BudgetTimezone, "budget-timezone", "UTC", "Timezone whose midnight starts a new budget day (IANA name or Local)")
+	server.Flags().IntVar(&opts.QueueWait, "queue-wait", 0, "Hold requests up to this many seconds when all keys are cooling down (0 fails at once)")
+	server.Flags().IntVar(&opts.Retries, "retries", 0, "Retry connection errors and 502/503/504 upstream responses this many times")
+	server.Flags().IntVar(&opts.RetryBackoff, "retry-backoff", 500, "Base retry delay in ms, doubled on every attempt with jitter")
+	server.Flags().IntVar(&opts.BreakerThreshold, "breaker-threshold", 0, "Fail fast with 503 after this many consecutive upstream failures of a URL (0 disables)")
+	server.Flags().IntVar(&opts.BreakerCooldown, "breaker-cooldown", 30, "Seconds the circuit of a failing upstream URL stays open before a probe request")
+	server.Flags().StringVar(&opts.ThreadsDB, "threads-db", "", "Enable /v1/threads conversation store in this bbolt file")
+	server.Flags().StringVar(&opts.Storage, "storage", "memory", "Keep cache and threads in memory, bolt:PATH or redis://HOST:PORT/DB (also shares key rotation, cooldowns and quotas)")
+	server.Flags().StringVar(&opts.UsageDB, "usage-db", "", "Record token usage per day, key and model in this SQLite file for \"freeglm usage\"")
+	server.Flags().StringVar(&opts.Moderation.URL, "moderation-url", "", "Check text with this OpenAI compatible /v1/moderations endpoint")
+	server.Flags().StringVar(&opts.Moderation.Key, "moderation-key", "", "API key for --moderation-url")
+	server.Flags().StringVar(&opts.Moderation.Patterns, "moderation-patterns", "", "Check text against regular expressions in this file, one per line")
+	server.Flags().StringVar(&opts.Moderation.Action, "moderation-action", "reject", "What to do with flagged text: reject or redact (patterns only)")
+	server.Flags().StringVar(&opts.Moderation.Scope, "moderation-scope", "prompt", "Moderate prompt, completion or both")
+	server.Flags().StringArrayVar(&opts.HookPlugins, "hook-plugin", nil, "Load request/response hooks from Go plugin (.so exporting Hook)")
+	server.Flags().StringArrayVar(&opts.HookScripts, "hook-script", nil, "Run command as request/response hook speaking JSON lines on stdin/stdout")
+	server.Flags().Int64Var(&flags.maxBody, "max-body-size", 32, "Max chat request body size in MiB (0 disables)")
+	server.Flags().StringVar(&flags.pidFile, "pid-file", "", "Write server PID to this file")
+	server.Flags().StringVar(&flags.socketMode, "socket-mode", "0600", "Permissions of unix socket for --listen unix:/path.sock")
+	server.Flags().BoolVar(&opts.NoKeepAlive, "no-keep-alive", false, "Close client connections after every response instead of keeping them alive")
+	server.Flags().IntVar(&opts.IdleTimeout, "idle-timeout", 120, "Seconds a kept alive client connection may idle before it is closed (0 never closes)")
+	server.Flags().BoolVar(&opts.ReusePort, "reuse-port", false, "Listen with SO_REUSEPORT so a new freeglm can bind the address while this one drains")
+	server.Flags().IntVar(&flags.drainTimeout, "drain-timeout", 300, "Seconds active requests may take to finish on SIGTERM, SIGUSR2 upgrade or service stop")
+	server.Flags().BoolVar(&opts.JSONRepair, "json-repair", false, "Validate and repair non-stream response_format JSON output")
+	server.Flags().IntVar(&opts.JSONRetries, "json-retries", 1, "Ask upstream again this many times when --json-repair can not fix the output")
+	server.Flags().StringVar(&opts.TLSCert, "tls-cert", "", "Serve HTTPS with this PEM certificate")
+	server.Flags().StringVar(&opts.TLSKey, "tls-key", "", "PEM private key for --tls-cert")
+	server.Flags().BoolVar(&opts.TLSSelfSigned, "tls-self-signed", false, "Serve HTTPS with self-signed certificate generated on first run (into --tls-cert/--tls-key or config dir)")
+	server.Flags().BoolVar(&opts.NoCompress, "no-compress", false, "Do not gzip large JSON responses for clients sending Accept-Encoding: gzip")
+	server.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Answer chat requests with the payload that would be sent upstream instead of calling it")
+	server.Flags().StringVar(&opts.TeeDir, "tee-dir", "", "Write every stream as relayed to the client into a file in this directory")
+	server.Flags().StringVar(&opts.Logprobs, "logprobs", "strip", "Requests for logprobs GLM does not return: strip them, reject with 400 or answer empty logprobs")
+	server.Flags().BoolVar(&opts.Dedup, "dedup", false, "Send identical non-stream requests in flight at once upstream only once and answer all with the response")
+	server.Flags().BoolVar(&opts.Affinity, "session-affinity", false, "Pick the pool key by conversation so all its turns use the same key")
+	server.Flags().BoolVar(&opts.Fair, "fair", false, "Share keys and lane slots fairly between clients (virtual key or IP) by their recent token usage")
+	server.Flags().BoolVar(&opts.Race, "race", false, "Race glm-4.7 and glm-4.7-flash on every chat request and answer with the first to finish")
+	server.Flags().IntVar(&opts.RaceMargin, "race-margin", 1000, "Milliseconds glm-4.7 may finish after glm-4.7-flash and still win the race")
+	server.Flags().StringVar(&opts.Shadow, "shadow", "", "Mirror sampled chat requests to this model and record its latency and tokens in /metrics, answers are discarded")
+	server.Flags().Float64Var(&opts.ShadowRate, "shadow-rate", 10, "Percent of chat requests mirrored to --shadow")
+	server.Flags().BoolVar(&opts.WebSocket, "websocket", false, "Serve streaming chat completions over WebSocket at /ws/chat")
+	server.Flags().StringArrayVar(&opts.WebSocketOrigins, "websocket-origin", nil, "Allow WebSockets from pages of other origins matching host pattern like *.example.com")
+	server.Flags().StringVar(&opts.AccessLog, "access-log", "", "Write access log in format: common, combined, json (empty disables)")
+	server.Flags().StringVar(&opts.AccessLogFile, "access-log-file", "", "Append access log to this file instead of stdout")
+	server.Flags().BoolVar(&opts.LogFullBodies, "log-full-bodies", false, "Do not truncate long log lines (keys and Authorization values are masked anyway)")
+	server.Flags().StringVar(&opts.StatsFile, "stats-file", "", "Persist token usage stats to this JSON file")
+	server.Flags().IntVarP(&opts.Keepalive, "keepalive", "k", 0, "Seconds between SSE keepalive comments on idle streams (0 disables)")
 
-	_command.cmd.AddCommand(server)
+	_command.cmd.AddCommand(server, _command.service(), _command.keys(), _command.healthcheck(), _command.chat(), _command.ask(), _command.pipe(), _command.bench(), _command.usage(), _command.version(), _command.mock())
 
 	return _command
 }
 
 func (cmd *Command) Execute(ctx context.Context) error {
-	if err := fang.Execute(ctx, cmd.cmd); err != nil {
+	info := version.Get()
+	if err := fang.Execute(ctx, cmd.cmd, fang.WithVersion(info.Version), fang.WithCommit(info.Commit)); err != nil {
 		return err
 	}
 	return nil
@@ -0,0 +1,81 @@
+package command
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) healthcheck() *cobra.Command {
+	var (
+		listen  string
+		live    bool
+		https   bool
+		timeout int
+	)
+	_healthcheck := &cobra.Command{
+		Use:   "healthcheck",
+		Short: "Check running server and exit non-zero when unhealthy",
+		Long: `Request /readyz (or /healthz with --live) of running server
+for Docker HEALTHCHECK, systemd or monitoring scripts
+
+Note:
+	- /healthz answers 200 while the server is up
+	- /readyz answers 503 when no models are routed, run server with --ready-check
+	  to also require at least one working key (checked at most every 30 sec.)
+	- certificate is not verified with --tls, the server may use a self-signed one
+`,
+		Example: `
+freeglm healthcheck
+freeglm healthcheck --live
+freeglm healthcheck --listen unix:/run/freeglm.sock
+freeglm healthcheck --listen 127.0.0.1:5443 --tls
+
+Dockerfile:
+HEALTHCHECK CMD ["/freeglm/freeglm","healthcheck","--listen","127.0.0.1:5000"]
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			transport := &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+			host := listen
+			if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+				host = "localhost"
+				transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", path)
+				}
+			}
+			scheme, path := "http", "/readyz"
+			if https {
+				scheme = "https"
+			}
+			if live {
+				path = "/healthz"
+			}
+			client := &http.Client{Transport: transport, Timeout: time.Duration(timeout) * time.Second}
+			resp, err := client.Get(scheme + "://" + host + path)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+			c.Println(strings.TrimSpace(string(body)))
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("unhealthy: %s", resp.Status)
+			}
+			return nil
+		},
+	}
+	_healthcheck.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:5000", "Server listen address or unix:/path.sock")
+	_healthcheck.Flags().BoolVar(&live, "live", false, "Check liveness (/healthz) instead of readiness (/readyz)")
+	_healthcheck.Flags().BoolVar(&https, "tls", false, "Server is listening with HTTPS")
+	_healthcheck.Flags().IntVarP(&timeout, "timeout", "t", 5, "Request timeout in seconds")
+	return _healthcheck
+}
@@ -0,0 +1,231 @@
+package command
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"freeglm/internal/server"
+
+	"charm.land/lipgloss/v2"
+	"charm.land/lipgloss/v2/table"
+	"github.com/spf13/cobra"
+)
+
+var keyStatusColor = map[string]string{
+	server.KeyValid:   "2",
+	server.KeyLimited: "3",
+	server.KeyInvalid: "1",
+	server.KeyError:   "5",
+}
+
+func (cmd *Command) keys() *cobra.Command {
+	_keys := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage API keys",
+		RunE: func(c *cobra.Command, args []string) error {
+			return c.Help()
+		},
+	}
+
+	var (
+		timeout int
+		proxy   string
+	)
+	check := &cobra.Command{
+		Use:   "check",
+		Short: "Check configured API keys against upstream",
+		Long: `Send a one token completion with every configured key and report
+which keys are valid, rate-limited or invalid (expired, revoked)
+
+Note:
+	- keys are read from ZAI_API_KEY, --config and --keys-file
+	- shared keys are checked against every provider without own keys
+	- exits with error when any key is not valid
+`,
+		Example: `
+freeglm keys check
+freeglm keys check --keys-file db/keys.txt
+freeglm keys check --config freeglm.json --timeout 10
+freeglm keys check --upstream-proxy socks5://127.0.0.1:1080
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := server.Options{Timeout: timeout, UpstreamProxy: proxy}
+			if err := cmd.options(c, &opts); err != nil {
+				return err
+			}
+			checks, err := server.CheckKeys(c.Context(), opts)
+			if err != nil {
+				return err
+			}
+			if len(checks) == 0 {
+				return fmt.Errorf("no keys configured")
+			}
+
+			failed := 0
+			rows := make([][]string, 0, len(checks))
+			for _, k := range checks {
+				if k.Status != server.KeyValid {
+					failed++
+				}
+				rows = append(rows, []string{k.Provider, k.Model, k.Key, k.Status, k.Latency.Round(time.Millisecond).String(), k.Message})
+			}
+			t := table.New().
+				Border(lipgloss.NormalBorder()).
+				Headers("PROVIDER", "MODEL", "KEY", "STATUS", "LATENCY", "MESSAGE").
+				Rows(rows...).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					style := lipgloss.NewStyle().Padding(0, 1)
+					switch {
+					case row == table.HeaderRow:
+						return style.Bold(true)
+					case col == 3:
+						return style.Foreground(lipgloss.Color(keyStatusColor[checks[row].Status]))
+					}
+					return style
+				})
+			lipgloss.Println(t)
+
+			if failed != 0 {
+				return fmt.Errorf("%d of %d keys are not valid", failed, len(checks))
+			}
+			return nil
+		},
+	}
+	check.Flags().IntVarP(&timeout, "timeout", "t", 30, "Timeout for one check in sec.")
+	check.Flags().StringVar(&proxy, "upstream-proxy", "", "Proxy URL for upstream requests: http://, socks5:// (default from HTTPS_PROXY, ALL_PROXY)")
+	_keys.AddCommand(check)
+	_keys.AddCommand(cmd.virtualKeys()...)
+	return _keys
+}
+
+func virtualKeysPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "virtual-keys.json"
+	}
+	return filepath.Join(dir, "freeglm", "virtual-keys.json")
+}
+
+func (cmd *Command) virtualKeys() []*cobra.Command {
+	var (
+		file        string
+		name        string
+		dailyTokens int
+		rpm         int
+	)
+	create := &cobra.Command{
+		Use:   "create",
+		Short: "Issue virtual API key for a client",
+		Long: `Issue a virtual API key for "freeglm server --virtual-keys", the client sends it
+instead of an upstream key and requests are sent with pool keys within its quotas
+
+Note:
+	- running server picks up new and revoked keys without restart
+	- --daily-tokens counts total tokens per UTC day, 0 is unlimited
+	- --rpm limits requests per minute, 0 is unlimited
+`,
+		Example: `
+freeglm keys create --name alice --daily-tokens 200000
+freeglm keys create --name ci --rpm 10 --file db/virtual-keys.json
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			keys, err := server.LoadVirtualKeys(file)
+			if err != nil {
+				return err
+			}
+			if slices.ContainsFunc(keys, func(k server.VirtualKey) bool { return k.Name == name }) {
+				return fmt.Errorf("virtual key %s already exists", name)
+			}
+			key, err := server.NewVirtualKey(name, dailyTokens, rpm)
+			if err != nil {
+				return err
+			}
+			if err := server.SaveVirtualKeys(file, append(keys, key)); err != nil {
+				return err
+			}
+			fmt.Fprintln(c.OutOrStdout(), key.Key)
+			return nil
+		},
+	}
+	create.Flags().StringVarP(&name, "name", "n", "", "Client name")
+	create.Flags().IntVar(&dailyTokens, "daily-tokens", 0, "Total tokens per UTC day (0 is unlimited)")
+	create.Flags().IntVar(&rpm, "rpm", 0, "Requests per minute (0 is unlimited)")
+	create.MarkFlagRequired("name")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List virtual API keys and their usage today",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			keys, err := server.LoadVirtualKeys(file)
+			if err != nil {
+				return err
+			}
+			usage, err := server.LoadVirtualUsage(file)
+			if err != nil {
+				return err
+			}
+			today := time.Now().UTC().Format(time.DateOnly)
+			rows := make([][]string, 0, len(keys))
+			for _, k := range keys {
+				used := 0
+				if u, ok := usage[k.Name]; ok && u.Day == today {
+					used = u.Tokens
+				}
+				rows = append(rows, []string{k.Name, k.Key[:min(len(k.Key), 8)] + "****", quota(k.DailyTokens), quota(k.RPM), strconv.Itoa(used), k.Created.Format(time.DateOnly)})
+			}
+			t := table.New().
+				Border(lipgloss.NormalBorder()).
+				Headers("NAME", "KEY", "DAILY TOKENS", "RPM", "USED TODAY", "CREATED").
+				Rows(rows...).
+				StyleFunc(func(row, col int) lipgloss.Style {
+					style := lipgloss.NewStyle().Padding(0, 1)
+					if row == table.HeaderRow {
+						return style.Bold(true)
+					}
+					return style
+				})
+			lipgloss.Println(t)
+			return nil
+		},
+	}
+
+	revoke := &cobra.Command{
+		Use:   "revoke name",
+		Short: "Revoke virtual API key",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			keys, err := server.LoadVirtualKeys(file)
+			if err != nil {
+				return err
+			}
+			n := len(keys)
+			keys = slices.DeleteFunc(keys, func(k server.VirtualKey) bool { return k.Name == args[0] })
+			if len(keys) == n {
+				return fmt.Errorf("virtual key %s not found", args[0])
+			}
+			if err := server.SaveVirtualKeys(file, keys); err != nil {
+				return err
+			}
+			c.Println("virtual key revoked:", args[0])
+			return nil
+		},
+	}
+
+	for _, _cmd := range []*cobra.Command{create, list, revoke} {
+		_cmd.Flags().StringVarP(&file, "file", "f", virtualKeysPath(), "Virtual keys file, pass it to freeglm server --virtual-keys")
+	}
+	return []*cobra.Command{create, list, revoke}
+}
+
+func quota(n int) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(n)
+}
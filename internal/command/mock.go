@@ -0,0 +1,57 @@
+package command
+
+import (
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"freeglm/internal/mock"
+)
+
+func (cmd *Command) mock() *cobra.Command {
+	var listen string
+	_mock := &cobra.Command{
+		Use:   "mock [FIXTURES...]",
+		Short: "Serve canned GLM responses from fixture files as an offline upstream",
+		Long: `Serve chat completions and models like the GLM API from JSON or YAML
+fixture files, to test clients and the proxy or demo them offline
+
+Note:
+	- the first fixture matching "model" glob, "contains" (text of the last user
+	  message) and "stream" answers, requests matching none get 404
+	- without fixtures every request is answered with its last user message
+	- "delay" is ms before headers, "interval" is ms between stream chunks
+	- "error" is answered as GLM error with "status" (400 by default), "body" is sent as is
+	- streams send "reasoning" and "content" word by word or "chunks" as given
+`,
+		Example: `
+freeglm mock fixtures/ --listen 127.0.0.1:5999
+Serve every .json, .yaml and .yml file of fixtures/, for example
+	fixtures:
+	  - match: {model: "glm-4.7*", contains: "hello", stream: true}
+	    reasoning: "The user greets me"
+	    chunks: ["Hel", "lo", "!"]
+	    interval: 200
+	  - match: {contains: "weather"}
+	    tool_calls: [{id: call_1, type: function, function: {name: weather, arguments: "{}"}}]
+	  - match: {contains: "fail"}
+	    status: 429
+	    error: "rate limit reached"
+
+freeglm server --config mock.json
+Send the proxy to the mock with a provider overriding the GLM models
+	{"providers": [{"name": "mock", "base_url": "http://127.0.0.1:5999/api/paas/v4",
+	  "keys": ["mock"], "models": {"glm-4.7": 8192, "glm-4.7-flash": 8192}}]}
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			fixtures, err := mock.Load(args...)
+			if err != nil {
+				return err
+			}
+			c.Printf("start mock: %s (%d fixtures)\n", listen, len(fixtures))
+			return http.ListenAndServe(listen, mock.Handler(fixtures))
+		},
+	}
+	_mock.Flags().StringVarP(&listen, "listen", "l", "127.0.0.1:5999", "Mock listen address")
+	return _mock
+}
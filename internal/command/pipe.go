@@ -0,0 +1,104 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"freeglm/internal/server"
+
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) pipe() *cobra.Command {
+	var (
+		model   string
+		path    string
+		verbose bool
+	)
+	_pipe := &cobra.Command{
+		Use:   "pipe",
+		Short: "Answer one JSON request from stdin on stdout",
+		Long: `Read one OpenAI request from stdin, send it through the proxy pipeline using
+configured keys and write the normalized response to stdout: a JSON object,
+or the SSE stream as it arrives for "stream": true, without any listener
+
+Note:
+	- the exit code is non-zero when the response is an error, its JSON body is still written
+	- keys, providers and aliases are read from ZAI_API_KEY, --config and --keys-file
+	- logs are discarded unless --verbose, they go to stderr then
+`,
+		Example: `
+echo '{"model":"glm-4.7","messages":[{"role":"user","content":"Hi"}]}' | freeglm pipe
+freeglm pipe < request.json > response.json
+freeglm pipe --path /v1/completions < legacy.json
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			opts := server.Options{Model: model, Retries: 2, RetryBackoff: 500, KeyCooldown: 60}
+			if err := cmd.options(c, &opts); err != nil {
+				return err
+			}
+			if !verbose {
+				log.SetOutput(io.Discard)
+			}
+			handler, err := server.Handler(opts)
+			if err != nil {
+				return err
+			}
+			r, err := http.NewRequestWithContext(c.Context(), http.MethodPost, "http://freeglm"+path, c.InOrStdin())
+			if err != nil {
+				return err
+			}
+			r.RemoteAddr = "127.0.0.1:0"
+			r.RequestURI = r.URL.RequestURI()
+			r.Header.Set("Content-Type", "application/json")
+			if key := os.Getenv("ZAI_API_KEY"); key != "" {
+				r.Header.Set("Authorization", "Bearer "+key)
+			}
+			w := &stdoutWriter{header: http.Header{}, out: bufio.NewWriter(c.OutOrStdout())}
+			handler.ServeHTTP(w, r)
+			if err := w.out.Flush(); err != nil {
+				return err
+			}
+			if w.status >= 400 {
+				return fmt.Errorf("request failed: %d %s", w.status, http.StatusText(w.status))
+			}
+			return nil
+		},
+	}
+	_pipe.Flags().StringVarP(&model, "model", "m", "glm-4.7-flash", "Model for requests naming no known model")
+	_pipe.Flags().StringVar(&path, "path", "/v1/chat/completions", "Endpoint the request is sent to")
+	_pipe.Flags().BoolVarP(&verbose, "verbose", "v", false, "Write server logs to stderr")
+	return _pipe
+}
+
+// stdoutWriter writes the response body to stdout, streams are flushed
+// chunk by chunk.
+type stdoutWriter struct {
+	header http.Header
+	out    *bufio.Writer
+	status int
+}
+
+func (w *stdoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *stdoutWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *stdoutWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.out.Write(p)
+}
+
+func (w *stdoutWriter) Flush() {
+	w.out.Flush()
+}
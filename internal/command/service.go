@@ -0,0 +1,117 @@
+package command
+
+import (
+	"os"
+	"path/filepath"
+
+	"freeglm/internal/service"
+
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) service() *cobra.Command {
+	var user bool
+
+	_service := &cobra.Command{
+		Use:   "service",
+		Short: "Manage freeglm system service",
+		Long: `Manage freeglm as systemd unit (Linux), launchd plist (macOS) or Windows service
+
+Note:
+	- flags after "--" are written into the unit as "freeglm server" flags
+	- --config and --keys-file are written as absolute paths
+	- ZAI_API_KEY from current environment is written into the unit
+	- system unit needs root, use --user for the current user unit
+	- Windows service is installed for the machine from Administrator console,
+	  --user is ignored, it starts automatically and restarts after failures,
+	  logs are written into %TEMP%\freeglm.log of the service account
+`,
+		Example: `
+freeglm service install -- --listen 0.0.0.0:5000 --model glm-4.7
+Install and enable service with "freeglm server --listen 0.0.0.0:5000 --model glm-4.7"
+
+freeglm service install --user --config ~/.config/freeglm.json
+Install user service with config
+
+freeglm service start
+freeglm service stop
+freeglm service uninstall
+`,
+		RunE: func(c *cobra.Command, args []string) error {
+			return c.Help()
+		},
+	}
+	_service.PersistentFlags().BoolVarP(&user, "user", "u", os.Geteuid() != 0, "Manage user service instead of system service")
+
+	_service.AddCommand(
+		&cobra.Command{
+			Use:   "install [-- server flags]",
+			Short: "Install and enable service",
+			RunE: func(c *cobra.Command, args []string) error {
+				serverArgs := []string{"server"}
+				for _, flag := range [][2]string{{"--config", cmd.config}, {"--keys-file", cmd.keysFile}} {
+					if flag[1] == "" {
+						continue
+					}
+					abs, err := filepath.Abs(flag[1])
+					if err != nil {
+						return err
+					}
+					serverArgs = append(serverArgs, flag[0], abs)
+				}
+				_svc, err := service.New(append(serverArgs, args...), user)
+				if err != nil {
+					return err
+				}
+				path, err := _svc.Install()
+				if err != nil {
+					return err
+				}
+				c.Println("service installed:", path)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "uninstall",
+			Short: "Stop, disable and remove service",
+			Args:  cobra.NoArgs,
+			RunE: func(c *cobra.Command, args []string) error {
+				_svc, err := service.New(nil, user)
+				if err != nil {
+					return err
+				}
+				path, err := _svc.Uninstall()
+				if err != nil {
+					return err
+				}
+				c.Println("service removed:", path)
+				return nil
+			},
+		},
+		&cobra.Command{
+			Use:   "start",
+			Short: "Start service",
+			Args:  cobra.NoArgs,
+			RunE: func(c *cobra.Command, args []string) error {
+				_svc, err := service.New(nil, user)
+				if err != nil {
+					return err
+				}
+				return _svc.Start()
+			},
+		},
+		&cobra.Command{
+			Use:   "stop",
+			Short: "Stop service",
+			Args:  cobra.NoArgs,
+			RunE: func(c *cobra.Command, args []string) error {
+				_svc, err := service.New(nil, user)
+				if err != nil {
+					return err
+				}
+				return _svc.Stop()
+			},
+		},
+	)
+	return _service
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package command
+
+import (
+	"freeglm/internal/server"
+
+	"github.com/spf13/cobra"
+)
+
+// handleSignals is a no-op outside of unix, the Windows service stops the
+// server through service.Serve.
+func handleSignals(c *cobra.Command, _server *server.Server, shutdown func()) {}
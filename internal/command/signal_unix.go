@@ -0,0 +1,35 @@
+//go:build unix
+
+package command
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"freeglm/internal/server"
+
+	"github.com/spf13/cobra"
+)
+
+// handleSignals drains the server on SIGTERM, on SIGUSR2 it first hands the
+// listener to a new process started from the (replaced) executable.
+func handleSignals(c *cobra.Command, _server *server.Server, shutdown func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR2 {
+				pid, err := _server.Upgrade()
+				if err != nil {
+					c.Println("upgrade error:", err)
+					continue
+				}
+				c.Printf("upgraded to pid %d, draining active requests\n", pid)
+			}
+			signal.Stop(signals)
+			shutdown()
+			return
+		}
+	}()
+}
@@ -0,0 +1,169 @@
+package command
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"freeglm/internal/usagedb"
+
+	"charm.land/lipgloss/v2"
+	"charm.land/lipgloss/v2/table"
+	"github.com/spf13/cobra"
+)
+
+func usageDBPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "usage.db"
+	}
+	return filepath.Join(dir, "freeglm", "usage.db")
+}
+
+func (cmd *Command) usage() *cobra.Command {
+	var (
+		path   string
+		days   int
+		weekly bool
+	)
+	_usage := &cobra.Command{
+		Use:   "usage",
+		Short: "Report token usage per day or week, key and model",
+		Long: `Print token usage recorded by "freeglm server --usage-db" per day or
+week, key and model with totals per key
+
+Note:
+	- days and weeks are UTC, weeks start on Monday
+	- keys are shown by id, the hash they are listed under in /stats
+	- requests failed upstream are not counted
+`,
+		Example: `
+freeglm server --usage-db ~/.config/freeglm/usage.db
+freeglm usage
+freeglm usage --days 1
+freeglm usage --weekly --days 90
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			if _, err := os.Stat(path); err != nil {
+				return fmt.Errorf("usage db %s: %w (run server with --usage-db)", path, err)
+			}
+			if weekly && !c.Flags().Changed("days") {
+				days = 28
+			}
+			store, err := usagedb.Open(path)
+			if err != nil {
+				return err
+			}
+			defer store.Close()
+			since := time.Now().UTC().AddDate(0, 0, 1-max(1, days))
+			rows, err := store.Rows(since)
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				fmt.Fprintf(c.OutOrStdout(), "no usage since %s\n", since.Format(time.DateOnly))
+				return nil
+			}
+			period := "DAY"
+			if weekly {
+				rows, period = byWeek(rows), "WEEK"
+			}
+			lipgloss.Println(usageTable([]string{period, "KEY", "MODEL"}, rows, func(r usagedb.Row) []string {
+				return []string{r.Day, r.Key, r.Model}
+			}))
+
+			totals := map[string]usagedb.Row{}
+			for _, r := range rows {
+				totals[r.Key] = addUsage(totals[r.Key], r)
+			}
+			keys := make([]usagedb.Row, 0, len(totals))
+			for _, key := range slices.Sorted(maps.Keys(totals)) {
+				t := totals[key]
+				t.Key = key
+				keys = append(keys, t)
+			}
+			lipgloss.Println(usageTable([]string{"KEY"}, keys, func(r usagedb.Row) []string {
+				return []string{r.Key}
+			}))
+			return nil
+		},
+	}
+	_usage.Flags().StringVarP(&path, "db", "f", usageDBPath(), "SQLite file written by server --usage-db")
+	_usage.Flags().IntVarP(&days, "days", "d", 7, "Report this many last days including today (28 with --weekly)")
+	_usage.Flags().BoolVarP(&weekly, "weekly", "w", false, "Sum days into weeks")
+	return _usage
+}
+
+// byWeek sums rows into weeks named by their Monday.
+func byWeek(rows []usagedb.Row) []usagedb.Row {
+	var out []usagedb.Row
+	index := map[[3]string]int{}
+	for _, r := range rows {
+		day, err := time.Parse(time.DateOnly, r.Day)
+		if err != nil {
+			continue
+		}
+		r.Day = day.AddDate(0, 0, -(int(day.Weekday())+6)%7).Format(time.DateOnly)
+		id := [3]string{r.Day, r.Key, r.Model}
+		if i, ok := index[id]; ok {
+			out[i] = addUsage(out[i], r)
+			continue
+		}
+		index[id] = len(out)
+		out = append(out, r)
+	}
+	slices.SortFunc(out, func(a, b usagedb.Row) int {
+		return cmp.Or(strings.Compare(a.Day, b.Day), strings.Compare(a.Key, b.Key), strings.Compare(a.Model, b.Model))
+	})
+	return out
+}
+
+func addUsage(a, b usagedb.Row) usagedb.Row {
+	a.Requests += b.Requests
+	a.Prompt += b.Prompt
+	a.Completion += b.Completion
+	a.Cached += b.Cached
+	return a
+}
+
+// usageTable lists rows with token columns after the label columns and a
+// total row at the bottom.
+func usageTable(labels []string, rows []usagedb.Row, label func(usagedb.Row) []string) *table.Table {
+	var total usagedb.Row
+	data := make([][]string, 0, len(rows)+1)
+	for _, r := range rows {
+		total = addUsage(total, r)
+		data = append(data, append(label(r), usageColumns(r)...))
+	}
+	last := make([]string, len(labels))
+	last[0] = "TOTAL"
+	data = append(data, append(last, usageColumns(total)...))
+	return table.New().
+		Border(lipgloss.NormalBorder()).
+		Headers(append(slices.Clone(labels), "REQUESTS", "PROMPT", "COMPLETION", "CACHED", "TOTAL")...).
+		Rows(data...).
+		StyleFunc(func(row, col int) lipgloss.Style {
+			style := lipgloss.NewStyle().Padding(0, 1)
+			if row == table.HeaderRow || row == len(data)-1 {
+				return style.Bold(true)
+			}
+			return style
+		})
+}
+
+func usageColumns(r usagedb.Row) []string {
+	return []string{
+		strconv.Itoa(r.Requests),
+		strconv.Itoa(r.Prompt),
+		strconv.Itoa(r.Completion),
+		strconv.Itoa(r.Cached),
+		strconv.Itoa(r.Total()),
+	}
+}
@@ -0,0 +1,77 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"freeglm/internal/version"
+	"freeglm/pkg/freeglmclient"
+
+	"github.com/spf13/cobra"
+)
+
+func (cmd *Command) version() *cobra.Command {
+	var (
+		asJSON bool
+		target string
+		apiKey string
+	)
+	_version := &cobra.Command{
+		Use:   "version",
+		Short: "Print version, commit and build date",
+		Long: `Print build metadata of this binary, or of a running proxy with --target
+together with the features it serves, as GET /version answers them
+
+Note:
+	- version, commit and date are set with -ldflags -X freeglm/internal/version.Version=...,
+	  builds without them use the VCS stamp of go build
+	- api is a number growing with new endpoints and request fields, compare it
+	  or check features (enabled by server flags) to tell what a proxy supports
+`,
+		Example: `
+freeglm version
+freeglm version --json
+freeglm version --target http://127.0.0.1:5000/v1
+curl -s http://127.0.0.1:5000/version | jq .features
+`,
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			out := c.OutOrStdout()
+			var v any = version.Get()
+			if target != "" {
+				client := freeglmclient.New(freeglmclient.Config{BaseURL: target, APIKey: apiKey, Retries: -1})
+				remote, err := client.Version(c.Context())
+				if err != nil {
+					return err
+				}
+				v = remote
+			}
+			if asJSON {
+				encoder := json.NewEncoder(out)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(v)
+			}
+			switch v := v.(type) {
+			case version.Info:
+				fmt.Fprintln(out, v)
+			case *freeglmclient.Version:
+				fmt.Fprintf(out, "freeglm %s (%s, %s, %s %s), api %d\n", v.Version, v.Commit, v.Date, v.Go, v.Platform, v.API)
+				var enabled []string
+				for _, feature := range slices.Sorted(maps.Keys(v.Features)) {
+					if v.Features[feature] {
+						enabled = append(enabled, feature)
+					}
+				}
+				fmt.Fprintln(out, "features:", strings.Join(enabled, ", "))
+			}
+			return nil
+		},
+	}
+	_version.Flags().BoolVar(&asJSON, "json", false, "Print as JSON")
+	_version.Flags().StringVar(&target, "target", "", "Base URL of a running proxy to ask for its version")
+	_version.Flags().StringVar(&apiKey, "api-key", "", "API key for --target")
+	return _version
+}
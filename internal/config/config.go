@@ -1,13 +1,89 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"strings"
 )
 
+type Provider struct {
+	Name      string         `json:"name"`
+	BaseURL   string         `json:"base_url"`
+	Fallbacks []string       `json:"fallback_urls"`
+	Keys      []string       `json:"keys"`
+	Models    map[string]int `json:"models"`
+	Vision    []string       `json:"vision"`
+	Context   map[string]int `json:"context"`
+	// PromptCache models get cache_control marks forwarded.
+	PromptCache []string `json:"prompt_cache"`
+	// Audio models are served on /audio/transcriptions and /audio/speech.
+	Audio []string `json:"audio"`
+	// Images models are served on /images/generations.
+	Images []string `json:"images"`
+}
+
+type Profile struct {
+	Tokens      []string `json:"tokens"`
+	Reasoning   string   `json:"reasoning"`
+	ToolRepair  bool     `json:"tool_repair"`
+	StripParams []string `json:"strip_params"`
+}
+
+// Transport tunes connections to upstreams, zero values keep Go defaults.
+type Transport struct {
+	MaxIdleConnsPerHost int  `json:"max_idle_conns_per_host"`
+	IdleConnTimeout     int  `json:"idle_conn_timeout"`
+	HTTP2               bool `json:"http2"`
+	DisableKeepAlives   bool `json:"disable_keep_alives"`
+}
+
+// Rewrite changes payloads of requests matching all of Match.
+type Rewrite struct {
+	Match         RewriteMatch               `json:"match"`
+	Set           map[string]json.RawMessage `json:"set"`
+	Override      map[string]json.RawMessage `json:"override"`
+	Remove        []string                   `json:"remove"`
+	PrependSystem string                     `json:"prepend_system"`
+	AppendSystem  string                     `json:"append_system"`
+}
+
+type RewriteMatch struct {
+	Model  string            `json:"model"`
+	Path   string            `json:"path"`
+	Header map[string]string `json:"header"`
+}
+
+// Template is a persona model ID served by Model with its prompt.
+type Template struct {
+	Model          string          `json:"model"`
+	System         string          `json:"system"`
+	User           string          `json:"user"`
+	ResponseFormat json.RawMessage `json:"response_format"`
+}
+
+// Tenant is a logical proxy selected by Host header or path prefix.
+type Tenant struct {
+	Hosts       []string          `json:"hosts"`
+	Prefix      string            `json:"prefix"`
+	Keys        []string          `json:"keys"`
+	Providers   []Provider        `json:"providers"`
+	Aliases     map[string]string `json:"aliases"`
+	DailyTokens int               `json:"daily_tokens"`
+	RPM         int               `json:"rpm"`
+}
+
 type Config struct {
-	Keys []string
+	Keys      []string            `json:"keys"`
+	Providers []Provider          `json:"providers"`
+	Profiles  map[string]Profile  `json:"profiles"`
+	Aliases   map[string]string   `json:"aliases"`
+	Transport *Transport          `json:"transport"`
+	Rewrites  []Rewrite           `json:"rewrites"`
+	Templates map[string]Template `json:"templates"`
+	Tenants   map[string]Tenant   `json:"tenants"`
+	Storage   string              `json:"storage"`
 }
 
 func New() (*Config, error) {
@@ -20,3 +96,73 @@ func New() (*Config, error) {
 		Keys: strings.Split(_key, ","),
 	}, nil
 }
+
+func (c *Config) Load(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config: %w", err)
+	}
+	var file Config
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("parse config %s: %w", path, err)
+	}
+	c.Keys = append(c.Keys, file.Keys...)
+	c.Providers = append(c.Providers, file.Providers...)
+	c.Rewrites = append(c.Rewrites, file.Rewrites...)
+	if len(file.Profiles) != 0 {
+		if c.Profiles == nil {
+			c.Profiles = map[string]Profile{}
+		}
+		maps.Copy(c.Profiles, file.Profiles)
+	}
+	if len(file.Templates) != 0 {
+		if c.Templates == nil {
+			c.Templates = map[string]Template{}
+		}
+		maps.Copy(c.Templates, file.Templates)
+	}
+	if len(file.Tenants) != 0 {
+		if c.Tenants == nil {
+			c.Tenants = map[string]Tenant{}
+		}
+		maps.Copy(c.Tenants, file.Tenants)
+	}
+	if file.Transport != nil {
+		c.Transport = file.Transport
+	}
+	if file.Storage != "" {
+		c.Storage = file.Storage
+	}
+	if len(file.Aliases) != 0 {
+		if c.Aliases == nil {
+			c.Aliases = map[string]string{}
+		}
+		maps.Copy(c.Aliases, file.Aliases)
+	}
+	return nil
+}
+
+func (c *Config) LoadKeys(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read keys: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, key := range strings.Split(line, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				c.Keys = append(c.Keys, key)
+			}
+		}
+	}
+	return nil
+}
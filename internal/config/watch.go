@@ -0,0 +1,41 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+func Watch(ctx context.Context, interval time.Duration, fn func(), paths ...string) {
+	mtimes := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		mtimes[path] = mtime(path)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed := false
+		for _, path := range paths {
+			if t := mtime(path); !t.Equal(mtimes[path]) {
+				mtimes[path] = t
+				changed = true
+			}
+		}
+		if changed {
+			fn()
+		}
+	}
+}
+
+func mtime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
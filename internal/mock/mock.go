@@ -0,0 +1,327 @@
+package mock
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Fixture is a canned response for requests matching Match. Body is sent
+// as is, Error as a GLM error, otherwise Content, Reasoning and ToolCalls
+// are answered as a completion or a stream of Chunks (words of Content by
+// default) every Interval ms.
+type Fixture struct {
+	Match Match `json:"match"`
+	// Status defaults to 200, or 400 with Error.
+	Status int `json:"status"`
+	// Delay is ms before response headers.
+	Delay        int             `json:"delay"`
+	Interval     int             `json:"interval"`
+	Content      string          `json:"content"`
+	Reasoning    string          `json:"reasoning"`
+	Chunks       []string        `json:"chunks"`
+	ToolCalls    json.RawMessage `json:"tool_calls"`
+	FinishReason string          `json:"finish_reason"`
+	Usage        json.RawMessage `json:"usage"`
+	Body         json.RawMessage `json:"body"`
+	Error        string          `json:"error"`
+}
+
+// Match is a model glob, a substring of the last user message and the
+// stream flag, empty ones match anything.
+type Match struct {
+	Model    string `json:"model"`
+	Contains string `json:"contains"`
+	Stream   *bool  `json:"stream"`
+}
+
+func (m Match) matches(model, text string, stream bool) bool {
+	if m.Model != "" {
+		if ok, _ := path.Match(m.Model, model); !ok {
+			return false
+		}
+	}
+	return strings.Contains(text, m.Contains) && (m.Stream == nil || *m.Stream == stream)
+}
+
+// Load reads fixtures from JSON or YAML files, a directory adds its .json,
+// .yaml and .yml files by name. A file holds one fixture, a list of them or
+// an object with "fixtures" list.
+func Load(paths ...string) ([]Fixture, error) {
+	var fixtures []Fixture
+	for _, p := range paths {
+		files := []string{p}
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return nil, err
+			}
+			files = nil
+			for _, entry := range entries {
+				if ext := filepath.Ext(entry.Name()); !entry.IsDir() && slices.Contains([]string{".json", ".yaml", ".yml"}, ext) {
+					files = append(files, filepath.Join(p, entry.Name()))
+				}
+			}
+		}
+		for _, file := range files {
+			loaded, err := loadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("fixtures %s: %w", file, err)
+			}
+			fixtures = append(fixtures, loaded...)
+		}
+	}
+	return fixtures, nil
+}
+
+func loadFile(file string) ([]Fixture, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// JSON is YAML, both are read through JSON for the field names
+	var doc any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if m, ok := doc.(map[string]any); ok && m["fixtures"] != nil {
+		doc = m["fixtures"]
+	}
+	if _, ok := doc.([]any); !ok {
+		doc = []any{doc}
+	}
+	if data, err = json.Marshal(doc); err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+type handler struct {
+	fixtures []Fixture
+}
+
+// Handler serves fixtures on the GLM upstream interface: POST
+// .../chat/completions and GET .../models. Without fixtures every request is
+// answered with its last user message.
+func Handler(fixtures []Fixture) http.Handler {
+	return &handler{fixtures: fixtures}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/models"):
+		h.models(w)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		h.chat(w, r)
+	default:
+		sendError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (h *handler) models(w http.ResponseWriter) {
+	models := []string{"glm-4.7", "glm-4.7-flash"}
+	for _, f := range h.fixtures {
+		if f.Match.Model != "" && !strings.ContainsAny(f.Match.Model, "*?[") && !slices.Contains(models, f.Match.Model) {
+			models = append(models, f.Match.Model)
+		}
+	}
+	data := make([]map[string]any, 0, len(models))
+	for _, model := range models {
+		data = append(data, map[string]any{"id": model, "object": "model", "created": 1700000000, "owned_by": "mock"})
+	}
+	sendJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+type request struct {
+	Model    string `json:"model"`
+	Stream   bool   `json:"stream"`
+	Messages []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+func (h *handler) chat(w http.ResponseWriter, r *http.Request) {
+	var req request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	var text string
+	for _, msg := range req.Messages {
+		if msg.Role == "user" {
+			text = contentText(msg.Content)
+		}
+	}
+	fixture := Fixture{Content: text}
+	if len(h.fixtures) != 0 {
+		idx := slices.IndexFunc(h.fixtures, func(f Fixture) bool { return f.Match.matches(req.Model, text, req.Stream) })
+		if idx < 0 {
+			sendError(w, http.StatusNotFound, fmt.Sprintf("no fixture matches model %s", req.Model))
+			return
+		}
+		fixture = h.fixtures[idx]
+	}
+	if fixture.Delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(time.Duration(fixture.Delay) * time.Millisecond):
+		}
+	}
+	switch {
+	case fixture.Error != "":
+		sendError(w, cmp.Or(fixture.Status, http.StatusBadRequest), fixture.Error)
+	case len(fixture.Body) != 0:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(cmp.Or(fixture.Status, http.StatusOK))
+		w.Write(fixture.Body)
+	case req.Stream:
+		stream(w, r, req.Model, text, fixture)
+	default:
+		message := map[string]any{"role": "assistant", "content": fixture.Content}
+		if fixture.Reasoning != "" {
+			message["reasoning_content"] = fixture.Reasoning
+		}
+		if len(fixture.ToolCalls) != 0 {
+			message["tool_calls"] = fixture.ToolCalls
+		}
+		sendJSON(w, cmp.Or(fixture.Status, http.StatusOK), map[string]any{
+			"id":      "mock-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+			"created": time.Now().Unix(),
+			"model":   req.Model,
+			"choices": []any{map[string]any{"index": 0, "message": message, "finish_reason": fixture.finishReason()}},
+			"usage":   fixture.usage(text),
+		})
+	}
+}
+
+// stream sends reasoning and content word by word (or Chunks), tool calls
+// in one chunk and usage with the last chunk.
+func stream(w http.ResponseWriter, r *http.Request, model, text string, fixture Fixture) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(cmp.Or(fixture.Status, http.StatusOK))
+	flusher, _ := w.(http.Flusher)
+	id := "mock-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	send := func(delta map[string]any, last bool) bool {
+		choice := map[string]any{"index": 0, "delta": delta}
+		chunk := map[string]any{"id": id, "created": time.Now().Unix(), "model": model, "choices": []any{choice}}
+		if last {
+			choice["finish_reason"] = fixture.finishReason()
+			chunk["usage"] = fixture.usage(text)
+		}
+		data, _ := json.Marshal(chunk)
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if fixture.Interval > 0 && !last {
+			select {
+			case <-r.Context().Done():
+				return false
+			case <-time.After(time.Duration(fixture.Interval) * time.Millisecond):
+			}
+		}
+		return true
+	}
+	var deltas []map[string]any
+	for _, part := range words(fixture.Reasoning) {
+		deltas = append(deltas, map[string]any{"role": "assistant", "reasoning_content": part})
+	}
+	chunks := fixture.Chunks
+	if chunks == nil {
+		chunks = words(fixture.Content)
+	}
+	for _, part := range chunks {
+		deltas = append(deltas, map[string]any{"role": "assistant", "content": part})
+	}
+	if len(fixture.ToolCalls) != 0 {
+		deltas = append(deltas, map[string]any{"role": "assistant", "tool_calls": fixture.ToolCalls})
+	}
+	for _, delta := range deltas {
+		if !send(delta, false) {
+			return
+		}
+	}
+	if send(map[string]any{"role": "assistant", "content": ""}, true) {
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}
+}
+
+func (f Fixture) finishReason() string {
+	switch {
+	case f.FinishReason != "":
+		return f.FinishReason
+	case len(f.ToolCalls) != 0:
+		return "tool_calls"
+	}
+	return "stop"
+}
+
+// usage is Usage or about four characters per token of the prompt and the
+// answer.
+func (f Fixture) usage(prompt string) any {
+	if len(f.Usage) != 0 {
+		return f.Usage
+	}
+	promptTokens := len(prompt)/4 + 1
+	completionTokens := (len(f.Reasoning)+len(f.Content))/4 + 1
+	return map[string]int{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"total_tokens":      promptTokens + completionTokens,
+	}
+}
+
+func words(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.SplitAfter(text, " ")
+}
+
+func contentText(raw json.RawMessage) string {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	json.Unmarshal(raw, &parts)
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func sendError(w http.ResponseWriter, status int, message string) {
+	sendJSON(w, status, map[string]any{"error": map[string]any{"code": strconv.Itoa(status), "message": message}})
+}
+
+func sendJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
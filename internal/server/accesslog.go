@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accessCommon   = "common"
+	accessCombined = "combined"
+	accessJSON     = "json"
+)
+
+type accessLog struct {
+	next   http.Handler
+	format string
+	mu     sync.Mutex
+	out    io.Writer
+}
+
+func newAccessLog(next http.Handler, format string, out io.Writer) (http.Handler, error) {
+	switch format {
+	case "":
+		return next, nil
+	case accessCommon, accessCombined, accessJSON:
+		return &accessLog{next: next, format: format, out: out}, nil
+	}
+	return nil, fmt.Errorf("access log format must be one of [%s %s %s]", accessCommon, accessCombined, accessJSON)
+}
+
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func (a *accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w}
+	a.next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	duration := time.Since(start)
+
+	var line []byte
+	switch a.format {
+	case accessJSON:
+		line, _ = marshal(map[string]any{
+			"time":       start.Format(time.RFC3339Nano),
+			"client":     clientIP(r),
+			"method":     r.Method,
+			"path":       r.URL.RequestURI(),
+			"proto":      r.Proto,
+			"status":     rec.status,
+			"bytes":      rec.bytes,
+			"duration":   duration.Seconds(),
+			"referer":    r.Referer(),
+			"user_agent": r.UserAgent(),
+			"request_id": rec.Header().Get(requestIDHeader),
+		})
+	default:
+		size := "-"
+		if rec.bytes > 0 {
+			size = fmt.Sprint(rec.bytes)
+		}
+		entry := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+			clientIP(r), start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.URL.RequestURI(), r.Proto, rec.status, size)
+		if a.format == accessCombined {
+			entry += fmt.Sprintf(" %q %q", orDash(r.Referer()), orDash(r.UserAgent()))
+		}
+		line = fmt.Appendf(nil, "%s %.3f %s", entry, duration.Seconds(), orDash(rec.Header().Get(requestIDHeader)))
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.out.Write(append(line, '\n'))
+}
+
+func orDash(s string) string {
+	if strings.TrimSpace(s) == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// admin holds the keys added and removed with /admin/keys and the models
+// discovered from upstream, they are applied on top of options from flags
+// and config so reloads keep them.
+type admin struct {
+	token string
+
+	mu         sync.Mutex
+	opts       Options
+	added      map[string][]string
+	removed    map[string]bool
+	discovered map[string][]string
+}
+
+type keysEdit struct {
+	Provider string   `json:"provider"`
+	Add      []string `json:"add"`
+	Remove   []string `json:"remove"`
+}
+
+func newAdmin(opts Options) *admin {
+	return &admin{token: opts.AdminToken, opts: opts, added: map[string][]string{}, removed: map[string]bool{}}
+}
+
+// apply returns opts with runtime key edits and discovered models, shared
+// keys are kept under "" provider name.
+func (a *admin) apply(opts Options) Options {
+	edit := func(name string, list []string) []string {
+		out := slices.DeleteFunc(slices.Clone(list), func(key string) bool { return a.removed[key] })
+		for _, key := range a.added[name] {
+			if !slices.Contains(out, key) {
+				out = append(out, key)
+			}
+		}
+		return out
+	}
+	opts.Keys = edit("", opts.Keys)
+	opts.Providers = slices.Clone(opts.Providers)
+	for i, p := range opts.Providers {
+		p.Keys = edit(p.Name, p.Keys)
+		opts.Providers[i] = p
+	}
+	opts.discovered = a.discovered
+	return opts
+}
+
+func (h *handler) reload(opts Options) error {
+	h.admin.mu.Lock()
+	defer h.admin.mu.Unlock()
+	_routes, err := newRoutes(h.admin.apply(opts), h.store)
+	if err != nil {
+		return err
+	}
+	h.admin.opts = opts
+	h.routes.Store(_routes)
+	return nil
+}
+
+func (h *handler) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if h.admin.token == "" {
+		h.sendErrorJSON(w, http.StatusNotFound, "Admin API is disabled, run server with --admin-token")
+		return false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.admin.token)) != 1 {
+		h.sendErrorJSON(w, http.StatusUnauthorized, "Invalid admin token")
+		return false
+	}
+	return true
+}
+
+func (h *handler) handleAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		payload, ok := h.decodeBody(w, r)
+		if !ok {
+			return
+		}
+		var edit keysEdit
+		if err := json.Unmarshal(mustMarshal(payload), &edit); err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, "provider must be a string, add and remove arrays of strings")
+			return
+		}
+		if err := h.editKeys(edit); err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	h.admin.mu.Lock()
+	opts := h.admin.apply(h.admin.opts)
+	h.admin.mu.Unlock()
+	pools := map[string][]string{"": opts.Keys}
+	for _, p := range opts.Providers {
+		pools[p.Name] = p.Keys
+	}
+	data := make([]map[string]any, 0, len(pools))
+	for _, name := range slices.Sorted(maps.Keys(pools)) {
+		masked := make([]string, 0, len(pools[name]))
+		for _, key := range pools[name] {
+			masked = append(masked, maskKey(key))
+		}
+		data = append(data, map[string]any{"provider": name, "keys": masked})
+	}
+	h.sendJSON(w, http.StatusOK, map[string]any{"object": "list", "data": data})
+}
+
+// editKeys adds and removes keys of the provider ("" for shared keys) and
+// swaps the routes, requests in flight keep the pool they picked a key from.
+func (h *handler) editKeys(edit keysEdit) error {
+	h.admin.mu.Lock()
+	defer h.admin.mu.Unlock()
+	if edit.Provider != "" && !slices.ContainsFunc(h.admin.opts.Providers, func(p Provider) bool { return p.Name == edit.Provider }) {
+		return fmt.Errorf("provider %s is not configured, leave it empty for shared keys", edit.Provider)
+	}
+	for _, key := range edit.Remove {
+		h.admin.removed[strings.TrimSpace(key)] = true
+		for name, keys := range h.admin.added {
+			h.admin.added[name] = slices.DeleteFunc(keys, func(k string) bool { return k == strings.TrimSpace(key) })
+		}
+	}
+	for _, key := range edit.Add {
+		if key = strings.TrimSpace(key); key == "" {
+			continue
+		}
+		delete(h.admin.removed, key)
+		if !slices.Contains(h.admin.added[edit.Provider], key) {
+			h.admin.added[edit.Provider] = append(h.admin.added[edit.Provider], key)
+		}
+	}
+	_routes, err := newRoutes(h.admin.apply(h.admin.opts), h.store)
+	if err != nil {
+		return err
+	}
+	h.routes.Store(_routes)
+	return nil
+}
+
+// reset targets of /admin/reset
+const (
+	resetKeys      = "keys"
+	resetUsage     = "usage"
+	resetCache     = "cache"
+	resetUpstreams = "upstreams"
+)
+
+var resetTargets = []string{resetKeys, resetUsage, resetCache, resetUpstreams}
+
+type resetRequest struct {
+	Targets []string `json:"targets"`
+	Key     string   `json:"key"`
+}
+
+// handleAdminReset clears runtime state without restart: key cooldowns and
+// strategy counters, usage stats, response cache, upstreams marked down and open circuits.
+// An empty target list resets everything, "key" limits "keys" to one key.
+func (h *handler) handleAdminReset(w http.ResponseWriter, r *http.Request) {
+	if !h.authorizeAdmin(w, r) {
+		return
+	}
+	var reset resetRequest
+	if r.ContentLength != 0 {
+		payload, ok := h.decodeBody(w, r)
+		if !ok {
+			return
+		}
+		if err := json.Unmarshal(mustMarshal(payload), &reset); err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, "targets must be an array of strings, key a string")
+			return
+		}
+	}
+	if len(reset.Targets) == 0 {
+		reset.Targets = resetTargets
+	}
+	for _, target := range reset.Targets {
+		if !slices.Contains(resetTargets, target) {
+			h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("targets must be some of %v", resetTargets))
+			return
+		}
+	}
+	for _, target := range reset.Targets {
+		switch target {
+		case resetKeys:
+			if key := strings.TrimSpace(reset.Key); key != "" {
+				h.cooldowns.reset(key)
+				continue
+			}
+			h.cooldowns.reset("")
+			if err := resetShared(h.store); err != nil {
+				h.sendErrorJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			h.admin.mu.Lock()
+			_routes, err := newRoutes(h.admin.apply(h.admin.opts), h.store)
+			if err == nil {
+				h.routes.Store(_routes)
+			}
+			h.admin.mu.Unlock()
+			if err != nil {
+				h.sendErrorJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		case resetUsage:
+			h.stats.reset()
+		case resetCache:
+			if h.cache != nil {
+				h.cache.reset()
+			}
+		case resetUpstreams:
+			h.upstreams.reset()
+			h.breakers.reset()
+		}
+	}
+	logf(r.Context(), "admin reset %s", strings.Join(reset.Targets, ", "))
+	h.sendJSON(w, http.StatusOK, map[string]any{"reset": reset.Targets})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash/fnv"
+	"net/http"
+	"slices"
+)
+
+const sessionHeader = "X-Freeglm-Session"
+
+// session identifies the conversation of a request for sticky keys: the
+// header value, or with --session-affinity the hash of the first system and
+// user messages which stay the same in every turn. Empty is no affinity.
+func (h *handler) session(r *http.Request, payload map[string]json.RawMessage) string {
+	if id := r.Header.Get(sessionHeader); id != "" {
+		return id
+	}
+	if !h.affinity {
+		return ""
+	}
+	var system, user json.RawMessage
+	for _, msg := range decodeArray(payload["messages"]) {
+		switch stringValue(msg["role"], "") {
+		case "system", "developer":
+			if system == nil {
+				system = msg["content"]
+			}
+		case "user":
+			user = msg["content"]
+		}
+		if user != nil {
+			break
+		}
+	}
+	if user == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	json.Compact(&buf, system)
+	buf.WriteByte(0)
+	json.Compact(&buf, user)
+	sum := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(sum[:8])
+}
+
+// sticky picks the key of a session by rendezvous hashing, so every replica
+// sends a conversation with the same key and a removed key moves only its
+// own sessions. A key cooling down passes the session to the next one.
+func (h *handler) sticky(pool keys, session string) string {
+	if session != "" {
+		ranked := slices.SortedFunc(slices.Values(pool.all()), func(a, b string) int {
+			return cmp.Compare(affinity(session, b), affinity(session, a))
+		})
+		for _, key := range ranked {
+			if h.resting(key) == 0 {
+				return key
+			}
+		}
+	}
+	key, _ := h.pick(pool)
+	return key
+}
+
+func affinity(session, key string) uint64 {
+	hash := fnv.New64a()
+	hash.Write([]byte(session))
+	hash.Write([]byte{0})
+	hash.Write([]byte(key))
+	return hash.Sum64()
+}
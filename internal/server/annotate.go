@@ -0,0 +1,38 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	upstreamStatusHeader = "X-Freeglm-Upstream-Status"
+	keyIndexHeader       = "X-Freeglm-Key-Index"
+	latencyHeader        = "X-Freeglm-Latency-Ms"
+	modelHeader          = "X-Freeglm-Model"
+)
+
+// annotate tells the client how the request was served before the response
+// is written: upstream model, status and latency up to response headers.
+// The key is sent as a short hash so keys can be told apart but not read.
+func annotate(w http.ResponseWriter, c *call, resp *http.Response) {
+	header := w.Header()
+	header.Set(modelHeader, c.model)
+	if c.token != "" {
+		header.Set(keyIndexHeader, keyHash(c.token))
+	}
+	if !c.start.IsZero() {
+		header.Set(latencyHeader, strconv.FormatInt(time.Since(c.start).Milliseconds(), 10))
+	}
+	if resp != nil {
+		header.Set(upstreamStatusHeader, strconv.Itoa(resp.StatusCode))
+	}
+}
+
+func keyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	glmASR = "glm-asr-2512"
+	glmTTS = "glm-tts"
+)
+
+// openAIAudio maps OpenAI audio models to GLM ones.
+var openAIAudio = map[string]string{
+	"whisper-1":              glmASR,
+	"gpt-4o-transcribe":      glmASR,
+	"gpt-4o-mini-transcribe": glmASR,
+	"tts-1":                  glmTTS,
+	"tts-1-hd":               glmTTS,
+	"gpt-4o-mini-tts":        glmTTS,
+}
+
+// openAIVoices are dropped from speech requests, upstream uses its default
+// voice instead.
+var openAIVoices = map[string]bool{
+	"alloy": true, "ash": true, "ballad": true, "coral": true, "echo": true, "fable": true,
+	"nova": true, "onyx": true, "sage": true, "shimmer": true, "verse": true,
+}
+
+func isAudioPath(path string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(path, "/v1"), "/audio/")
+}
+
+// audioModel resolves the requested audio model, unknown models fall back
+// to fallback like unknown chat models fall back to glm-4.7-flash.
+func (rt *routes) audioModel(name, fallback string) (string, GLMConfig) {
+	if model, ok := openAIAudio[name]; ok {
+		name = model
+	}
+	if config, ok := rt.audio[name]; ok {
+		return name, config
+	}
+	return fallback, rt.audio[fallback]
+}
+
+func (h *handler) handleAudio(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimPrefix(r.URL.Path, "/v1") {
+	case "/audio/transcriptions":
+		h.handleTranscription(w, r)
+	case "/audio/speech":
+		h.handleSpeech(w, r)
+	default:
+		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleTranscription rebuilds the multipart upload with the GLM model name
+// and forwards it to the ASR endpoint.
+func (h *handler) handleTranscription(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if h.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		h.sendErrorJSON(w, http.StatusBadRequest, "Content-Type must be multipart/form-data")
+		return
+	}
+	var (
+		body      bytes.Buffer
+		requested string
+		format    string
+		file      bool
+	)
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	writer := multipart.NewWriter(&body)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			switch part.FormName() {
+			case "model", "response_format":
+				var value []byte
+				value, err = io.ReadAll(io.LimitReader(part, 1<<10))
+				if part.FormName() == "model" {
+					requested = strings.TrimSpace(string(value))
+				} else {
+					format = strings.TrimSpace(string(value))
+				}
+				continue
+			case "file":
+				file = true
+			}
+			var dst io.Writer
+			if dst, err = writer.CreatePart(part.Header); err == nil {
+				_, err = io.Copy(dst, part)
+			}
+		}
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				h.sendErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+				return
+			}
+			h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid body: %v", err))
+			return
+		}
+	}
+	if !file {
+		h.sendErrorJSON(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	switch format {
+	case "", "json", "text", "verbose_json":
+	default:
+		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("response_format %q is not supported", format))
+		return
+	}
+	model, config := h.routesFor(r.Context()).audioModel(requested, glmASR)
+	writer.WriteField("model", model)
+	writer.Close()
+	h.forwardAudio(w, r, config, model, "/transcriptions", writer.FormDataContentType(), body.Bytes(), format == "text")
+}
+
+func (h *handler) handleSpeech(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	if stringValue(payload["input"], "") == "" {
+		h.sendErrorJSON(w, http.StatusBadRequest, "input is required")
+		return
+	}
+	model, config := h.routesFor(r.Context()).audioModel(stringValue(payload["model"], ""), glmTTS)
+	payload["model"] = rawJSON(model)
+	if openAIVoices[stringValue(payload["voice"], "")] {
+		delete(payload, "voice")
+	}
+	// OpenAI defaults to mp3 which GLM does not produce
+	if _, ok := payload["response_format"]; !ok {
+		payload["response_format"] = rawJSON("wav")
+	}
+	body, err := marshal(payload)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.forwardAudio(w, r, config, model, "/speech", "application/json; charset=utf-8", body, false)
+}
+
+// forwardAudio streams the upstream answer back, text converts JSON
+// transcription into plain text.
+func (h *handler) forwardAudio(w http.ResponseWriter, r *http.Request, config GLMConfig, model, path, contentType string, body []byte, text bool) {
+	start := time.Now()
+	resp, release := h.sendMedia(w, r, config, model, config.URL+"/audio"+path, contentType, body)
+	if resp == nil {
+		return
+	}
+	defer release()
+	defer resp.Body.Close()
+	logf(r.Context(), "%s -> audio%s, %.1fs", model, path, time.Since(start).Seconds())
+
+	if text && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		var transcript struct {
+			Text string `json:"text"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+		if err := json.Unmarshal(data, &transcript); err != nil {
+			h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Invalid response: %v", err))
+			return
+		}
+		h.addCORSHeaders(w)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, transcript.Text)
+		return
+	}
+
+	h.addCORSHeaders(w)
+	for _, header := range []string{"Content-Type", "Content-Length", "Content-Disposition"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
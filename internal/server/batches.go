@@ -0,0 +1,424 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"freeglm/internal/batches"
+)
+
+var batchEndpoints = []string{"/v1/chat/completions", "/v1/completions"}
+
+type batchRequest struct {
+	CustomID string                     `json:"custom_id"`
+	Method   string                     `json:"method"`
+	URL      string                     `json:"url"`
+	Body     map[string]json.RawMessage `json:"body"`
+}
+
+type batchResult struct {
+	ID       string         `json:"id"`
+	CustomID string         `json:"custom_id"`
+	Response *batchResponse `json:"response"`
+	Error    *batches.Error `json:"error"`
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// batchRunner processes batches in background, cancel funcs are kept by
+// batch ID for POST /v1/batches/{id}/cancel.
+type batchRunner struct {
+	store       *batches.Store
+	concurrency int
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newBatchRunner(dir string, concurrency int) (*batchRunner, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	store, err := batches.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("open batches dir %s: %w", dir, err)
+	}
+	return &batchRunner{store: store, concurrency: max(1, concurrency), cancels: map[string]context.CancelFunc{}}, nil
+}
+
+func isBatchesPath(path string) bool {
+	for _, prefix := range []string{"/v1/files", "/v1/batches"} {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *handler) handleBatches(w http.ResponseWriter, r *http.Request) {
+	if h.batches == nil {
+		h.sendErrorJSON(w, http.StatusNotFound, "Batches are disabled, run server with --batch-dir")
+		return
+	}
+	store := h.batches.store
+	owner := virtualKeyName(r.Context())
+	kind, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/v1/"), "/")
+	parts := strings.Split(rest, "/")
+	if rest != "" && !h.batches.owns(kind, parts[0], owner) {
+		h.sendBatchJSON(w, nil, batches.ErrNotFound)
+		return
+	}
+	switch {
+	case kind == "files" && rest == "" && r.Method == http.MethodGet:
+		files := slices.DeleteFunc(store.Files(), func(f batches.File) bool { return f.Owner != owner })
+		h.sendJSON(w, http.StatusOK, map[string]any{"object": "list", "data": files})
+	case kind == "files" && rest == "" && r.Method == http.MethodPost:
+		h.uploadFile(w, r)
+	case kind == "files" && len(parts) == 1 && r.Method == http.MethodGet:
+		f, err := store.File(parts[0])
+		h.sendBatchJSON(w, f, err)
+	case kind == "files" && len(parts) == 1 && r.Method == http.MethodDelete:
+		err := store.DeleteFile(parts[0])
+		h.sendBatchJSON(w, map[string]any{"id": parts[0], "object": "file", "deleted": true}, err)
+	case kind == "files" && len(parts) == 2 && parts[1] == "content" && r.Method == http.MethodGet:
+		content, err := store.Content(parts[0])
+		if err != nil {
+			h.sendBatchJSON(w, nil, err)
+			return
+		}
+		defer content.Close()
+		h.addCORSHeaders(w)
+		w.Header().Set("Content-Type", "application/jsonl")
+		io.Copy(w, content)
+	case kind == "batches" && rest == "" && r.Method == http.MethodGet:
+		list := slices.DeleteFunc(store.Batches(), func(b batches.Batch) bool { return b.Owner != owner })
+		h.sendJSON(w, http.StatusOK, map[string]any{"object": "list", "data": list})
+	case kind == "batches" && rest == "" && r.Method == http.MethodPost:
+		h.createBatch(w, r)
+	case kind == "batches" && len(parts) == 1 && r.Method == http.MethodGet:
+		b, err := store.Batch(parts[0])
+		h.sendBatchJSON(w, b, err)
+	case kind == "batches" && len(parts) == 2 && parts[1] == "cancel" && r.Method == http.MethodPost:
+		h.cancelBatch(w, parts[0])
+	default:
+		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+	}
+}
+
+// owns tells whether the file or batch id belongs to the virtual key owner,
+// missing ones are left for the store to report.
+func (br *batchRunner) owns(kind, id, owner string) bool {
+	if kind == "files" {
+		f, err := br.store.File(id)
+		return err != nil || f.Owner == owner
+	}
+	b, err := br.store.Batch(id)
+	return err != nil || b.Owner == owner
+}
+
+func (h *handler) sendBatchJSON(w http.ResponseWriter, data any, err error) {
+	switch {
+	case errors.Is(err, batches.ErrNotFound):
+		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+	case err != nil:
+		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Batches error: %v", err))
+	default:
+		h.sendJSON(w, http.StatusOK, data)
+	}
+}
+
+func (h *handler) uploadFile(w http.ResponseWriter, r *http.Request) {
+	if h.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.sendErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+			return
+		}
+		h.sendErrorJSON(w, http.StatusBadRequest, "file is required as multipart/form-data field")
+		return
+	}
+	defer file.Close()
+	if purpose := r.FormValue("purpose"); purpose != "batch" {
+		h.sendErrorJSON(w, http.StatusBadRequest, "purpose must be batch")
+		return
+	}
+	f, err := h.batches.store.CreateFile(virtualKeyName(r.Context()), header.Filename, "batch", file)
+	h.sendBatchJSON(w, f, err)
+}
+
+func (h *handler) createBatch(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	b := batches.Batch{
+		InputFileID:      stringValue(payload["input_file_id"], ""),
+		Endpoint:         stringValue(payload["endpoint"], ""),
+		CompletionWindow: stringValue(payload["completion_window"], "24h"),
+		Owner:            virtualKeyName(r.Context()),
+	}
+	if f, err := h.batches.store.File(b.InputFileID); err != nil || f.Owner != b.Owner {
+		h.sendErrorJSON(w, http.StatusBadRequest, "input_file_id must be an uploaded file")
+		return
+	}
+	if !slices.Contains(batchEndpoints, b.Endpoint) {
+		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("endpoint must be one of %v", batchEndpoints))
+		return
+	}
+	if raw := payload["metadata"]; !isNullJSON(raw) {
+		if err := json.Unmarshal(raw, &b.Metadata); err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, "metadata must be an object of strings")
+			return
+		}
+	}
+	b, err := h.batches.store.CreateBatch(b)
+	if err != nil {
+		h.sendBatchJSON(w, nil, err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.batches.mu.Lock()
+	h.batches.cancels[b.ID] = cancel
+	h.batches.mu.Unlock()
+	go h.runBatch(ctx, b, r.Header.Get("Authorization"))
+	h.sendJSON(w, http.StatusOK, b)
+}
+
+func (h *handler) cancelBatch(w http.ResponseWriter, id string) {
+	b, err := h.batches.store.Update(id, func(b *batches.Batch) {
+		if b.Status == batches.StatusValidating || b.Status == batches.StatusInProgress {
+			b.Status, b.CancellingAt = batches.StatusCancelling, batches.Now()
+		}
+	})
+	if err == nil && b.Status == batches.StatusCancelling {
+		h.batches.mu.Lock()
+		if cancel, ok := h.batches.cancels[id]; ok {
+			cancel()
+		}
+		h.batches.mu.Unlock()
+	}
+	h.sendBatchJSON(w, b, err)
+}
+
+// runBatch sends every line of the input file through the proxy itself with
+// the Authorization of the batch creator, so profiles and keys apply as for
+// direct requests.
+func (h *handler) runBatch(ctx context.Context, b batches.Batch, auth string) {
+	store := h.batches.store
+	defer func() {
+		h.batches.mu.Lock()
+		if cancel, ok := h.batches.cancels[b.ID]; ok {
+			cancel()
+			delete(h.batches.cancels, b.ID)
+		}
+		h.batches.mu.Unlock()
+	}()
+	requests, lineErrors, err := h.readBatch(b)
+	if err != nil {
+		lineErrors = []batches.Error{{Code: "invalid_file", Message: err.Error()}}
+	}
+	if len(lineErrors) != 0 {
+		store.Update(b.ID, func(b *batches.Batch) { b.Fail(lineErrors...) })
+		log.Printf("batch %s failed validation", b.ID)
+		return
+	}
+	store.Update(b.ID, func(b *batches.Batch) {
+		if b.Status == batches.StatusValidating {
+			b.Status, b.InProgressAt = batches.StatusInProgress, batches.Now()
+		}
+		b.RequestCounts.Total = len(requests)
+	})
+	log.Printf("batch %s started, %d requests", b.ID, len(requests))
+
+	var (
+		mu              sync.Mutex
+		outputs, failed bytes.Buffer
+		wg              sync.WaitGroup
+	)
+	queue := make(chan batchRequest)
+	for range h.batches.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				result := h.batchCall(ctx, req, auth)
+				line, _ := marshal(result)
+				ok := result.Response != nil && result.Response.StatusCode < 300
+				mu.Lock()
+				if ok {
+					outputs.Write(append(line, '\n'))
+				} else {
+					failed.Write(append(line, '\n'))
+				}
+				mu.Unlock()
+				store.Update(b.ID, func(b *batches.Batch) {
+					if ok {
+						b.RequestCounts.Completed++
+					} else {
+						b.RequestCounts.Failed++
+					}
+				})
+			}
+		}()
+	}
+send:
+	for _, req := range requests {
+		select {
+		case queue <- req:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	b, _ = store.Update(b.ID, func(b *batches.Batch) {
+		if b.Status == batches.StatusInProgress {
+			b.Status, b.FinalizingAt = batches.StatusFinalizing, batches.Now()
+		}
+	})
+	var outputID, errorID *string
+	for _, file := range []struct {
+		buf *bytes.Buffer
+		id  **string
+		ext string
+	}{{&outputs, &outputID, "output"}, {&failed, &errorID, "error"}} {
+		if file.buf.Len() == 0 {
+			continue
+		}
+		f, err := store.CreateFile(b.Owner, b.ID+"_"+file.ext+".jsonl", "batch_output", file.buf)
+		if err != nil {
+			log.Printf("batch %s %s file: %v", b.ID, file.ext, err)
+			continue
+		}
+		*file.id = &f.ID
+	}
+	b, _ = store.Update(b.ID, func(b *batches.Batch) {
+		b.OutputFileID, b.ErrorFileID = outputID, errorID
+		if b.Status == batches.StatusCancelling {
+			b.Status, b.CancelledAt = batches.StatusCancelled, batches.Now()
+		} else {
+			b.Status, b.CompletedAt = batches.StatusCompleted, batches.Now()
+		}
+	})
+	log.Printf("batch %s %s, %d completed, %d failed", b.ID, b.Status, b.RequestCounts.Completed, b.RequestCounts.Failed)
+}
+
+func (h *handler) readBatch(b batches.Batch) ([]batchRequest, []batches.Error, error) {
+	content, err := h.batches.store.Content(b.InputFileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer content.Close()
+	var (
+		requests []batchRequest
+		failures []batches.Error
+	)
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		fail := func(code, message string) {
+			failures = append(failures, batches.Error{Code: code, Message: message, Line: &line})
+		}
+		var req batchRequest
+		switch err := json.Unmarshal(scanner.Bytes(), &req); {
+		case err != nil:
+			fail("invalid_json_line", "Line is not a JSON object")
+		case req.CustomID == "":
+			fail("missing_custom_id", "custom_id is required")
+		case ids[req.CustomID]:
+			fail("duplicate_custom_id", fmt.Sprintf("custom_id %s is used more than once", req.CustomID))
+		case req.Method != http.MethodPost:
+			fail("invalid_method", "method must be POST")
+		case req.URL != b.Endpoint:
+			fail("mismatched_endpoint", fmt.Sprintf("url must be %s as the batch endpoint", b.Endpoint))
+		case req.Body == nil:
+			fail("missing_body", "body must be an object")
+		default:
+			ids[req.CustomID] = true
+			requests = append(requests, req)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(requests) == 0 && len(failures) == 0 {
+		return nil, nil, fmt.Errorf("input file has no requests")
+	}
+	return requests, failures, nil
+}
+
+func (h *handler) batchCall(ctx context.Context, br batchRequest, auth string) batchResult {
+	result := batchResult{ID: randomID("batch_req_", 24), CustomID: br.CustomID}
+	br.Body["stream"] = rawJSON(false)
+	body, err := marshal(br.Body)
+	if err == nil {
+		var req *http.Request
+		if req, err = http.NewRequestWithContext(ctx, http.MethodPost, br.URL, bytes.NewReader(body)); err == nil {
+			req.Header.Set("Authorization", auth)
+			req.Header.Set("Content-Type", "application/json")
+			if _, ok := h.lanes["low"]; ok {
+				req.Header.Set(priorityHeader, "low")
+			}
+			rec := &batchWriter{header: http.Header{}}
+			req = withRequestID(rec, req)
+			h.handlePost(rec, req)
+			if ctx.Err() == nil {
+				result.Response = &batchResponse{StatusCode: rec.status, RequestID: requestID(req.Context()), Body: rec.body.Bytes()}
+				return result
+			}
+			err = ctx.Err()
+		}
+	}
+	code := "request_failed"
+	if errors.Is(err, context.Canceled) {
+		code = "batch_cancelled"
+	}
+	result.Error = &batches.Error{Code: code, Message: err.Error()}
+	return result
+}
+
+// batchWriter records the response of one batch request.
+type batchWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *batchWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *batchWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	b.WriteHeader(http.StatusOK)
+	return b.body.Write(p)
+}
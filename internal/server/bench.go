@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BenchOptions struct {
+	Models []string
+	// Requests sent for every model and key.
+	Requests    int
+	Concurrency int
+	Prompt      string
+	MaxTokens   int
+	// Target is base URL of a running proxy, upstream providers are
+	// benchmarked directly with every key when empty.
+	Target string
+	APIKey string
+}
+
+type BenchResult struct {
+	Provider     string
+	Model        string
+	Key          string
+	Requests     int
+	Errors       int
+	P50          time.Duration
+	P95          time.Duration
+	TokensPerSec float64
+	Message      string
+
+	url       string
+	key       string
+	latencies []time.Duration
+	tokens    int
+	spent     time.Duration
+}
+
+// Bench sends bench.Requests synthetic completions for every model and key,
+// at most bench.Concurrency at once for every key.
+func Bench(ctx context.Context, opts Options, bench BenchOptions) ([]*BenchResult, error) {
+	client, err := newClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if bench.Requests <= 0 || bench.Concurrency <= 0 {
+		return nil, fmt.Errorf("requests and concurrency must be positive")
+	}
+	var results []*BenchResult
+	for _, model := range bench.Models {
+		if bench.Target != "" {
+			results = append(results, &BenchResult{
+				Provider: "proxy", Model: model, Key: maskKey(bench.APIKey),
+				url: strings.TrimSuffix(bench.Target, "/") + "/chat/completions", key: bench.APIKey,
+			})
+			continue
+		}
+		found := false
+		for _, p := range slices.Concat(providers, opts.Providers) {
+			if _, ok := p.Models[model]; !ok {
+				continue
+			}
+			keys := p.Keys
+			if len(keys) == 0 {
+				keys = opts.Keys
+			}
+			for _, key := range keys {
+				found = true
+				results = append(results, &BenchResult{
+					Provider: p.Name, Model: model, Key: maskKey(key),
+					url: strings.TrimSuffix(p.BaseURL, "/") + "/chat/completions", key: key,
+				})
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no provider with keys serves model %s", model)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.run(ctx, client, bench)
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
+
+func (r *BenchResult) run(ctx context.Context, client *http.Client, bench BenchOptions) {
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		limit = make(chan struct{}, bench.Concurrency)
+	)
+	for range bench.Requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			latency, tokens, err := r.send(ctx, client, bench)
+			mu.Lock()
+			defer mu.Unlock()
+			r.Requests++
+			if err != nil {
+				r.Errors++
+				r.Message = err.Error()
+				return
+			}
+			r.latencies = append(r.latencies, latency)
+			r.tokens += tokens
+			r.spent += latency
+		}()
+	}
+	wg.Wait()
+	slices.Sort(r.latencies)
+	r.P50, r.P95 = percentile(r.latencies, 50), percentile(r.latencies, 95)
+	if r.spent > 0 {
+		r.TokensPerSec = float64(r.tokens) / r.spent.Seconds()
+	}
+}
+
+func (r *BenchResult) send(ctx context.Context, client *http.Client, bench BenchOptions) (time.Duration, int, error) {
+	body, _ := marshal(map[string]any{
+		"model":      r.Model,
+		"messages":   []map[string]string{{"role": "user", "content": bench.Prompt}},
+		"max_tokens": bench.MaxTokens,
+		"stream":     false,
+	})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, 0, err
+	}
+	if r.key != "" {
+		req.Header.Set("Authorization", "Bearer "+r.key)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if err := decodeBody(resp); err != nil {
+		return 0, 0, err
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	latency := time.Since(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode >= 300 {
+		return 0, 0, fmt.Errorf("%d %s", resp.StatusCode, upstreamMessage(resp.StatusCode, data))
+	}
+	var root map[string]json.RawMessage
+	if err := json.Unmarshal(data, &root); err != nil {
+		return 0, 0, fmt.Errorf("invalid response: %w", err)
+	}
+	return latency, extractUsage(root).completion, nil
+}
+
+// percentile expects sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// breakers open the circuit of an upstream URL after threshold consecutive
+// connection errors or 502/503/504, requests to it fail fast until cooldown
+// passes. Then one request is let through as a probe: success closes the
+// circuit, failure opens it for another cooldown.
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu    sync.Mutex
+	state map[string]*breaker
+}
+
+type breaker struct {
+	failures int
+	until    time.Time
+	probing  bool
+}
+
+// circuitOpenError is returned for requests to an upstream URL with open
+// circuit, they are not retried.
+type circuitOpenError struct {
+	target   string
+	failures int
+	retry    time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("Upstream %s is unavailable after %d consecutive failures, circuit is open, retry in %s",
+		e.target, e.failures, e.retry.Round(time.Second))
+}
+
+func newBreakers(threshold, cooldown int) *breakers {
+	return &breakers{
+		threshold: max(0, threshold),
+		cooldown:  time.Duration(max(1, cooldown)) * time.Second,
+		state:     map[string]*breaker{},
+	}
+}
+
+// allow returns circuitOpenError when the request must not be sent to target.
+func (b *breakers) allow(target string) error {
+	if b.threshold == 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.state[target]
+	if s == nil || s.failures < b.threshold {
+		return nil
+	}
+	if wait := time.Until(s.until); wait > 0 || s.probing {
+		return &circuitOpenError{target: target, failures: s.failures, retry: max(wait, time.Second)}
+	}
+	s.probing = true
+	log.Printf("upstream %s circuit half-open, probing", target)
+	return nil
+}
+
+func (b *breakers) success(target string) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.state[target]; s != nil {
+		if s.failures >= b.threshold {
+			log.Printf("upstream %s circuit closed", target)
+		}
+		delete(b.state, target)
+	}
+}
+
+func (b *breakers) failure(target string) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.state[target]
+	if s == nil {
+		s = &breaker{}
+		b.state[target] = s
+	}
+	s.failures++
+	s.probing = false
+	if s.failures >= b.threshold {
+		s.until = time.Now().Add(b.cooldown)
+		log.Printf("upstream %s circuit open for %s after %d consecutive failures", target, b.cooldown, s.failures)
+	}
+}
+
+// abort ends a probe canceled by the client without a verdict.
+func (b *breakers) abort(target string) {
+	if b.threshold == 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.state[target]; s != nil {
+		s.probing = false
+	}
+}
+
+func (b *breakers) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	clear(b.state)
+}
+
+// sendConnectionError writes 503 with Retry-After for open circuits and 502
+// for other errors of sending the request.
+func (h *handler) sendConnectionError(w http.ResponseWriter, err error) {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(open.retry.Seconds()))))
+		h.sendErrorJSON(w, http.StatusServiceUnavailable, open.Error())
+		return
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		h.sendErrorJSON(w, http.StatusGatewayTimeout, fmt.Sprintf("Upstream timeout: %v", err))
+		return
+	}
+	h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Connection error: %v", err))
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const (
+	budgetReject = "reject"
+	budgetQueue  = "queue"
+)
+
+var budgetActions = []string{budgetReject, budgetQueue}
+
+// budgetAll is the quota name of the budget of all keys, keys are counted
+// under their keyID.
+const budgetAll = "all"
+
+// budget is the daily token budget of all keys and of every key, counted
+// from usage and started over at midnight of its timezone. A key which used
+// its budget is not picked, when all did (or the total is used up) requests
+// are rejected with 429 or queued until midnight.
+type budget struct {
+	total  int
+	perKey int
+	queue  bool
+	quotas *quotas
+}
+
+func newBudget(total, perKey int, action, timezone string, store storage.Storage) (*budget, error) {
+	if total < 0 || perKey < 0 {
+		return nil, errors.New("daily budget tokens must not be negative")
+	}
+	action = cmp.Or(action, budgetReject)
+	if action != budgetReject && action != budgetQueue {
+		return nil, fmt.Errorf("budget action must be one of %v", budgetActions)
+	}
+	loc, err := time.LoadLocation(cmp.Or(timezone, "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("budget timezone: %w", err)
+	}
+	if total == 0 && perKey == 0 {
+		return nil, nil
+	}
+	q := newQuotas(nil, "budget", store)
+	q.loc = loc
+	// a budget of paid plans must survive restarts, not only be shared
+	if _, memory := store.(*storage.Memory); !memory {
+		q.store = store
+	}
+	return &budget{total: total, perKey: perKey, queue: action == budgetQueue, quotas: q}, nil
+}
+
+// exhausted returns how long key rests for its used up budget, zero when it
+// may be picked.
+func (b *budget) exhausted(key string) time.Duration {
+	if b == nil || b.perKey == 0 || key == "" {
+		return 0
+	}
+	if code, _, wait := b.quotas.admit(keyID(key), b.perKey, 0); code != "" {
+		return wait
+	}
+	return 0
+}
+
+// admit returns the message and wait until midnight when the total budget
+// or the budget of key is used up.
+func (b *budget) admit(key string) (string, time.Duration) {
+	if b == nil {
+		return "", 0
+	}
+	if b.total > 0 {
+		if code, _, wait := b.quotas.admit(budgetAll, b.total, 0); code != "" {
+			return fmt.Sprintf("Daily budget of %d tokens is used up", b.total), wait
+		}
+	}
+	if wait := b.exhausted(key); wait > 0 {
+		return fmt.Sprintf("Daily budget of %d tokens is used up for every key", b.perKey), wait
+	}
+	return "", 0
+}
+
+func (b *budget) used(key string, tokens int) {
+	if b == nil || tokens == 0 {
+		return
+	}
+	if b.total > 0 {
+		b.quotas.used(budgetAll, tokens)
+	}
+	if b.perKey > 0 && key != "" {
+		b.quotas.used(keyID(key), tokens)
+	}
+}
+
+// spent returns tokens of the total budget used today.
+func (b *budget) spent() int {
+	b.quotas.mu.Lock()
+	defer b.quotas.mu.Unlock()
+	return b.quotas.today(budgetAll, time.Now()).Tokens
+}
+
+// admitBudget rejects the request with 429 when the budget is used up, with
+// queue action it waits for midnight instead unless X-Freeglm-Max-Wait is
+// shorter. key is the pool key, pick skipped keys without budget so it has
+// none only when no key has.
+func (h *handler) admitBudget(w http.ResponseWriter, r *http.Request, key string) bool {
+	msg, wait := h.budget.admit(key)
+	if wait <= 0 {
+		return true
+	}
+	limit, err := strconv.Atoi(r.Header.Get(maxWaitHeader))
+	if h.budget.queue && (err != nil || limit < 0 || time.Duration(limit)*time.Second >= wait) {
+		logf(r.Context(), "%s, queued for %s", msg, wait.Round(time.Second))
+		select {
+		case <-r.Context().Done():
+			return false
+		case <-time.After(wait):
+			return true
+		}
+	}
+	logf(r.Context(), "%s", msg)
+	h.sendLimitError(w, insufficientQuota, msg, wait)
+	return false
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const cacheHeader = "X-Freeglm-Cache"
+
+// responseCache keeps the last non-stream responses by request hash, the
+// least recently used entry is dropped when size is reached. With store set
+// entries live there under "cache:" until ttl instead.
+type responseCache struct {
+	size  int
+	ttl   time.Duration
+	store storage.Storage
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+	dirty   bool
+}
+
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Body    json.RawMessage `json:"body"`
+	Expires time.Time       `json:"expires"`
+}
+
+func newResponseCache(size int, ttl time.Duration) *responseCache {
+	if size <= 0 {
+		return nil
+	}
+	return &responseCache{size: size, ttl: ttl, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// cacheKey hashes everything the response depends on: the payload sent
+// upstream, the model name shown to the client and the response rewrites,
+// and who asked so callers never get answers cached for others: the client
+// key unless pooled, the virtual key and the tenant. Hits are not counted
+// in budgets and quotas as no tokens are spent.
+func cacheKey(payload []byte, c *call, n int, pooled bool) string {
+	sum := sha256.New()
+	sum.Write(payload)
+	fmt.Fprintf(sum, "\x00%s\x00%d", c.alias, n)
+	if !pooled {
+		fmt.Fprintf(sum, "\x00%s", keyID(c.token))
+	}
+	fmt.Fprintf(sum, "\x00%s\x00%s", c.virtual, c.tenant)
+	if c.transform != nil {
+		fmt.Fprintf(sum, "\x00%s\x00%t\x00%t", c.transform.reasoning, c.transform.toolRepair, c.transform.logprobs)
+	}
+	if c.legacy != nil {
+		fmt.Fprintf(sum, "\x00%s\x00%t\x00%t", c.legacy.prompt, c.legacy.echo, c.legacy.logprobs)
+	}
+	if c.format != nil {
+		sum.Write([]byte("\x00format"))
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+// cacheMode reads X-Freeglm-Cache request header: "no-store" skips the
+// cache, "refresh" skips the lookup but stores the new response.
+func cacheMode(value string) (lookup, store bool, err error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "":
+		return true, true, nil
+	case "refresh":
+		return false, true, nil
+	case "no-store":
+		return false, false, nil
+	}
+	return false, false, fmt.Errorf("%s must be one of [no-store refresh]", cacheHeader)
+}
+
+func (rc *responseCache) get(key string) ([]byte, bool) {
+	if rc.store != nil {
+		body, err := rc.store.Get(context.Background(), "cache:"+key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Println("cache get error:", err)
+		}
+		return body, err == nil
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	el, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if rc.ttl > 0 && time.Now().After(entry.Expires) {
+		rc.order.Remove(el)
+		delete(rc.entries, key)
+		rc.dirty = true
+		return nil, false
+	}
+	rc.order.MoveToFront(el)
+	return entry.Body, true
+}
+
+func (rc *responseCache) put(key string, body []byte) {
+	if rc.store != nil {
+		if err := rc.store.Set(context.Background(), "cache:"+key, body, rc.ttl); err != nil {
+			log.Println("cache put error:", err)
+		}
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.insert(&cacheEntry{Key: key, Body: append(json.RawMessage(nil), body...), Expires: time.Now().Add(rc.ttl)})
+	rc.dirty = true
+}
+
+func (rc *responseCache) reset() {
+	if rc.store != nil {
+		ctx := context.Background()
+		err := rc.store.Scan(ctx, "cache:", func(key string, _ []byte) error {
+			return rc.store.Delete(ctx, key)
+		})
+		if err != nil {
+			log.Println("cache reset error:", err)
+		}
+		return
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.order.Init()
+	clear(rc.entries)
+	rc.dirty = true
+}
+
+func (rc *responseCache) insert(entry *cacheEntry) {
+	if el, ok := rc.entries[entry.Key]; ok {
+		el.Value = entry
+		rc.order.MoveToFront(el)
+		return
+	}
+	rc.entries[entry.Key] = rc.order.PushFront(entry)
+	for rc.order.Len() > rc.size {
+		last := rc.order.Back()
+		rc.order.Remove(last)
+		delete(rc.entries, last.Value.(*cacheEntry).Key)
+	}
+}
+
+func (rc *responseCache) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	now := time.Now()
+	// entries are saved from the most recently used, insert the oldest first
+	for i := len(entries) - 1; i >= 0; i-- {
+		if rc.ttl > 0 && now.After(entries[i].Expires) {
+			continue
+		}
+		rc.insert(entries[i])
+	}
+	return nil
+}
+
+func (rc *responseCache) save(path string) error {
+	rc.mu.Lock()
+	if !rc.dirty {
+		rc.mu.Unlock()
+		return nil
+	}
+	rc.dirty = false
+	entries := make([]*cacheEntry, 0, rc.order.Len())
+	for el := rc.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*cacheEntry))
+	}
+	data, err := json.Marshal(entries)
+	rc.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (rc *responseCache) persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := rc.save(path); err != nil {
+				log.Println("cache save error:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KeyValid   = "valid"
+	KeyLimited = "rate-limited"
+	KeyInvalid = "invalid"
+	KeyError   = "error"
+)
+
+type KeyCheck struct {
+	Provider string
+	Model    string
+	Key      string
+	Status   string
+	Message  string
+	Latency  time.Duration
+
+	key    string
+	shared bool
+}
+
+// CheckKeys sends a one token completion with every key to every provider
+// the key is used for.
+func CheckKeys(ctx context.Context, opts Options) ([]KeyCheck, error) {
+	client, err := newClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if client.Timeout == 0 {
+		client.Timeout = 30 * time.Second
+	}
+	var (
+		checks []KeyCheck
+		urls   []string
+	)
+	for _, p := range slices.Concat(providers, opts.Providers) {
+		models := slices.Sorted(maps.Keys(p.Models))
+		if len(models) == 0 {
+			continue
+		}
+		keys, shared := p.Keys, len(p.Keys) == 0
+		if shared {
+			keys = opts.Keys
+		}
+		for _, key := range keys {
+			checks = append(checks, KeyCheck{Provider: p.Name, Model: models[0], Key: key, key: key, shared: shared})
+			urls = append(urls, strings.TrimSuffix(p.BaseURL, "/")+"/chat/completions")
+		}
+	}
+
+	var wg sync.WaitGroup
+	limit := make(chan struct{}, 8)
+	for i := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+			checks[i].check(ctx, client, urls[i])
+		}()
+	}
+	wg.Wait()
+	return checks, nil
+}
+
+// WarmKeys checks all keys like CheckKeys, logs the results and drops keys
+// rejected as invalid from opts. A shared key is dropped when every provider
+// rejects it, rate-limited keys and keys failing otherwise are kept. With
+// strict it fails when no key is valid or rate-limited.
+func WarmKeys(ctx context.Context, opts *Options, strict bool) error {
+	checks, err := CheckKeys(ctx, *opts)
+	if err != nil {
+		return err
+	}
+	var (
+		usable        int
+		invalid       = map[string]map[string]bool{}
+		sharedChecks  = map[string]int{}
+		sharedInvalid = map[string]int{}
+	)
+	for _, k := range checks {
+		switch k.Status {
+		case KeyValid, KeyLimited:
+			usable++
+			log.Printf("key %s for %s: %s (%s)", k.Key, k.Provider, k.Status, k.Latency.Round(time.Millisecond))
+		default:
+			log.Printf("key %s for %s: %s: %s", k.Key, k.Provider, k.Status, k.Message)
+		}
+		switch {
+		case k.shared:
+			sharedChecks[k.key]++
+			if k.Status == KeyInvalid {
+				sharedInvalid[k.key]++
+			}
+		case k.Status == KeyInvalid:
+			if invalid[k.Provider] == nil {
+				invalid[k.Provider] = map[string]bool{}
+			}
+			invalid[k.Provider][k.key] = true
+		}
+	}
+	log.Printf("%d of %d key checks usable", usable, len(checks))
+	if strict && usable == 0 {
+		return fmt.Errorf("no usable API keys (--strict-keys)")
+	}
+
+	opts.Keys = slices.DeleteFunc(slices.Clone(opts.Keys), func(key string) bool {
+		return sharedChecks[key] > 0 && sharedInvalid[key] == sharedChecks[key]
+	})
+	providers := slices.Clone(opts.Providers)
+	for i, p := range providers {
+		drop := invalid[p.Name]
+		if len(drop) == 0 {
+			continue
+		}
+		// a provider without keys would fall back to the shared ones
+		if keys := slices.DeleteFunc(slices.Clone(p.Keys), func(key string) bool { return drop[key] }); len(keys) != 0 {
+			providers[i].Keys = keys
+		}
+	}
+	opts.Providers = providers
+	return nil
+}
+
+func (k *KeyCheck) check(ctx context.Context, client *http.Client, url string) {
+	body, _ := marshal(map[string]any{
+		"model":      k.Model,
+		"messages":   []map[string]string{{"role": "user", "content": "ping"}},
+		"max_tokens": 1,
+		"stream":     false,
+	})
+	defer func() { k.Key = maskKey(k.Key) }()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		k.Status, k.Message = KeyError, err.Error()
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+k.Key)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	start := time.Now()
+	resp, err := client.Do(req)
+	k.Latency = time.Since(start)
+	if err != nil {
+		k.Status, k.Message = KeyError, err.Error()
+		return
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	switch {
+	case resp.StatusCode < 300:
+		k.Status = KeyValid
+		return
+	case resp.StatusCode == http.StatusTooManyRequests:
+		k.Status = KeyLimited
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		k.Status = KeyInvalid
+	default:
+		k.Status = KeyError
+	}
+	k.Message = upstreamMessage(resp.StatusCode, data)
+}
+
+func upstreamMessage(status int, body []byte) string {
+	msg := strings.TrimSpace(string(body))
+	var parsed map[string]any
+	if err := json.Unmarshal(body, &parsed); err == nil {
+		if errMap, ok := parsed["error"].(map[string]any); ok {
+			if text, ok := errMap["message"].(string); ok && text != "" {
+				msg = text
+			}
+		}
+	}
+	if msg == "" {
+		msg = fmt.Sprintf("upstream error %d", status)
+	}
+	return msg
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+var logprobsStub = mustMarshal(map[string]any{
+	"tokens":         []string{},
+	"token_logprobs": []float64{},
+	"top_logprobs":   []any{},
+	"text_offset":    []int{},
+})
+
+type completion struct {
+	prompt   string
+	echo     bool
+	logprobs bool
+	echoed   map[int]bool
+}
+
+func (h *handler) handleCompletion(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	legacy, err := completionRequest(payload)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.forward(w, r, payload, legacy)
+}
+
+func completionRequest(payload map[string]json.RawMessage) (*completion, error) {
+	c := &completion{echoed: map[int]bool{}}
+	if raw := payload["prompt"]; !isNullJSON(raw) {
+		var prompts []string
+		switch {
+		case json.Unmarshal(raw, &c.prompt) == nil:
+		case json.Unmarshal(raw, &prompts) == nil:
+			if len(prompts) > 1 {
+				return nil, fmt.Errorf("prompt must be a single string, got %d prompts", len(prompts))
+			}
+			if len(prompts) == 1 {
+				c.prompt = prompts[0]
+			}
+		default:
+			return nil, fmt.Errorf("prompt must be a string")
+		}
+	}
+	if suffix := stringValue(payload["suffix"], ""); suffix != "" {
+		return nil, fmt.Errorf("suffix is not supported")
+	}
+	c.echo, _ = boolValue(payload["echo"])
+	c.logprobs = !isNullJSON(payload["logprobs"])
+	for _, key := range []string{"prompt", "suffix", "echo", "logprobs", "best_of"} {
+		delete(payload, key)
+	}
+	payload["messages"] = mustMarshal([]map[string]string{{"role": "user", "content": c.prompt}})
+	return c, nil
+}
+
+func (c *completion) response(out *bytes.Buffer, body []byte) error {
+	resp, err := decodeJSONBytes(body)
+	if err != nil {
+		return err
+	}
+	resp["object"] = rawJSON("text_completion")
+	choices := decodeArray(resp["choices"])
+	for i, choice := range choices {
+		choices[i] = c.choice(choice, "message")
+	}
+	resp["choices"] = mustMarshal(choices)
+	return encodeJSONMap(out, resp)
+}
+
+// chunk rewrites the chat chunk written to buf after offset in place.
+func (c *completion) chunk(buf *bytes.Buffer, offset int) error {
+	chunk, err := decodeJSONBytes(bytes.Clone(buf.Bytes()[offset:]))
+	if err != nil {
+		return err
+	}
+	chunk["object"] = rawJSON("text_completion")
+	choices := decodeArray(chunk["choices"])
+	for i, choice := range choices {
+		choices[i] = c.choice(choice, "delta")
+	}
+	chunk["choices"] = mustMarshal(choices)
+	buf.Truncate(offset)
+	return encodeJSONMap(buf, chunk)
+}
+
+func (c *completion) choice(choice map[string]json.RawMessage, field string) map[string]json.RawMessage {
+	index, _ := intValue(choice["index"])
+	text := stringValue(extractNested(choice, field, "content"), "")
+	if c.echo && !c.echoed[index] {
+		text = c.prompt + text
+		c.echoed[index] = true
+	}
+	logprobs := json.RawMessage("null")
+	if c.logprobs {
+		logprobs = logprobsStub
+	}
+	finish := choice["finish_reason"]
+	if len(finish) == 0 {
+		finish = json.RawMessage("null")
+	}
+	out := map[string]json.RawMessage{
+		"index":         rawJSON(index),
+		"text":          rawJSON(text),
+		"logprobs":      logprobs,
+		"finish_reason": finish,
+	}
+	if raw, ok := choice[finishRawField]; ok {
+		out[finishRawField] = raw
+	}
+	return out
+}
+
+func completionID() string {
+	return randomID("cmpl-", 29)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	contextOff       = "off"
+	contextError     = "error"
+	contextTruncate  = "truncate-oldest"
+	contextSummarize = "summarize"
+
+	defaultContext = 128000
+	// mediaTokens is a rough price of one image or video part.
+	mediaTokens = 1000
+	// minFitTokens is the least max_tokens is lowered to for a long prompt,
+	// prompts leaving less room are left to --context-strategy.
+	minFitTokens = 1024
+)
+
+var contextStrategies = []string{contextOff, contextError, contextTruncate, contextSummarize}
+
+const summaryPrompt = "Summarize the conversation below in a few short paragraphs. Keep names, decisions, facts, code identifiers and open questions, skip pleasantries."
+
+func validContextStrategy(strategy string) error {
+	if !slices.Contains(contextStrategies, strategy) {
+		return fmt.Errorf("context strategy must be one of %v", contextStrategies)
+	}
+	return nil
+}
+
+// estimateTokens is ~3 bytes per token of message text, it errs on the
+// larger side for English and is close for Chinese.
+func estimateTokens(msg map[string]json.RawMessage) int {
+	n := 4 + len(msg["tool_calls"])/3
+	content := msg["content"]
+	if len(content) == 0 || content[0] != '[' {
+		return n + len(content)/3
+	}
+	var parts []map[string]json.RawMessage
+	json.Unmarshal(content, &parts)
+	for _, part := range parts {
+		if text, ok := part["text"]; ok {
+			n += len(text) / 3
+		} else {
+			n += mediaTokens
+		}
+	}
+	return n
+}
+
+// fitTokens lowers max_tokens so the estimated prompt and the answer fit
+// the model context, which upstream rejects with 400 otherwise. It returns
+// the estimated prompt and the lowered max_tokens, zero when it is kept.
+func fitTokens(payload map[string]json.RawMessage, config GLMConfig) (int, int) {
+	maxTokens, ok := intValue(payload["max_tokens"])
+	if !ok || maxTokens <= minFitTokens {
+		return 0, 0
+	}
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return 0, 0
+	}
+	limit := config.Context
+	if limit <= 0 {
+		limit = defaultContext
+	}
+	prompt := len(payload["tools"]) / 3
+	for _, msg := range messages {
+		prompt += estimateTokens(msg)
+	}
+	lowered := limit - prompt
+	if lowered >= maxTokens || lowered < minFitTokens {
+		return prompt, 0
+	}
+	payload["max_tokens"] = rawJSON(lowered)
+	return prompt, lowered
+}
+
+// fitContext makes the messages fit the model context with max_tokens
+// (or max_completion_tokens) reserved for the answer, per --context-strategy.
+func (h *handler) fitContext(ctx context.Context, payload map[string]json.RawMessage, c *call) error {
+	if h.contextStrategy == contextOff {
+		return nil
+	}
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return nil
+	}
+	limit := c.config.Context
+	if limit <= 0 {
+		limit = defaultContext
+	}
+	reserve, _ := intValue(payload["max_tokens"])
+	budget := limit - reserve
+	sizes := make([]int, len(messages))
+	total := 0
+	for i, msg := range messages {
+		sizes[i] = estimateTokens(msg)
+		total += sizes[i]
+	}
+	if total <= budget {
+		return nil
+	}
+	if h.contextStrategy == contextError {
+		return fmt.Errorf("messages are ~%d tokens, %s context of %d tokens fits %d with %s %d", total, c.model, limit, budget, c.tokensField, reserve)
+	}
+
+	// system messages at the start and the last message are always kept
+	first := 0
+	for first < len(messages)-1 && isSystemRole(messages[first]) {
+		first++
+	}
+	cut := first
+	for total > budget && cut < len(messages)-1 {
+		total -= sizes[cut]
+		cut++
+		// tool results without the assistant tool call are rejected upstream
+		for cut < len(messages)-1 && stringValue(messages[cut]["role"], "") == "tool" {
+			total -= sizes[cut]
+			cut++
+		}
+	}
+	if total > budget {
+		return fmt.Errorf("last message is ~%d tokens, %s context of %d tokens fits %d with %s %d", total, c.model, limit, budget, c.tokensField, reserve)
+	}
+	dropped := messages[first:cut]
+	kept := append(messages[:first:first], messages[cut:]...)
+	if h.contextStrategy == contextSummarize {
+		summary, err := h.summarize(ctx, c, dropped)
+		if err != nil {
+			logf(ctx, "%s summary of %d messages failed, dropping them: %v", c.model, len(dropped), err)
+		} else if size := estimateTokens(summary); total+size <= budget {
+			kept = append(kept[:first:first], append([]map[string]json.RawMessage{summary}, kept[first:]...)...)
+			logf(ctx, "%s context overflow, %d oldest messages summarized", c.model, len(dropped))
+			payload["messages"] = chatMessages(kept)
+			return nil
+		}
+	}
+	logf(ctx, "%s context overflow, %d oldest messages dropped", c.model, len(dropped))
+	payload["messages"] = chatMessages(kept)
+	return nil
+}
+
+func isSystemRole(msg map[string]json.RawMessage) bool {
+	role := stringValue(msg["role"], "")
+	return role == "system" || role == "developer"
+}
+
+// summarize asks the same model with the same key for a summary of the
+// messages and returns it as a system message.
+func (h *handler) summarize(ctx context.Context, c *call, messages []map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		text := msg["content"]
+		if parts := decodeArray(text); parts != nil {
+			for _, part := range parts {
+				delete(part, "cache_control")
+			}
+			text, _, _ = contentParts(mustMarshal(parts), false)
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", stringValue(msg["role"], "user"), stringValue(text, ""))
+	}
+	body, err := marshal(map[string]any{
+		"model": c.model,
+		"messages": []map[string]string{
+			{"role": "system", "content": summaryPrompt},
+			{"role": "user", "content": transcript.String()},
+		},
+		"max_tokens": 1024,
+		"stream":     false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(requestIDHeader, requestID(ctx))
+	resp, err := h.send(req, c.model)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("upstream %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	if len(out.Choices) == 0 || strings.TrimSpace(out.Choices[0].Message.Content) == "" {
+		return nil, fmt.Errorf("empty summary")
+	}
+	return map[string]json.RawMessage{
+		"role":    rawJSON("system"),
+		"content": rawJSON("Summary of the earlier conversation:\n" + out.Choices[0].Message.Content),
+	}, nil
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	_ "embed"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+func (h *handler) handleDashboard(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(dashboardHTML)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(dashboardHTML)
+}
+
+func (h *handler) handleDashboardEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.sendErrorJSON(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	h.addCORSHeaders(w)
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		buf.Reset()
+		if err := encode(buf, h.stats.live()); err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n", buf.Bytes())
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
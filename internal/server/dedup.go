@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+const dedupHeader = "X-Freeglm-Dedup"
+
+// flights coalesces identical non-stream requests: the first one goes
+// upstream, the ones arriving while it is in flight wait and get its
+// response, so clients retrying before an answer do not spend quota twice.
+type flights struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+// flight is a request in flight with the response it sent, status is zero
+// when it sent none (its client went away before).
+type flight struct {
+	done   chan struct{}
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func newFlights(enabled bool) *flights {
+	if !enabled {
+		return nil
+	}
+	return &flights{calls: map[string]*flight{}}
+}
+
+// join returns the flight of key and whether the caller leads it, a leader
+// must land it.
+func (f *flights) join(key string) (*flight, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if fl, ok := f.calls[key]; ok {
+		return fl, false
+	}
+	fl := &flight{done: make(chan struct{})}
+	f.calls[key] = fl
+	return fl, true
+}
+
+// land lets the waiting requests answer with the response of fl.
+func (f *flights) land(key string, fl *flight) {
+	f.mu.Lock()
+	delete(f.calls, key)
+	f.mu.Unlock()
+	close(fl.done)
+}
+
+// sendShared writes the response of fl for another request, false when fl
+// sent none and the request has to go upstream itself.
+func sendShared(w http.ResponseWriter, fl *flight) bool {
+	if fl.status == 0 {
+		return false
+	}
+	for name, values := range fl.header {
+		if _, ok := w.Header()[name]; !ok {
+			w.Header()[name] = values
+		}
+	}
+	body := fl.body.Bytes()
+	if id := fl.header.Get(requestIDHeader); id != "" && fl.status >= 400 {
+		// errors name the request they answer
+		body = bytes.ReplaceAll(body, []byte(id), []byte(w.Header().Get(requestIDHeader)))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set(dedupHeader, "shared")
+	w.WriteHeader(fl.status)
+	w.Write(body)
+	return true
+}
+
+// flightWriter records the response of a leading request for its flight.
+type flightWriter struct {
+	http.ResponseWriter
+	flight *flight
+}
+
+func (fw *flightWriter) WriteHeader(status int) {
+	if fw.flight.status == 0 {
+		fw.flight.status = status
+		fw.flight.header = fw.Header().Clone()
+	}
+	fw.ResponseWriter.WriteHeader(status)
+}
+
+func (fw *flightWriter) Write(p []byte) (int, error) {
+	if fw.flight.status == 0 {
+		fw.WriteHeader(http.StatusOK)
+	}
+	fw.flight.body.Write(p)
+	return fw.ResponseWriter.Write(p)
+}
+
+func (fw *flightWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
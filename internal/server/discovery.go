@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoverTokens is the max_tokens limit of discovered models, as of the
+// built-in ones.
+const discoverTokens = 8192
+
+// discoverTimeout bounds listing the models of one provider.
+const discoverTimeout = 10 * time.Second
+
+// nonChatModel matches IDs upstream lists next to chat models which are not
+// served on /chat/completions.
+var nonChatModel = regexp.MustCompile(`embedding|rerank|asr|tts|cogview|cogvideo|whisper|dall-e`)
+
+// discoverModels lists models of every provider and routes IDs no provider
+// configures with discoverTokens and default context. A provider which
+// fails keeps the models discovered from it before.
+func (h *handler) discoverModels(ctx context.Context) {
+	h.admin.mu.Lock()
+	opts, previous := h.admin.opts, h.admin.discovered
+	h.admin.mu.Unlock()
+	all := slices.Concat(providers, opts.Providers)
+	known := map[string]bool{}
+	for _, p := range all {
+		for _, model := range slices.Concat(slices.Collect(maps.Keys(p.Models)), p.Audio, p.Images) {
+			known[model] = true
+		}
+	}
+	_routes := h.routes.Load()
+	lists := make([][]string, len(all))
+	errs := make([]error, len(all))
+	var wg sync.WaitGroup
+	for i, p := range all {
+		key, _ := h.pick(_routes.pools[p.Name])
+		if key == "" || slices.IndexFunc(all, func(o Provider) bool { return o.Name == p.Name }) != i {
+			continue
+		}
+		wg.Go(func() {
+			lists[i], errs[i] = h.listModels(ctx, p.BaseURL, key)
+		})
+	}
+	wg.Wait()
+	found := map[string][]string{}
+	for i, p := range all {
+		switch {
+		case errs[i] != nil:
+			log.Printf("discover models of %s: %v", p.Name, errs[i])
+			found[p.Name] = previous[p.Name]
+			continue
+		case lists[i] == nil:
+			continue
+		}
+		found[p.Name] = slices.DeleteFunc(lists[i], func(id string) bool {
+			return known[id] || nonChatModel.MatchString(strings.ToLower(id))
+		})
+		if added := slices.DeleteFunc(slices.Clone(found[p.Name]), func(id string) bool {
+			return slices.Contains(previous[p.Name], id)
+		}); len(added) != 0 {
+			log.Printf("discovered models of %s: %s", p.Name, strings.Join(added, ", "))
+		}
+	}
+
+	h.admin.mu.Lock()
+	defer h.admin.mu.Unlock()
+	if maps.EqualFunc(found, h.admin.discovered, slices.Equal) {
+		return
+	}
+	h.admin.discovered = found
+	_routes, err := newRoutes(h.admin.apply(h.admin.opts), h.store)
+	if err != nil {
+		log.Println("discover models error:", err)
+		return
+	}
+	h.routes.Store(_routes)
+}
+
+// listModels returns sorted model IDs of OpenAI compatible GET /models of
+// baseURL.
+func (h *handler) listModels(ctx context.Context, baseURL, key string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoverTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+		return nil, fmt.Errorf("upstream %d: %s", resp.StatusCode, upstreamMessage(resp.StatusCode, body))
+	}
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decode models: %w", err)
+	}
+	var ids []string
+	for _, model := range list.Data {
+		if id := strings.TrimSpace(model.ID); id != "" && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+// discover lists upstream models every interval until ctx is done.
+func (h *handler) discover(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.discoverModels(ctx)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const dryRunHeader = "X-Freeglm-Dry-Run"
+
+// dryRun reports whether the request is answered without calling upstream,
+// for all requests with --dry-run or per request with the header.
+func (h *handler) dryRun(r *http.Request) bool {
+	if h.dryRunAll {
+		return true
+	}
+	on, _ := strconv.ParseBool(r.Header.Get(dryRunHeader))
+	return on
+}
+
+// dryRunResponse fakes the upstream response with the request which would
+// be sent as assistant content, so it goes through the usual response path
+// and reaches the client in its own format, streamed or not.
+func dryRunResponse(r *http.Request, c *call, payload map[string]json.RawMessage) *http.Response {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	encodeJSONMap(buf, payload)
+	request := mustMarshal(map[string]any{
+		"url":      c.config.URL,
+		"provider": c.config.Provider,
+		"headers": map[string]string{
+			"Authorization": "Bearer " + maskKey(c.token),
+			"Content-Type":  "application/json; charset=utf-8",
+			requestIDHeader: requestID(r.Context()),
+		},
+		"body": json.RawMessage(buf.Bytes()),
+	})
+	var content bytes.Buffer
+	json.Indent(&content, request, "", "  ")
+	return fakeResponse(r, c, "dryrun-", map[string]any{"role": "assistant", "content": content.String()}, "stop")
+}
+
+// fakeResponse is an upstream response with message and finish reason and
+// no usage, streamed when the client asked for a stream.
+func fakeResponse(r *http.Request, c *call, prefix string, message map[string]any, finish string) *http.Response {
+	id, created := randomID(prefix, 24), time.Now().Unix()
+	zero := map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0}
+	var body []byte
+	header := http.Header{}
+	if c.stream {
+		chunk := func(delta map[string]any, finish, used any) []byte {
+			event := map[string]any{
+				"id":      id,
+				"created": created,
+				"model":   c.model,
+				"choices": []any{map[string]any{"index": 0, "delta": delta, "finish_reason": finish}},
+			}
+			if used != nil {
+				event["usage"] = used
+			}
+			return append(append([]byte("data: "), mustMarshal(event)...), "\n\n"...)
+		}
+		body = append(chunk(message, nil, nil), chunk(map[string]any{}, finish, zero)...)
+		body = append(body, "data: [DONE]\n\n"...)
+		header.Set("Content-Type", "text/event-stream")
+	} else {
+		body = mustMarshal(map[string]any{
+			"id":      id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   c.model,
+			"choices": []any{map[string]any{"index": 0, "message": message, "finish_reason": finish}},
+			"usage":   zero,
+		})
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
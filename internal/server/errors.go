@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// OpenAI error types, SDKs branch on them to decide about retries.
+const (
+	invalidRequestError = "invalid_request_error"
+	authenticationError = "authentication_error"
+	permissionError     = "permission_error"
+	rateLimitError      = "rate_limit_error"
+	insufficientQuota   = "insufficient_quota"
+	serverError         = "server_error"
+)
+
+// OpenAI error codes answered for more than a status.
+const (
+	codeRateLimit     = "rate_limit_exceeded"
+	codeContextLength = "context_length_exceeded"
+	codeContentFilter = "content_filter"
+	codeInvalidKey    = "invalid_api_key"
+	codeModelNotFound = "model_not_found"
+)
+
+// errorTypes are the types of codes which are not derived from the status.
+var errorTypes = map[string]string{
+	insufficientQuota: insufficientQuota,
+	codeRateLimit:     rateLimitError,
+	codeContextLength: invalidRequestError,
+	codeContentFilter: invalidRequestError,
+	codeInvalidKey:    authenticationError,
+	codeModelNotFound: invalidRequestError,
+}
+
+// statusCode is the error code of status.
+func statusCode(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return codeInvalidKey
+	case http.StatusForbidden:
+		return "permission_denied"
+	case http.StatusNotFound:
+		return "not_found"
+	case http.StatusMethodNotAllowed:
+		return "method_not_allowed"
+	case http.StatusConflict:
+		return "conflict"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusUnsupportedMediaType:
+		return "unsupported_media_type"
+	case http.StatusTooManyRequests:
+		return codeRateLimit
+	case http.StatusBadGateway:
+		return "upstream_error"
+	case http.StatusServiceUnavailable:
+		return "service_unavailable"
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return "timeout"
+	}
+	if status >= 500 {
+		return "internal_error"
+	}
+	return "invalid_request"
+}
+
+func errorType(status int, code string) string {
+	if kind, ok := errorTypes[code]; ok {
+		return kind
+	}
+	switch {
+	case status == http.StatusUnauthorized:
+		return authenticationError
+	case status == http.StatusForbidden:
+		return permissionError
+	case status == http.StatusTooManyRequests:
+		return rateLimitError
+	case status >= 500:
+		return serverError
+	}
+	return invalidRequestError
+}
+
+// messageParam matches the request field validation messages start with,
+// like "tools[0].function.name must match ...".
+var messageParam = regexp.MustCompile(`^([a-z_]+(?:\[\d+\]|\.[a-z_]+)*) (?:must|is|are) `)
+
+// errorParam is the request field a 400 message is about, "" when it
+// names none.
+func errorParam(status int, message string) string {
+	if status != http.StatusBadRequest {
+		return ""
+	}
+	if m := messageParam.FindStringSubmatch(message); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// sendErrorJSON answers an OpenAI error with type and code of status and
+// param taken from a validation message.
+func (h *handler) sendErrorJSON(w http.ResponseWriter, status int, message string) {
+	h.sendError(w, status, statusCode(status), errorParam(status, message), message)
+}
+
+func (h *handler) sendError(w http.ResponseWriter, status int, code, param, message string) {
+	body := map[string]any{
+		"message": message,
+		"type":    errorType(status, code),
+		"param":   nil,
+		"code":    code,
+	}
+	if param != "" {
+		body["param"] = param
+	}
+	if id := w.Header().Get(requestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	h.sendJSON(w, status, map[string]any{"error": body})
+}
+
+// sendLimitError answers 429 for a rate limit or a used up token quota with
+// code codeRateLimit or insufficientQuota, clients should not retry before
+// Retry-After.
+func (h *handler) sendLimitError(w http.ResponseWriter, code, message string, retry time.Duration) {
+	if retry > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	}
+	h.sendError(w, http.StatusTooManyRequests, code, "", message)
+}
+
+// glmErrorCodes map GLM business error codes to OpenAI codes.
+var glmErrorCodes = map[string]string{
+	"1000": codeInvalidKey,
+	"1001": codeInvalidKey,
+	"1002": codeInvalidKey,
+	"1003": codeInvalidKey,
+	"1113": insufficientQuota,
+	"1211": codeModelNotFound,
+	"1261": codeContextLength,
+	"1301": codeContentFilter,
+	"1302": codeRateLimit,
+	"1303": codeRateLimit,
+	"1305": codeRateLimit,
+}
+
+// upstreamCode is the OpenAI code of an upstream error body, GLM sends its
+// own numeric codes and OpenAI compatible providers theirs.
+func upstreamCode(status int, body []byte) string {
+	var parsed struct {
+		Error struct {
+			Code json.RawMessage `json:"code"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Error.Code) != 0 {
+		code := stringValue(parsed.Error.Code, string(parsed.Error.Code))
+		if mapped, ok := glmErrorCodes[code]; ok {
+			return mapped
+		}
+		if _, ok := errorTypes[code]; ok {
+			return code
+		}
+	}
+	return statusCode(status)
+}
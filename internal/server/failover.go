@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeInterval is how often an upstream URL marked down is checked.
+const probeInterval = 10 * time.Second
+
+// upstreams tracks health of provider URLs with fallbacks, a URL failing
+// with connection error or 502/503/504 is skipped until a probe of its
+// /models answers.
+type upstreams struct {
+	client *http.Client
+
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func newUpstreams(client *http.Client) *upstreams {
+	return &upstreams{client: client, down: map[string]bool{}}
+}
+
+// failoverGroups maps every provider URL to the list of all its URLs.
+func failoverGroups(models map[string]GLMConfig) map[string][]string {
+	groups := map[string][]string{}
+	for _, config := range models {
+		if len(config.Fallbacks) == 0 {
+			continue
+		}
+		group := append([]string{config.URL}, config.Fallbacks...)
+		for _, u := range group {
+			groups[u] = group
+		}
+	}
+	return groups
+}
+
+// order returns the group URLs which are up first, the down ones stay as
+// the last resort.
+func (u *upstreams) order(group []string) []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	out := make([]string, 0, len(group))
+	for _, target := range group {
+		if !u.down[target] {
+			out = append(out, target)
+		}
+	}
+	for _, target := range group {
+		if u.down[target] {
+			out = append(out, target)
+		}
+	}
+	return out
+}
+
+func (u *upstreams) fail(target string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.down[target] {
+		return
+	}
+	u.down[target] = true
+	log.Printf("upstream %s is down", target)
+	go u.probe(target)
+}
+
+func (u *upstreams) ok(target string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.down[target] {
+		delete(u.down, target)
+		log.Printf("upstream %s is back", target)
+	}
+}
+
+// reset marks all upstreams up, running probes stop on their next tick.
+func (u *upstreams) reset() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	clear(u.down)
+}
+
+func (u *upstreams) probe(target string) {
+	models := strings.TrimSuffix(target, "/chat/completions") + "/models"
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		u.mu.Lock()
+		down := u.down[target]
+		u.mu.Unlock()
+		if !down {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), probeInterval)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, models, nil)
+		if err == nil {
+			var resp *http.Response
+			if resp, err = u.client.Do(req); err == nil {
+				resp.Body.Close()
+				if resp.StatusCode < 500 {
+					u.ok(target)
+				}
+			}
+		}
+		cancel()
+	}
+}
+
+// sendFailover sends the request to the first healthy URL of its failover
+// group and moves on to the next URL on connection error or 502/503/504,
+// only the response of the last URL is returned as is. URLs with open
+// circuit are skipped, the last one fails with circuitOpenError.
+func (h *handler) sendFailover(req *http.Request, model string, fresh bool) (*http.Response, error) {
+	primary := req.URL.String()
+	targets := h.upstreams.order(h.routesFor(req.Context()).failover[primary])
+	if len(targets) == 0 {
+		targets = []string{primary}
+	}
+	for i, target := range targets {
+		if err := h.breakers.allow(target); err != nil {
+			if i == len(targets)-1 {
+				return nil, err
+			}
+			logf(req.Context(), "%s skipping %s: circuit open", model, target)
+			continue
+		}
+		if i > 0 || fresh || target != primary {
+			next, err := retarget(req, target)
+			if err != nil {
+				return nil, err
+			}
+			req = next
+		}
+		resp, err := h.client.Do(req)
+		if err == nil {
+			if err = decodeBody(resp); err != nil {
+				resp.Body.Close()
+				resp = nil
+			}
+		}
+		if err == nil && !retryable(resp.StatusCode) {
+			h.breakers.success(target)
+			if len(targets) > 1 {
+				h.upstreams.ok(target)
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			h.breakers.abort(target)
+			return resp, err
+		}
+		h.breakers.failure(target)
+		if len(targets) > 1 {
+			h.upstreams.fail(target)
+		}
+		if i == len(targets)-1 {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		logf(req.Context(), "%s failing over from %s to %s", model, target, targets[i+1])
+	}
+	return nil, nil
+}
+
+// retarget clones the request to the URL with a new body from GetBody.
+func retarget(req *http.Request, target string) (*http.Request, error) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next := req.Clone(req.Context())
+	next.Body = body
+	next.URL, next.Host = parsed, parsed.Host
+	return next, nil
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// fairHalfLife halves the tokens counted for a client, so it is judged
+	// by its recent traffic.
+	fairHalfLife = 5 * time.Minute
+	// fairRequestCost is the tokens an in flight request counts for, its
+	// usage is not known yet.
+	fairRequestCost = 1000
+)
+
+// fairness tracks the consumption of every client (virtual key or IP) with
+// --fair: clients above the mean are kept on a key of their own and wait
+// behind lighter clients for lane slots, so a chatty client can not take the
+// healthiest keys and all slots from the others.
+type fairness struct {
+	mu      sync.Mutex
+	clients map[string]*consumption
+	swept   time.Time
+}
+
+type consumption struct {
+	tokens float64
+	last   time.Time
+	active int
+}
+
+func newFairness(on bool) *fairness {
+	if !on {
+		return nil
+	}
+	return &fairness{clients: map[string]*consumption{}, swept: time.Now()}
+}
+
+// fairClient identifies the client of a request, a virtual key covers all
+// its IPs.
+func fairClient(r *http.Request) string {
+	if name := virtualKeyName(r.Context()); name != "" {
+		return "virtual:" + name
+	}
+	return "ip:" + clientIP(r)
+}
+
+// get returns the decayed consumption of client, f.mu is held.
+func (f *fairness) get(client string, now time.Time) *consumption {
+	c, ok := f.clients[client]
+	if !ok {
+		c = &consumption{last: now}
+		f.clients[client] = c
+	}
+	c.tokens *= math.Exp2(-now.Sub(c.last).Seconds() / fairHalfLife.Seconds())
+	c.last = now
+	return c
+}
+
+// weight is rounded to whole tokens, so requests of one client weigh the
+// same while waiting.
+func (c *consumption) weight() float64 {
+	return math.Round(c.tokens) + float64(c.active*fairRequestCost)
+}
+
+func (f *fairness) begin(client string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(client, time.Now()).active++
+}
+
+func (f *fairness) end(client string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(client, time.Now()).active--
+}
+
+func (f *fairness) used(client string, tokens int) {
+	if f == nil || client == "" {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	f.get(client, now).tokens += float64(tokens)
+	if now.Sub(f.swept) < time.Minute {
+		return
+	}
+	for id, c := range f.clients {
+		if c.active == 0 && f.get(id, now).tokens < 1 {
+			delete(f.clients, id)
+		}
+	}
+	f.swept = now
+}
+
+// weight returns the recent consumption of client for lanes, nil without
+// --fair.
+func (f *fairness) weight(client string) func() float64 {
+	if f == nil {
+		return nil
+	}
+	return func() float64 {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.get(client, time.Now()).weight()
+	}
+}
+
+// over reports whether client consumed more than the mean of the clients
+// seen recently, a single client is never over.
+func (f *fairness) over(client string) bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	own := f.get(client, now).weight()
+	if len(f.clients) < 2 {
+		return false
+	}
+	var total float64
+	for id := range f.clients {
+		total += f.get(id, now).weight()
+	}
+	return own > total/float64(len(f.clients))
+}
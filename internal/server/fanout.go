@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+const maxChoices = 8
+
+// fanout replaces one upstream request with n > 1 choices by n parallel
+// requests, GLM ignores "n" and always answers with one choice.
+func (h *handler) fanout(req *http.Request, model string, n int, stream bool) (*http.Response, error) {
+	resps := make([]*http.Response, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clone := req.Clone(req.Context())
+			body, err := req.GetBody()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			clone.Body = body
+			resps[i], errs[i] = h.send(clone, model)
+		}()
+	}
+	wg.Wait()
+
+	var failed *http.Response
+	var err error
+	for i := range n {
+		switch {
+		case errs[i] != nil && err == nil:
+			err = errs[i]
+		case resps[i] != nil && resps[i].StatusCode >= 400 && failed == nil:
+			failed = resps[i]
+		}
+	}
+	if err != nil || failed != nil {
+		for _, resp := range resps {
+			if resp != nil && resp != failed {
+				resp.Body.Close()
+			}
+		}
+		return failed, err
+	}
+
+	merged := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        resps[0].Header.Clone(),
+		ContentLength: -1,
+		Request:       req,
+	}
+	merged.Header.Del("Content-Length")
+	if stream {
+		merged.Body = mergeStreams(resps)
+		return merged, nil
+	}
+	body, err := mergeResponses(resps)
+	if err != nil {
+		return nil, err
+	}
+	merged.Body = io.NopCloser(bytes.NewReader(body))
+	merged.ContentLength = int64(len(body))
+	return merged, nil
+}
+
+func mergeResponses(resps []*http.Response) ([]byte, error) {
+	var (
+		merged  map[string]json.RawMessage
+		choices []map[string]json.RawMessage
+		total   usage
+	)
+	for _, resp := range resps {
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		root, err := decodeJSONBytes(data)
+		if err != nil {
+			return nil, err
+		}
+		if merged == nil {
+			merged = root
+		}
+		for _, choice := range decodeArray(root["choices"]) {
+			choice["index"] = rawJSON(len(choices))
+			choices = append(choices, choice)
+		}
+		total.add(extractUsage(root))
+	}
+	merged["choices"] = mustMarshal(choices)
+	merged["usage"] = total.raw()
+	return marshal(merged)
+}
+
+type indexed struct {
+	index   int
+	payload []byte
+}
+
+// mergeStreams interleaves upstream SSE streams into one, choices of the
+// i-th stream get index i and usage is summed into the last chunk.
+func mergeStreams(resps []*http.Response) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		done := make(chan struct{})
+		defer close(done)
+		for _, resp := range resps {
+			defer resp.Body.Close()
+		}
+
+		merged := make(chan indexed)
+		var (
+			wg      sync.WaitGroup
+			mu      sync.Mutex
+			readErr error
+		)
+		for i, resp := range resps {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				upstream := readEvents(resp.Body, done)
+				for payload := range upstream.data {
+					select {
+					case merged <- indexed{index: i, payload: payload}:
+					case <-done:
+						return
+					}
+				}
+				if upstream.err != nil {
+					mu.Lock()
+					readErr = upstream.err
+					mu.Unlock()
+				}
+			}()
+		}
+		go func() {
+			wg.Wait()
+			close(merged)
+		}()
+
+		var total usage
+		for item := range merged {
+			if bytes.Equal(item.payload, []byte("[DONE]")) {
+				continue
+			}
+			chunk, err := decodeJSONBytes(item.payload)
+			if err != nil {
+				continue
+			}
+			total.add(extractUsage(chunk))
+			delete(chunk, "usage")
+			delete(chunk, "id")
+			choices := decodeArray(chunk["choices"])
+			if len(choices) == 0 {
+				continue
+			}
+			for _, choice := range choices {
+				choice["index"] = rawJSON(item.index)
+			}
+			chunk["choices"] = mustMarshal(choices)
+			if _, err := fmt.Fprintf(pw, "data: %s\n\n", mustMarshal(chunk)); err != nil {
+				return
+			}
+		}
+		if total.total != 0 {
+			fmt.Fprintf(pw, "data: %s\n\n", mustMarshal(map[string]json.RawMessage{
+				"choices": json.RawMessage("[]"),
+				"usage":   total.raw(),
+			}))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if readErr != nil {
+			pw.CloseWithError(readErr)
+			return
+		}
+		fmt.Fprintf(pw, "data: [DONE]\n\n")
+		pw.Close()
+	}()
+	return pr
+}
+
+func (u *usage) add(n usage) {
+	u.prompt += n.prompt
+	u.completion += n.completion
+	u.total += n.total
+	u.cached += n.cached
+}
+
+func (u usage) raw() json.RawMessage {
+	raw := map[string]any{
+		"prompt_tokens":     u.prompt,
+		"completion_tokens": u.completion,
+		"total_tokens":      u.total,
+	}
+	if u.cached > 0 {
+		raw["prompt_tokens_details"] = map[string]int{"cached_tokens": u.cached}
+	}
+	return mustMarshal(raw)
+}
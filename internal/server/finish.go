@@ -0,0 +1,62 @@
+package server
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// finishRawField keeps the upstream finish_reason when it was changed.
+const finishRawField = "freeglm_finish_reason_raw"
+
+// finishReasons are the values OpenAI clients know.
+var finishReasons = []string{"stop", "length", "tool_calls", "content_filter", "function_call"}
+
+// glmFinishReasons maps GLM and other upstream finish reasons, lower case,
+// to OpenAI values, unknown ones end as "stop". A generation cut off by a
+// network error is incomplete, so it ends as "length".
+var glmFinishReasons = map[string]string{
+	"sensitive":                     "content_filter",
+	"content_filtered":              "content_filter",
+	"safety":                        "content_filter",
+	"model_context_window_exceeded": "length",
+	"max_tokens":                    "length",
+	"max_output_tokens":             "length",
+	"context_length_exceeded":       "length",
+	"network_error":                 "length",
+	"tool_call":                     "tool_calls",
+	"tool_use":                      "tool_calls",
+	"function_calls":                "tool_calls",
+	"end_turn":                      "stop",
+	"stop_sequence":                 "stop",
+	"eos":                           "stop",
+	"停止":                            "stop",
+	"长度":                            "length",
+	"长度限制":                          "length",
+	"敏感":                            "content_filter",
+	"敏感内容":                          "content_filter",
+	"工具调用":                          "tool_calls",
+}
+
+// canonicalFinish reports whether finish_reason of the choice is missing,
+// null or an OpenAI value.
+func canonicalFinish(choice map[string]json.RawMessage) bool {
+	raw := choice["finish_reason"]
+	return isNullJSON(raw) || slices.Contains(finishReasons, stringValue(raw, ""))
+}
+
+// normalizeFinish sets finish_reason of the choice to an OpenAI value and
+// keeps the upstream one in freeglm_finish_reason_raw.
+func normalizeFinish(choice map[string]json.RawMessage) {
+	if canonicalFinish(choice) {
+		return
+	}
+	reason := strings.ToLower(strings.TrimSpace(stringValue(choice["finish_reason"], "")))
+	if !slices.Contains(finishReasons, reason) {
+		if reason = glmFinishReasons[reason]; reason == "" {
+			reason = "stop"
+		}
+	}
+	choice[finishRawField] = choice["finish_reason"]
+	choice["finish_reason"] = rawJSON(reason)
+}
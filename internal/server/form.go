@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// formMemory is the part of a multipart body kept in memory, files over it
+// are buffered on disk until the request is decoded.
+const formMemory = 8 << 20
+
+var errUnsupportedMedia = errors.New("unsupported Content-Type")
+
+// formStrings are fields whose form values are always strings, values of
+// other fields are JSON when they parse as JSON.
+var formStrings = []string{"model", "user", "message", "content", "system", "suffix"}
+
+// decodePayload decodes a request body by its Content-Type: JSON (also when
+// the type is missing or text/plain), URL encoded or multipart form.
+func decodePayload(r *http.Request) (map[string]json.RawMessage, error) {
+	contentType := r.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil && contentType != "" {
+		return nil, fmt.Errorf("%w %q", errUnsupportedMedia, contentType)
+	}
+	switch {
+	case mediaType == "", mediaType == "application/json", mediaType == "text/plain", strings.HasSuffix(mediaType, "+json"):
+		return decodeJSONMap(r.Body)
+	case mediaType == "application/x-www-form-urlencoded":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		// curl -d sends JSON with the form type
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			return decodeJSONBytes(data)
+		}
+		values, err := url.ParseQuery(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return formPayload(values, nil)
+	case mediaType == "multipart/form-data":
+		if params["boundary"] == "" {
+			return nil, errors.New("multipart/form-data without boundary")
+		}
+		form, err := multipart.NewReader(r.Body, params["boundary"]).ReadForm(formMemory)
+		if err != nil {
+			return nil, err
+		}
+		defer form.RemoveAll()
+		var files []*multipart.FileHeader
+		for _, name := range slices.Sorted(maps.Keys(form.File)) {
+			files = append(files, form.File[name]...)
+		}
+		return formPayload(form.Value, files)
+	}
+	return nil, fmt.Errorf("%w %q, send application/json, application/x-www-form-urlencoded or multipart/form-data", errUnsupportedMedia, mediaType)
+}
+
+// formPayload turns form fields into a payload, repeated fields and fields
+// named like "stop[]" into arrays. Without "messages" the "system" and
+// "message" (or "content") fields become messages, files are added as
+// parts of the last user message.
+func formPayload(values url.Values, files []*multipart.FileHeader) (map[string]json.RawMessage, error) {
+	payload := map[string]json.RawMessage{}
+	for name, vals := range values {
+		field, array := strings.CutSuffix(name, "[]")
+		raws := make([]json.RawMessage, len(vals))
+		for i, value := range vals {
+			raws[i] = formValue(field, value)
+		}
+		if array || len(raws) > 1 {
+			payload[field] = mustMarshal(raws)
+		} else {
+			payload[field] = raws[0]
+		}
+	}
+	if isNullJSON(payload["messages"]) {
+		var messages []map[string]json.RawMessage
+		if system := stringValue(payload["system"], ""); system != "" {
+			messages = append(messages, systemMessage(system))
+		}
+		text := stringValue(payload["message"], stringValue(payload["content"], ""))
+		if text != "" {
+			messages = append(messages, map[string]json.RawMessage{"role": rawJSON("user"), "content": rawJSON(text)})
+		}
+		if messages != nil {
+			delete(payload, "system")
+			delete(payload, "message")
+			delete(payload, "content")
+			payload["messages"] = chatMessages(messages)
+		}
+	}
+	if len(files) == 0 {
+		return payload, nil
+	}
+	parts, err := fileParts(files)
+	if err != nil {
+		return nil, err
+	}
+	messages := decodeArray(payload["messages"])
+	last := -1
+	for i, msg := range messages {
+		if stringValue(msg["role"], "") == "user" {
+			last = i
+		}
+	}
+	if last < 0 {
+		messages = append(messages, map[string]json.RawMessage{"role": rawJSON("user"), "content": mustMarshal(parts)})
+	} else {
+		var content []any
+		if text := stringValue(messages[last]["content"], ""); text != "" {
+			content = append(content, map[string]any{"type": "text", "text": text})
+		} else {
+			json.Unmarshal(messages[last]["content"], &content)
+		}
+		messages[last]["content"] = mustMarshal(append(content, parts...))
+	}
+	payload["messages"] = chatMessages(messages)
+	return payload, nil
+}
+
+func formValue(field, value string) json.RawMessage {
+	if !slices.Contains(formStrings, field) && json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	return rawJSON(value)
+}
+
+// fileParts reads uploaded images as image_url data URL parts and text
+// files as text parts, other files are unsupported.
+func fileParts(files []*multipart.FileHeader) ([]any, error) {
+	parts := make([]any, 0, len(files))
+	for _, file := range files {
+		f, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		mediaType, _, _ := mime.ParseMediaType(file.Header.Get("Content-Type"))
+		if mediaType == "" || mediaType == "application/octet-stream" {
+			mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+		}
+		switch {
+		case strings.HasPrefix(mediaType, "image/"):
+			dataURL := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": dataURL}})
+		case strings.HasPrefix(mediaType, "text/") || utf8.Valid(data):
+			parts = append(parts, map[string]any{"type": "text", "text": string(data)})
+		default:
+			return nil, fmt.Errorf("%w %q of file %s, upload images or text", errUnsupportedMedia, mediaType, file.Filename)
+		}
+	}
+	return parts, nil
+}
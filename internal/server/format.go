@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type responseFormat struct {
+	kind   string
+	schema map[string]any
+}
+
+// translateFormat keeps json_object as is and turns json_schema, which GLM
+// does not support, into json_object with the schema in a system message.
+func translateFormat(payload map[string]json.RawMessage) (*responseFormat, error) {
+	raw := payload["response_format"]
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	format := decodeMap(raw)
+	if format == nil {
+		return nil, fmt.Errorf("response_format must be an object")
+	}
+	switch kind := stringValue(format["type"], ""); kind {
+	case "text":
+		delete(payload, "response_format")
+		return nil, nil
+	case "json_object":
+		return &responseFormat{kind: kind}, nil
+	case "json_schema":
+		var spec struct {
+			Name   string         `json:"name"`
+			Schema map[string]any `json:"schema"`
+		}
+		if err := json.Unmarshal(format["json_schema"], &spec); err != nil || spec.Schema == nil {
+			return nil, fmt.Errorf("response_format.json_schema.schema must be an object")
+		}
+		var messages []json.RawMessage
+		json.Unmarshal(payload["messages"], &messages)
+		instruction := "Respond only with a JSON value, without markdown, matching this JSON schema"
+		if spec.Name != "" {
+			instruction += fmt.Sprintf(" named %q", spec.Name)
+		}
+		instruction += ":\n" + string(mustMarshal(spec.Schema))
+		system := mustMarshal(map[string]string{"role": "system", "content": instruction})
+		payload["messages"] = mustMarshal(slices.Insert(messages, 0, system))
+		payload["response_format"] = rawJSON(map[string]string{"type": "json_object"})
+		return &responseFormat{kind: kind, schema: spec.Schema}, nil
+	default:
+		return nil, fmt.Errorf("response_format.type must be one of [text json_object json_schema]")
+	}
+}
+
+// repair checks that every choice content is JSON matching the schema,
+// content wrapped into markdown fences or prose is cut down to JSON. It
+// returns the rewritten response and whether all choices are valid.
+func (f *responseFormat) repair(body []byte) ([]byte, bool) {
+	resp, err := decodeJSONBytes(body)
+	if err != nil {
+		return body, false
+	}
+	choices := decodeArray(resp["choices"])
+	valid, changed := true, false
+	for _, choice := range choices {
+		msg := decodeMap(choice["message"])
+		if msg == nil || !isNullJSON(msg["tool_calls"]) {
+			continue
+		}
+		content := stringValue(msg["content"], "")
+		fixed, ok := f.fix(content)
+		if !ok {
+			valid = false
+			continue
+		}
+		if fixed != content {
+			msg["content"] = rawJSON(fixed)
+			choice["message"] = mustMarshal(msg)
+			changed = true
+		}
+	}
+	if !changed {
+		return body, valid
+	}
+	resp["choices"] = mustMarshal(choices)
+	out, err := marshal(resp)
+	if err != nil {
+		return body, false
+	}
+	return out, valid
+}
+
+func (f *responseFormat) fix(content string) (string, bool) {
+	candidates := []string{content}
+	trimmed := strings.TrimSpace(content)
+	if rest, ok := strings.CutPrefix(trimmed, "```"); ok {
+		rest = strings.TrimPrefix(rest, "json")
+		if end := strings.LastIndex(rest, "```"); end >= 0 {
+			candidates = append(candidates, strings.TrimSpace(rest[:end]))
+		}
+	}
+	for _, pair := range [][2]byte{{'{', '}'}, {'[', ']'}} {
+		start, end := strings.IndexByte(content, pair[0]), strings.LastIndexByte(content, pair[1])
+		if start >= 0 && end > start {
+			candidates = append(candidates, content[start:end+1])
+		}
+	}
+	for _, candidate := range candidates {
+		var value any
+		decoder := json.NewDecoder(strings.NewReader(candidate))
+		decoder.UseNumber()
+		if decoder.Decode(&value) != nil || decoder.More() {
+			continue
+		}
+		if f.schema != nil && validateSchema(value, f.schema, "$") != nil {
+			continue
+		}
+		return candidate, true
+	}
+	return content, false
+}
+
+// validateSchema checks the JSON schema keywords models get wrong most:
+// type, enum, required, properties, additionalProperties and items.
+func validateSchema(value any, schema map[string]any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) != 0 && !slices.ContainsFunc(types, func(t string) bool { return schemaType(value, t) }) {
+		return fmt.Errorf("%s must be %s", path, strings.Join(types, " or "))
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		encoded := mustMarshal(value)
+		if !slices.ContainsFunc(enum, func(v any) bool { return bytes.Equal(mustMarshal(v), encoded) }) {
+			return fmt.Errorf("%s must be one of %s", path, mustMarshal(enum))
+		}
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schemaTypes(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s is required", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		for name, item := range v {
+			if sub, ok := properties[name].(map[string]any); ok {
+				if err := validateSchema(item, sub, path+"."+name); err != nil {
+					return err
+				}
+			} else if schema["additionalProperties"] == false {
+				return fmt.Errorf("%s.%s is not allowed", path, name)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for idx, item := range v {
+				if err := validateSchema(item, items, fmt.Sprintf("%s[%d]", path, idx)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func schemaTypes(raw any) []string {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func schemaType(value any, kind string) bool {
+	switch v := value.(type) {
+	case nil:
+		return kind == "null"
+	case bool:
+		return kind == "boolean"
+	case string:
+		return kind == "string"
+	case json.Number:
+		if kind == "number" {
+			return true
+		}
+		f, err := v.Float64()
+		return kind == "integer" && err == nil && f == math.Trunc(f)
+	case []any:
+		return kind == "array"
+	case map[string]any:
+		return kind == "object"
+	}
+	return false
+}
+
+// repairFormat repairs JSON content locally and asks upstream again when the
+// repair fails, usage of every attempt is summed.
+func (h *handler) repairFormat(resp *http.Response, c *call, normalized []byte, u usage, body, out *bytes.Buffer) ([]byte, usage, error) {
+	for attempt := 0; ; attempt++ {
+		fixed, valid := c.format.repair(normalized)
+		if valid {
+			return fixed, u, nil
+		}
+		if attempt >= h.jsonRetries {
+			logf(resp.Request.Context(), "%s response is not valid %s after %d retries", c.model, c.format.kind, attempt)
+			return fixed, u, nil
+		}
+		logf(resp.Request.Context(), "%s response is not valid %s, retry %d/%d", c.model, c.format.kind, attempt+1, h.jsonRetries)
+		reqBody, err := resp.Request.GetBody()
+		if err != nil {
+			return fixed, u, nil
+		}
+		req := resp.Request.Clone(resp.Request.Context())
+		req.Body = reqBody
+		next, err := h.send(req, c.model)
+		if err != nil {
+			return fixed, u, nil
+		}
+		if next.StatusCode >= 400 {
+			next.Body.Close()
+			return fixed, u, nil
+		}
+		var more usage
+		if normalized, more, err = h.readResponse(next, c, body, out); err != nil {
+			return nil, u, err
+		}
+		u.add(more)
+	}
+}
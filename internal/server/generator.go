@@ -1,11 +1,68 @@
 package server
 
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const (
+	roundRobin = "round-robin"
+	randomKey  = "random"
+	leastUsed  = "lru"
+	weighted   = "weighted"
+)
+
+var strategies = []string{roundRobin, randomKey, leastUsed, weighted}
+
+type tracker interface {
+	used(key string, tokens int)
+}
+
+// strategy returns the key pool factory of name, with shared storage
+// round-robin and lru take turns with other replicas. Weighted counts
+// tokens spent today in store, by all replicas when it is shared.
+func strategy(name string, quota int, store storage.Storage) (func([]string) keys, error) {
+	shared := storage.Shared(store)
+	switch name {
+	case roundRobin, "":
+		if shared {
+			return func(_e []string) keys { return newSharedRobin(_e, store) }, nil
+		}
+		return Generator, nil
+	case randomKey:
+		return func(_e []string) keys { return &shuffle{e: _e} }, nil
+	case leastUsed:
+		if shared {
+			return func(_e []string) keys { return newSharedLRU(_e, store) }, nil
+		}
+		return func(_e []string) keys { return &lru{e: _e, last: make([]time.Time, len(_e))} }, nil
+	case weighted:
+		return func(_e []string) keys {
+			return &remaining{e: _e, limit: quota, spent: make([]int, len(_e)), store: store}
+		}, nil
+	}
+	return nil, fmt.Errorf("key strategy must be one of %v", strategies)
+}
+
 type robin struct {
-	e []string
-	i int
+	mu sync.Mutex
+	e  []string
+	i  int
 }
 
 func (g *robin) next() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.e) == 0 {
+		return ""
+	}
 	v := g.e[g.i]
 	g.i += 1
 	if g.i > len(g.e)-1 {
@@ -13,3 +70,125 @@ func (g *robin) next() string {
 	}
 	return v
 }
+
+func (g *robin) all() []string {
+	return g.e
+}
+
+type shuffle struct {
+	e []string
+}
+
+func (g *shuffle) next() string {
+	if len(g.e) == 0 {
+		return ""
+	}
+	return g.e[rand.Intn(len(g.e))]
+}
+
+func (g *shuffle) all() []string {
+	return g.e
+}
+
+type lru struct {
+	mu   sync.Mutex
+	e    []string
+	last []time.Time
+}
+
+func (g *lru) next() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.e) == 0 {
+		return ""
+	}
+	i := 0
+	for j := range g.e {
+		if g.last[j].Before(g.last[i]) {
+			i = j
+		}
+	}
+	g.last[i] = time.Now()
+	return g.e[i]
+}
+
+func (g *lru) all() []string {
+	return g.e
+}
+
+type remaining struct {
+	mu    sync.Mutex
+	e     []string
+	limit int
+	spent []int
+	// store keeps spent tokens per UTC day, so they start over at midnight
+	// and survive reloads, synced is when spent was read
+	store  storage.Storage
+	synced time.Time
+}
+
+func (g *remaining) next() string {
+	if len(g.e) == 0 {
+		return ""
+	}
+	if g.store != nil {
+		g.sync()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	weights := make([]float64, len(g.e))
+	total := 0.0
+	for i, spent := range g.spent {
+		if g.limit > 0 {
+			weights[i] = float64(max(g.limit-spent, 0))
+		} else {
+			weights[i] = 1 / float64(1+spent)
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		i := 0
+		for j := range g.spent {
+			if g.spent[j] < g.spent[i] {
+				i = j
+			}
+		}
+		return g.e[i]
+	}
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			return g.e[i]
+		}
+		pick -= weight
+	}
+	return g.e[len(g.e)-1]
+}
+
+func (g *remaining) all() []string {
+	return g.e
+}
+
+// used counts tokens of key, the storage counter is added to without
+// holding g.mu so picks do not wait for it.
+func (g *remaining) used(key string, tokens int) {
+	i := slices.Index(g.e, key)
+	if i < 0 {
+		return
+	}
+	if g.store == nil {
+		g.mu.Lock()
+		g.spent[i] += tokens
+		g.mu.Unlock()
+		return
+	}
+	spent, err := g.store.Incr(context.Background(), spentKey(key, time.Now()), int64(tokens), 48*time.Hour)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err != nil {
+		log.Println("shared key usage error:", err)
+		g.spent[i] += tokens
+		return
+	}
+	g.spent[i] = int(spent)
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readyTTL is how long the upstream key check of /readyz is cached, probes
+// every few seconds must not spend a completion each.
+const readyTTL = 30 * time.Second
+
+type readiness struct {
+	upstream bool
+
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+func (h *handler) handleLive(w http.ResponseWriter) {
+	h.sendJSON(w, http.StatusOK, map[string]any{
+		"status": "ok",
+		"models": slices.Collect(maps.Keys(h.routes.Load().models)),
+	})
+}
+
+func (h *handler) handleReady(w http.ResponseWriter, r *http.Request) {
+	if err := h.ready(r.Context()); err != nil {
+		h.sendJSON(w, http.StatusServiceUnavailable, map[string]any{
+			"status": "unavailable",
+			"error":  err.Error(),
+		})
+		return
+	}
+	h.sendJSON(w, http.StatusOK, map[string]any{"status": "ready"})
+}
+
+// ready checks that models are routed and, with upstream check enabled,
+// that at least one key of any provider gets a completion.
+func (h *handler) ready(ctx context.Context) error {
+	_routes := h.routes.Load()
+	if len(_routes.models) == 0 {
+		return errors.New("no models configured")
+	}
+	if !h.readiness.upstream {
+		return nil
+	}
+	h.readiness.mu.Lock()
+	defer h.readiness.mu.Unlock()
+	if time.Since(h.readiness.checked) < readyTTL {
+		return h.readiness.err
+	}
+
+	var failures []string
+	checked := map[string]bool{}
+	for _, model := range slices.Sorted(maps.Keys(_routes.models)) {
+		config := _routes.models[model]
+		if checked[config.Provider] {
+			continue
+		}
+		checked[config.Provider] = true
+		key, _ := h.pick(_routes.pools[config.Provider])
+		if key == "" {
+			continue
+		}
+		k := KeyCheck{Provider: config.Provider, Model: model, Key: key}
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		k.check(checkCtx, h.client, config.URL)
+		cancel()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if k.Status == KeyValid || k.Status == KeyLimited {
+			h.readiness.checked, h.readiness.err = time.Now(), nil
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s %s", k.Provider, k.Status, k.Message))
+	}
+	err := errors.New("no provider has a key")
+	if len(failures) != 0 {
+		err = fmt.Errorf("no valid key: %s", strings.Join(failures, "; "))
+	}
+	h.readiness.checked, h.readiness.err = time.Now(), err
+	return err
+}
@@ -0,0 +1,247 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"plugin"
+	"runtime"
+	"sync"
+)
+
+// Hook is any value implementing one or more of RequestHook, ResponseHook
+// and StreamChunkHook.
+type Hook any
+
+// RequestHook rewrites the chat completion request before it is sent
+// upstream, an error rejects the request with 400.
+type RequestHook interface {
+	Request(r *http.Request, payload map[string]json.RawMessage) error
+}
+
+// ResponseHook rewrites the normalized non-stream chat completion, an error
+// is returned to the client as 502.
+type ResponseHook interface {
+	Response(r *http.Request, body map[string]json.RawMessage) error
+}
+
+// StreamChunkHook rewrites the normalized stream chunk, returning false
+// drops the chunk.
+type StreamChunkHook interface {
+	StreamChunk(r *http.Request, chunk map[string]json.RawMessage) (bool, error)
+}
+
+type hooks struct {
+	request  []RequestHook
+	response []ResponseHook
+	chunk    []StreamChunkHook
+}
+
+func newHooks(list []Hook) (hooks, error) {
+	var h hooks
+	for _, hook := range list {
+		matched := false
+		if v, ok := hook.(RequestHook); ok {
+			h.request, matched = append(h.request, v), true
+		}
+		if v, ok := hook.(ResponseHook); ok {
+			h.response, matched = append(h.response, v), true
+		}
+		if v, ok := hook.(StreamChunkHook); ok {
+			h.chunk, matched = append(h.chunk, v), true
+		}
+		if !matched {
+			return hooks{}, fmt.Errorf("hook %T implements none of RequestHook, ResponseHook, StreamChunkHook", hook)
+		}
+	}
+	return h, nil
+}
+
+func (h hooks) onRequest(r *http.Request, payload map[string]json.RawMessage) error {
+	for _, hook := range h.request {
+		if err := hook.Request(r, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h hooks) onResponse(r *http.Request, body []byte) ([]byte, error) {
+	if len(h.response) == 0 {
+		return body, nil
+	}
+	resp, err := decodeJSONBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range h.response {
+		if err := hook.Response(r, resp); err != nil {
+			return nil, err
+		}
+	}
+	return marshal(resp)
+}
+
+// onChunk rewrites the JSON chunk written to buf after offset in place.
+func (h hooks) onChunk(r *http.Request, buf *bytes.Buffer, offset int) (bool, error) {
+	if len(h.chunk) == 0 {
+		return true, nil
+	}
+	chunk, err := decodeJSONBytes(bytes.Clone(buf.Bytes()[offset:]))
+	if err != nil {
+		return false, err
+	}
+	for _, hook := range h.chunk {
+		keep, err := hook.StreamChunk(r, chunk)
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	buf.Truncate(offset)
+	return true, encodeJSONMap(buf, chunk)
+}
+
+// LoadPlugin opens a Go plugin built with -buildmode=plugin and returns its
+// exported "Hook" symbol.
+func LoadPlugin(path string) (Hook, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Hook")
+	if err != nil {
+		return nil, err
+	}
+	return sym, nil
+}
+
+// ScriptHook runs an external command and talks to it with one JSON object
+// per line: {"hook":"request","data":{...}} is answered with {"data":{...}}
+// to replace data, {} to keep it, {"drop":true} to drop a stream chunk or
+// {"error":"..."} to fail the request.
+type ScriptHook struct {
+	command string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+}
+
+type scriptReply struct {
+	Data  map[string]json.RawMessage `json:"data"`
+	Drop  bool                       `json:"drop"`
+	Error string                     `json:"error"`
+}
+
+func NewScriptHook(command string) *ScriptHook {
+	return &ScriptHook{command: command}
+}
+
+func (s *ScriptHook) Request(r *http.Request, payload map[string]json.RawMessage) error {
+	_, err := s.call(r.Context(), "request", payload)
+	return err
+}
+
+func (s *ScriptHook) Response(r *http.Request, body map[string]json.RawMessage) error {
+	_, err := s.call(r.Context(), "response", body)
+	return err
+}
+
+func (s *ScriptHook) StreamChunk(r *http.Request, chunk map[string]json.RawMessage) (bool, error) {
+	drop, err := s.call(r.Context(), "stream_chunk", chunk)
+	return !drop, err
+}
+
+func (s *ScriptHook) start() error {
+	shell := []string{"sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+	cmd := exec.Command(shell[0], shell[1], s.command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd, s.stdin, s.stdout = cmd, stdin, bufio.NewReader(stdout)
+	return nil
+}
+
+func (s *ScriptHook) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	s.cmd.Process.Kill()
+	s.cmd.Wait()
+	s.cmd = nil
+}
+
+func (s *ScriptHook) call(ctx context.Context, hook string, data map[string]json.RawMessage) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd == nil {
+		if err := s.start(); err != nil {
+			return false, fmt.Errorf("hook script: %w", err)
+		}
+	}
+	line, err := marshal(map[string]any{"hook": hook, "data": data})
+	if err != nil {
+		return false, err
+	}
+	if _, err := s.stdin.Write(append(line, '\n')); err != nil {
+		s.stop()
+		return false, fmt.Errorf("hook script: %w", err)
+	}
+
+	type result struct {
+		line []byte
+		err  error
+	}
+	read := make(chan result, 1)
+	go func() {
+		line, err := s.stdout.ReadBytes('\n')
+		read <- result{line, err}
+	}()
+	var res result
+	select {
+	case res = <-read:
+	case <-ctx.Done():
+		s.stop()
+		return false, ctx.Err()
+	}
+	if res.err != nil {
+		s.stop()
+		return false, fmt.Errorf("hook script: %w", res.err)
+	}
+
+	var reply scriptReply
+	if err := json.Unmarshal(res.line, &reply); err != nil {
+		logf(ctx, "hook script reply is not JSON: %s", res.line)
+		return false, fmt.Errorf("hook script: %w", err)
+	}
+	if reply.Error != "" {
+		return false, errors.New(reply.Error)
+	}
+	if reply.Data != nil {
+		clear(data)
+		maps.Copy(data, reply.Data)
+	}
+	return reply.Drop, nil
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const cogView = "cogview-4-250304"
+
+// openAIImages are OpenAI image models mapped to CogView.
+var openAIImages = map[string]bool{
+	"dall-e-2":         true,
+	"dall-e-3":         true,
+	"gpt-image-1":      true,
+	"gpt-image-1-mini": true,
+}
+
+// imageSizes maps OpenAI sizes to the closest CogView ones, other WxH sizes
+// are sent as is.
+var imageSizes = map[string]string{
+	"":          "1024x1024",
+	"auto":      "1024x1024",
+	"256x256":   "512x512",
+	"1792x1024": "1344x768",
+	"1024x1792": "768x1344",
+	"1536x1024": "1344x896",
+	"1024x1536": "896x1344",
+}
+
+func isImagesPath(path string) bool {
+	return strings.TrimPrefix(path, "/v1") == "/images/generations"
+}
+
+func (rt *routes) imageModel(name string) (string, GLMConfig) {
+	if config, ok := rt.images[name]; ok && !openAIImages[name] {
+		return name, config
+	}
+	return cogView, rt.images[cogView]
+}
+
+// imageQuality maps OpenAI quality to CogView standard or hd.
+func imageQuality(quality string) string {
+	switch quality {
+	case "hd", "high":
+		return "hd"
+	}
+	return "standard"
+}
+
+// handleImages sends one CogView request per image as CogView generates a
+// single image, b64_json is made by downloading the image URL.
+func (h *handler) handleImages(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	prompt := stringValue(payload["prompt"], "")
+	if prompt == "" {
+		h.sendErrorJSON(w, http.StatusBadRequest, "prompt is required")
+		return
+	}
+	n := 1
+	if raw, ok := payload["n"]; ok && !isNullJSON(raw) {
+		if n, ok = intValue(raw); !ok || n < 1 || n > maxChoices {
+			h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("n must be between 1 and %d", maxChoices))
+			return
+		}
+	}
+	format := stringValue(payload["response_format"], "url")
+	if format != "url" && format != "b64_json" {
+		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("response_format %q is not supported", format))
+		return
+	}
+	size := stringValue(payload["size"], "")
+	if mapped, ok := imageSizes[size]; ok {
+		size = mapped
+	}
+	model, config := h.routesFor(r.Context()).imageModel(stringValue(payload["model"], ""))
+	request := map[string]any{
+		"model":   model,
+		"prompt":  prompt,
+		"size":    size,
+		"quality": imageQuality(stringValue(payload["quality"], "")),
+	}
+	if user := stringValue(payload["user"], ""); user != "" {
+		request["user_id"] = user
+	}
+	body, err := marshal(request)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	start := time.Now()
+	data := make([]map[string]string, 0, n)
+	for range n {
+		image, ok := h.generateImage(w, r, config, model, body)
+		if !ok {
+			return
+		}
+		if format == "b64_json" {
+			encoded, err := h.downloadImage(r, image)
+			if err != nil {
+				h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Image download: %v", err))
+				return
+			}
+			data = append(data, map[string]string{"b64_json": encoded})
+			continue
+		}
+		data = append(data, map[string]string{"url": image})
+	}
+	logf(r.Context(), "%s -> %d images, %.1fs", model, n, time.Since(start).Seconds())
+	h.sendJSON(w, http.StatusOK, map[string]any{
+		"created": time.Now().Unix(),
+		"data":    data,
+	})
+}
+
+// generateImage returns URL of one generated image, on failure the error is
+// already written to w.
+func (h *handler) generateImage(w http.ResponseWriter, r *http.Request, config GLMConfig, model string, body []byte) (string, bool) {
+	resp, release := h.sendMedia(w, r, config, model, config.URL+"/images/generations", "application/json; charset=utf-8", body)
+	if resp == nil {
+		return "", false
+	}
+	defer release()
+	defer resp.Body.Close()
+	var result struct {
+		Data []struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	}
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err == nil {
+		err = json.Unmarshal(raw, &result)
+	}
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Invalid response: %v", err))
+		return "", false
+	}
+	if len(result.Data) == 0 || result.Data[0].URL == "" {
+		h.sendErrorJSON(w, http.StatusBadGateway, "Invalid response: no image url")
+		return "", false
+	}
+	return result.Data[0].URL, true
+}
+
+func (h *handler) downloadImage(r *http.Request, url string) (string, error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s answered %d", url, resp.StatusCode)
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, 32<<20))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(image), nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	priorityHeader = "X-Priority"
+	defaultLane    = "normal"
+)
+
+// lanes give every priority class its own concurrency budget, so long agent
+// jobs in "low" lane can not take the slots of editor requests in "high".
+type lanes map[string]*lane
+
+// lane hands a freed slot to the waiting request with the lowest weight at
+// that time, in arrival order among equal weights.
+type lane struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	seq     uint64
+	waiting []*laneWaiter
+}
+
+type laneWaiter struct {
+	weight func() float64
+	seq    uint64
+	ready  chan struct{}
+}
+
+func newLanes(budgets map[string]int) (lanes, error) {
+	if len(budgets) == 0 {
+		return nil, nil
+	}
+	l := lanes{}
+	for name, n := range budgets {
+		if n < 1 {
+			return nil, fmt.Errorf("lane %s concurrency must be positive", name)
+		}
+		l[strings.ToLower(name)] = &lane{limit: n}
+	}
+	if _, ok := l[defaultLane]; !ok {
+		return nil, fmt.Errorf("lane %q for requests without %s header is required", defaultLane, priorityHeader)
+	}
+	return l, nil
+}
+
+// acquire waits for a free slot in the lane from X-Priority header and
+// returns the release func, it fails on unknown lane or canceled request.
+// weight orders waiting requests (see fairness), nil keeps arrival order.
+func (l lanes) acquire(r *http.Request, weight func() float64) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	name := strings.ToLower(strings.TrimSpace(r.Header.Get(priorityHeader)))
+	if name == "" {
+		name = defaultLane
+	}
+	ln, ok := l[name]
+	if !ok {
+		return nil, fmt.Errorf("%s must be one of %v", priorityHeader, slices.Sorted(maps.Keys(l)))
+	}
+	ln.mu.Lock()
+	if ln.active < ln.limit && len(ln.waiting) == 0 {
+		ln.active++
+		ln.mu.Unlock()
+		return ln.release, nil
+	}
+	ln.seq++
+	waiter := &laneWaiter{weight: weight, seq: ln.seq, ready: make(chan struct{})}
+	ln.waiting = append(ln.waiting, waiter)
+	ln.mu.Unlock()
+	start := time.Now()
+	select {
+	case <-waiter.ready:
+		logf(r.Context(), "%s lane waited %.1fs for a slot", name, time.Since(start).Seconds())
+		return ln.release, nil
+	case <-r.Context().Done():
+		ln.mu.Lock()
+		idx := slices.Index(ln.waiting, waiter)
+		if idx >= 0 {
+			ln.waiting = slices.Delete(ln.waiting, idx, idx+1)
+		}
+		ln.mu.Unlock()
+		if idx < 0 {
+			// the slot was handed over meanwhile
+			ln.release()
+		}
+		return nil, r.Context().Err()
+	}
+}
+
+// release passes the slot to the next waiting request or frees it.
+func (ln *lane) release() {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+	if len(ln.waiting) == 0 {
+		ln.active--
+		return
+	}
+	weights := map[*laneWaiter]float64{}
+	for _, w := range ln.waiting {
+		if w.weight != nil {
+			weights[w] = w.weight()
+		}
+	}
+	next := slices.MinFunc(ln.waiting, func(a, b *laneWaiter) int {
+		return cmp.Or(cmp.Compare(weights[a], weights[b]), cmp.Compare(a.seq, b.seq))
+	})
+	ln.waiting = slices.DeleteFunc(ln.waiting, func(w *laneWaiter) bool { return w == next })
+	close(next.ready)
+}
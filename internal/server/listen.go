@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const listenFdsStart = 3
+
+// readyFdEnv names the pipe a new process closes once it listens, see
+// Upgrade.
+const readyFdEnv = "FREEGLM_READY_FD"
+
+// ListenAndServe listens on a systemd activated socket (LISTEN_FDS), a unix
+// socket for "unix:/path.sock" or TCP address and serves HTTPS when a
+// certificate is configured.
+func (s *Server) ListenAndServe() error {
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.listener = ln
+	s.mu.Unlock()
+	ready()
+	if s.certFile != "" {
+		return s.ServeTLS(ln, s.certFile, s.keyFile)
+	}
+	return s.Serve(ln)
+}
+
+func (s *Server) TLS() bool {
+	return s.certFile != ""
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if ln, err := activated(); ln != nil || err != nil {
+		return ln, err
+	}
+	path, ok := strings.CutPrefix(s.Addr, "unix:")
+	if !ok {
+		return listenConfig(s.reusePort).Listen(context.Background(), "tcp", s.Addr)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use", path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, s.socketMode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+func activated() (net.Listener, error) {
+	fds, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || fds < 1 {
+		return nil, nil
+	}
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err == nil && pid != os.Getpid() {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds > 1 {
+		return nil, errors.New("socket activation with more than one socket is not supported")
+	}
+	f := os.NewFile(listenFdsStart, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// ready tells the process upgrading to this one that it listens.
+func ready() {
+	fd, err := strconv.Atoi(os.Getenv(readyFdEnv))
+	os.Unsetenv(readyFdEnv)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
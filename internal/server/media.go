@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// mediaRegistry maps audio or image models to the base URL of their
+// provider.
+func mediaRegistry(custom []Provider, models func(Provider) []string) map[string]GLMConfig {
+	media := map[string]GLMConfig{}
+	for i, p := range slices.Concat(providers, custom) {
+		for _, model := range models(p) {
+			media[model] = GLMConfig{
+				URL:      strings.TrimSuffix(p.BaseURL, "/"),
+				Provider: p.Name,
+				Builtin:  i < len(providers),
+			}
+		}
+	}
+	return media
+}
+
+// sendMedia sends the body with the client or a pool key, rotating pool keys
+// on 429. On failure the error is already written to w and resp is nil,
+// otherwise release must be called after resp is read.
+func (h *handler) sendMedia(w http.ResponseWriter, r *http.Request, config GLMConfig, model, url, contentType string, body []byte) (*http.Response, func()) {
+	_routes := h.routesFor(r.Context())
+	_, proxied, err := _routes.profile(r, h.defaultProfile)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return nil, nil
+	}
+	c := &call{model: model, alias: model, config: config, provider: _routes.pools[config.Provider]}
+	key := clientKey(r, proxied, config)
+	pooled := key == ""
+	if pooled {
+		next, _ := h.pick(c.provider)
+		key = "Bearer " + next
+	}
+	c.token = strings.TrimPrefix(key, "Bearer ")
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Request error: %v", err))
+		return nil, nil
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.Header.Set("Authorization", key)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set(requestIDHeader, requestID(r.Context()))
+
+	release, err := h.lanes.acquire(r, h.fair.weight(fairClient(r)))
+	if err != nil {
+		if r.Context().Err() == nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		}
+		return nil, nil
+	}
+
+	h.stats.request(c.token, model, c.user, false)
+	c.start = time.Now()
+	resp, err := h.dispatch(r, req, c, 1, pooled)
+	annotate(w, c, resp)
+	if err != nil {
+		release()
+		if r.Context().Err() != nil {
+			logf(r.Context(), "%s canceled by client (%.1fs)", model, time.Since(c.start).Seconds())
+			return nil, nil
+		}
+		h.failure(c, err.Error())
+		h.sendConnectionError(w, err)
+		return nil, nil
+	}
+	if resp.StatusCode >= 400 {
+		release()
+		h.failure(c, h.handleUpstreamError(w, resp, c.start))
+		return nil, nil
+	}
+	return resp, release
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// allMethods are the methods of some path, OPTIONS of unknown paths and "*"
+// allows them.
+var allMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodDelete, http.MethodOptions}
+
+// methods returns the methods path is served with, nil for unknown paths.
+// HEAD is served like GET without body, except on streams which would only
+// wait for events nobody reads.
+func methods(path string) []string {
+	var allowed []string
+	switch path {
+	case "/v1/models", "/models", "/stats", "/v1/stats", "/metrics", "/dashboard", "/dashboard/",
+		"/health", "/healthz", "/version", "/v1/version", "/readyz":
+		allowed = []string{http.MethodGet, http.MethodHead}
+	case "/dashboard/events", "/ws/chat", "/v1/ws/chat":
+		allowed = []string{http.MethodGet}
+	case "/v1/chat/completions", "/chat/completions", "/v1/completions", "/completions", "/admin/reset":
+		allowed = []string{http.MethodPost}
+	case "/admin/keys":
+		allowed = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	default:
+		switch {
+		case isThreadsPath(path):
+			allowed = threadsMethods(path)
+		case isBatchesPath(path):
+			allowed = batchesMethods(path)
+		case isAudioPath(path), isImagesPath(path):
+			allowed = []string{http.MethodPost}
+		}
+	}
+	if allowed == nil {
+		return nil
+	}
+	return append(allowed, http.MethodOptions)
+}
+
+func threadsMethods(path string) []string {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(path, "/v1/threads"), "/"), "/")
+	switch {
+	case parts[0] == "":
+		return []string{http.MethodPost}
+	case len(parts) == 1:
+		return []string{http.MethodGet, http.MethodHead, http.MethodDelete}
+	case len(parts) == 2 && parts[1] == "messages":
+		return []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	case len(parts) == 2 && parts[1] == "runs":
+		return []string{http.MethodPost}
+	}
+	return nil
+}
+
+func batchesMethods(path string) []string {
+	kind, rest, _ := strings.Cut(strings.TrimPrefix(path, "/v1/"), "/")
+	parts := strings.Split(rest, "/")
+	switch {
+	case rest == "":
+		return []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	case kind == "files" && len(parts) == 1:
+		return []string{http.MethodGet, http.MethodHead, http.MethodDelete}
+	case kind == "files" && len(parts) == 2 && parts[1] == "content":
+		return []string{http.MethodGet, http.MethodHead}
+	case kind == "batches" && len(parts) == 1:
+		return []string{http.MethodGet, http.MethodHead}
+	case kind == "batches" && len(parts) == 2 && parts[1] == "cancel":
+		return []string{http.MethodPost}
+	}
+	return nil
+}
+
+// allowMethod answers 404 for unknown paths and 405 with Allow for methods
+// path is not served with.
+func (h *handler) allowMethod(w http.ResponseWriter, r *http.Request, allowed []string) bool {
+	switch {
+	case allowed == nil:
+		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+		return false
+	case !slices.Contains(allowed, r.Method):
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		h.sendErrorJSON(w, http.StatusMethodNotAllowed, fmt.Sprintf("Method %s is not allowed for %s, use %s", r.Method, r.URL.Path, strings.Join(allowed, ", ")))
+		return false
+	}
+	return true
+}
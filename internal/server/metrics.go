@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+var (
+	firstTokenBuckets = []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32}
+	throughputBuckets = []float64{5, 10, 20, 40, 80, 160, 320}
+)
+
+type histogram struct {
+	counts []int64
+	sum    float64
+	count  int64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		h.counts = make([]int64, len(bounds))
+	}
+	for i, bound := range bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// streamMetrics are time to first token and tokens/sec of streams by model.
+type streamMetrics struct {
+	firstToken map[string]*histogram
+	throughput map[string]*histogram
+}
+
+func newStreamMetrics() streamMetrics {
+	return streamMetrics{firstToken: map[string]*histogram{}, throughput: map[string]*histogram{}}
+}
+
+// streamed records a stream which sent its first chunk after firstToken and
+// generated completion tokens at tps per second, zero tps is unknown.
+func (s *stats) streamed(model string, firstToken time.Duration, tps float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	observe(s.metrics.firstToken, model, firstTokenBuckets, firstToken.Seconds())
+	if tps > 0 {
+		observe(s.metrics.throughput, model, throughputBuckets, tps)
+	}
+}
+
+// streamed logs and records time to first token and throughput of a stream
+// which sent its first chunk at first.
+func (h *handler) streamed(r *http.Request, c *call, first time.Time) {
+	firstToken, generation := first.Sub(c.start), time.Since(first)
+	var tps float64
+	if c.used.completion > 0 && generation > 0 {
+		tps = float64(c.used.completion) / generation.Seconds()
+	}
+	h.stats.streamed(c.model, firstToken, tps)
+	logf(r.Context(), "%s -> %s tok, %.1fs, first token %.2fs, %.1f tok/s",
+		c.model, c.used.String(), time.Since(c.start).Seconds(), firstToken.Seconds(), tps)
+}
+
+func observe(hists map[string]*histogram, model string, bounds []float64, v float64) {
+	h, ok := hists[model]
+	if !ok {
+		h = &histogram{}
+		hists[model] = h
+	}
+	h.observe(bounds, v)
+}
+
+// handleMetrics writes usage counters and stream histograms in Prometheus
+// text format.
+func (h *handler) handleMetrics(w http.ResponseWriter) {
+	snap := h.stats.snapshot()
+	live := h.stats.live()
+	h.addCORSHeaders(w)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	defer bw.Flush()
+
+	counter := func(name, help, label string, usage map[string]Usage, value func(Usage) int64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, id := range slices.Sorted(maps.Keys(usage)) {
+			fmt.Fprintf(bw, "%s{%s=%q} %d\n", name, label, id, value(usage[id]))
+		}
+	}
+	counter("freeglm_requests_total", "Requests sent upstream.", "model", snap.Models, func(u Usage) int64 { return u.Requests })
+	counter("freeglm_errors_total", "Failed requests.", "model", snap.Models, func(u Usage) int64 { return u.Errors })
+	counter("freeglm_prompt_tokens_total", "Prompt tokens.", "model", snap.Models, func(u Usage) int64 { return u.PromptTokens })
+	counter("freeglm_completion_tokens_total", "Completion tokens.", "model", snap.Models, func(u Usage) int64 { return u.CompletionTokens })
+	counter("freeglm_user_requests_total", "Requests by OpenAI user field.", "user", snap.Users, func(u Usage) int64 { return u.Requests })
+	counter("freeglm_user_errors_total", "Failed requests by OpenAI user field.", "user", snap.Users, func(u Usage) int64 { return u.Errors })
+	counter("freeglm_user_tokens_total", "Total tokens by OpenAI user field.", "user", snap.Users, func(u Usage) int64 { return u.TotalTokens })
+	fmt.Fprintf(bw, "# HELP freeglm_active_requests Requests in flight.\n# TYPE freeglm_active_requests gauge\nfreeglm_active_requests %d\n", live.Active)
+	fmt.Fprintf(bw, "# HELP freeglm_active_streams Streams in flight.\n# TYPE freeglm_active_streams gauge\nfreeglm_active_streams %d\n", live.ActiveStreams)
+	if h.budget != nil && h.budget.total > 0 {
+		fmt.Fprintf(bw, "# HELP freeglm_budget_tokens Daily token budget of all keys.\n# TYPE freeglm_budget_tokens gauge\nfreeglm_budget_tokens %d\n", h.budget.total)
+		fmt.Fprintf(bw, "# HELP freeglm_budget_used_tokens Tokens of the daily budget used today.\n# TYPE freeglm_budget_used_tokens gauge\nfreeglm_budget_used_tokens %d\n", h.budget.spent())
+	}
+
+	h.stats.mu.Lock()
+	defer h.stats.mu.Unlock()
+	writeHistograms(bw, "freeglm_stream_first_token_seconds", "Time from request to the first stream chunk.", firstTokenBuckets, h.stats.metrics.firstToken)
+	writeHistograms(bw, "freeglm_stream_tokens_per_second", "Completion tokens per second after the first chunk.", throughputBuckets, h.stats.metrics.throughput)
+	if h.shadow.model == "" {
+		return
+	}
+	shadow := h.stats.shadow.usage
+	counter("freeglm_shadow_requests_total", "Requests mirrored to the shadow model.", "model", shadow, func(u Usage) int64 { return u.Requests })
+	counter("freeglm_shadow_errors_total", "Failed mirrored requests.", "model", shadow, func(u Usage) int64 { return u.Errors })
+	counter("freeglm_shadow_completion_tokens_total", "Completion tokens of mirrored requests.", "model", shadow, func(u Usage) int64 { return u.CompletionTokens })
+	fmt.Fprintf(bw, "# HELP freeglm_shadow_skipped_total Sampled requests not mirrored as too many were in flight.\n# TYPE freeglm_shadow_skipped_total counter\nfreeglm_shadow_skipped_total %d\n", h.stats.shadow.skipped)
+	writeHistograms(bw, "freeglm_shadow_seconds", "Time of mirrored non-stream requests.", shadowBuckets, h.stats.shadow.latency)
+}
+
+func writeHistograms(bw *bufio.Writer, name, help string, bounds []float64, hists map[string]*histogram) {
+	fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, model := range slices.Sorted(maps.Keys(hists)) {
+		h := hists[model]
+		for i, bound := range bounds {
+			fmt.Fprintf(bw, "%s_bucket{model=%q,le=%q} %d\n", name, model, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(bw, "%s_bucket{model=%q,le=\"+Inf\"} %d\n", name, model, h.count)
+		fmt.Fprintf(bw, "%s_sum{model=%q} %g\n", name, model, h.sum)
+		fmt.Fprintf(bw, "%s_count{model=%q} %d\n", name, model, h.count)
+	}
+}
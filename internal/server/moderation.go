@@ -0,0 +1,347 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	moderateReject = "reject"
+	moderateRedact = "redact"
+
+	moderatePrompt     = "prompt"
+	moderateCompletion = "completion"
+	moderateBoth       = "both"
+
+	redacted = "****"
+)
+
+// Moderation checks prompts, completions or both with an OpenAI compatible
+// /v1/moderations URL, patterns or both. Flagged text is rejected with a
+// content_filter error, or with Action "redact" pattern matches are
+// replaced (the URL can only reject).
+type Moderation struct {
+	URL string
+	Key string
+	// Patterns is a file of regular expressions, one per line.
+	Patterns string
+	Action   string
+	Scope    string
+}
+
+// contentFilterError rejects a request or response flagged by moderation.
+type contentFilterError struct {
+	stage   string
+	reasons []string
+}
+
+func (e *contentFilterError) Error() string {
+	return fmt.Sprintf("%s was flagged by content moderation: %s", e.stage, strings.Join(e.reasons, ", "))
+}
+
+// moderator is a hook for every stage, stages out of its scope pass.
+type moderator struct {
+	url        string
+	key        string
+	patterns   []*regexp.Regexp
+	redact     bool
+	prompt     bool
+	completion bool
+	client     *http.Client
+	// flagged streams drop their chunks after the flagged one
+	flagged sync.Map
+}
+
+func newModerator(m Moderation) (*moderator, error) {
+	if m.URL == "" && m.Patterns == "" {
+		return nil, nil
+	}
+	action, scope := cmp.Or(m.Action, moderateReject), cmp.Or(m.Scope, moderatePrompt)
+	if action != moderateReject && action != moderateRedact {
+		return nil, fmt.Errorf("moderation action must be one of %v", []string{moderateReject, moderateRedact})
+	}
+	if !slices.Contains([]string{moderatePrompt, moderateCompletion, moderateBoth}, scope) {
+		return nil, fmt.Errorf("moderation scope must be one of %v", []string{moderatePrompt, moderateCompletion, moderateBoth})
+	}
+	mod := &moderator{
+		url:        m.URL,
+		key:        m.Key,
+		redact:     action == moderateRedact,
+		prompt:     scope != moderateCompletion,
+		completion: scope != moderatePrompt,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+	if m.Patterns != "" {
+		patterns, err := loadPatterns(m.Patterns)
+		if err != nil {
+			return nil, err
+		}
+		mod.patterns = patterns
+	}
+	return mod, nil
+}
+
+// loadPatterns reads case-insensitive expressions, empty lines and lines
+// starting with # are skipped.
+func loadPatterns(path string) ([]*regexp.Regexp, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read moderation patterns: %w", err)
+	}
+	var patterns []*regexp.Regexp
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + text)
+		if err != nil {
+			return nil, fmt.Errorf("moderation patterns %s:%d: %w", path, line, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// Request checks text of system and user messages.
+func (m *moderator) Request(r *http.Request, payload map[string]json.RawMessage) error {
+	if !m.prompt {
+		return nil
+	}
+	messages := decodeArray(payload["messages"])
+	var texts []string
+	for _, msg := range messages {
+		if role := stringValue(msg["role"], ""); role == "user" || role == "system" || role == "developer" {
+			texts = append(texts, contentTexts(msg["content"])...)
+		}
+	}
+	if err := m.check(r.Context(), moderatePrompt, texts); err != nil {
+		return err
+	}
+	if !m.redact || !m.matches(texts) {
+		return nil
+	}
+	for _, msg := range messages {
+		if role := stringValue(msg["role"], ""); role == "user" || role == "system" || role == "developer" {
+			msg["content"] = m.redactContent(msg["content"])
+		}
+	}
+	payload["messages"] = chatMessages(messages)
+	return nil
+}
+
+// Response checks content and reasoning of the choices.
+func (m *moderator) Response(r *http.Request, body map[string]json.RawMessage) error {
+	if !m.completion {
+		return nil
+	}
+	choices := decodeArray(body["choices"])
+	var texts []string
+	for _, choice := range choices {
+		msg := decodeMap(choice["message"])
+		texts = append(texts, stringValue(msg["content"], ""), stringValue(msg["reasoning_content"], ""))
+	}
+	if err := m.check(r.Context(), moderateCompletion, texts); err != nil {
+		return err
+	}
+	if !m.redact || !m.matches(texts) {
+		return nil
+	}
+	for _, choice := range choices {
+		msg := decodeMap(choice["message"])
+		m.redactFields(msg)
+		choice["message"] = mustMarshal(msg)
+	}
+	body["choices"] = mustMarshal(choices)
+	return nil
+}
+
+// StreamChunk checks chunks with patterns only, a match spanning chunks is
+// missed. Rejecting ends the stream with finish_reason content_filter.
+func (m *moderator) StreamChunk(r *http.Request, chunk map[string]json.RawMessage) (bool, error) {
+	if !m.completion || len(m.patterns) == 0 {
+		return true, nil
+	}
+	choices := decodeArray(chunk["choices"])
+	if _, ok := m.flagged.Load(r); ok {
+		// usage still has to be counted
+		if isNullJSON(chunk["usage"]) {
+			return false, nil
+		}
+		chunk["choices"] = rawJSON([]any{})
+		return true, nil
+	}
+	var texts []string
+	for _, choice := range choices {
+		delta := decodeMap(choice["delta"])
+		texts = append(texts, stringValue(delta["content"], ""), stringValue(delta["reasoning_content"], ""))
+	}
+	if !m.matches(texts) {
+		return true, nil
+	}
+	for _, choice := range choices {
+		delta := decodeMap(choice["delta"])
+		if m.redact {
+			m.redactFields(delta)
+		} else {
+			delete(delta, "content")
+			delete(delta, "reasoning_content")
+			choice["finish_reason"] = rawJSON("content_filter")
+		}
+		choice["delta"] = mustMarshal(delta)
+	}
+	chunk["choices"] = mustMarshal(choices)
+	if !m.redact {
+		logf(r.Context(), "stream was flagged by content moderation")
+		m.flagged.Store(r, true)
+		context.AfterFunc(r.Context(), func() { m.flagged.Delete(r) })
+	}
+	return true, nil
+}
+
+// check rejects texts matching a pattern unless they are redacted, and
+// texts the moderation URL flags.
+func (m *moderator) check(ctx context.Context, stage string, texts []string) error {
+	if !m.redact && m.matches(texts) {
+		return &contentFilterError{stage: stage, reasons: []string{"blocked words"}}
+	}
+	texts = slices.DeleteFunc(slices.Clone(texts), func(text string) bool { return text == "" })
+	if m.url == "" || len(texts) == 0 {
+		return nil
+	}
+	reasons, err := m.moderate(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("moderation: %w", err)
+	}
+	if len(reasons) != 0 {
+		return &contentFilterError{stage: stage, reasons: reasons}
+	}
+	return nil
+}
+
+// moderate asks the moderation URL and returns the flagged categories.
+func (m *moderator) moderate(ctx context.Context, texts []string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(mustMarshal(map[string]any{"input": texts})))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if m.key != "" {
+		req.Header.Set("Authorization", "Bearer "+m.key)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %d", m.url, resp.StatusCode)
+	}
+	var result struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	flagged := map[string]bool{}
+	for _, res := range result.Results {
+		if !res.Flagged {
+			continue
+		}
+		flagged["flagged"] = true
+		for category, on := range res.Categories {
+			if on {
+				flagged[category] = true
+			}
+		}
+	}
+	if len(flagged) > 1 {
+		delete(flagged, "flagged")
+	}
+	return slices.Sorted(maps.Keys(flagged)), nil
+}
+
+func (m *moderator) matches(texts []string) bool {
+	for _, re := range m.patterns {
+		if slices.ContainsFunc(texts, re.MatchString) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *moderator) redactText(text string) string {
+	for _, re := range m.patterns {
+		text = re.ReplaceAllLiteralString(text, redacted)
+	}
+	return text
+}
+
+func (m *moderator) redactFields(msg map[string]json.RawMessage) {
+	for _, field := range []string{"content", "reasoning_content"} {
+		if text := stringValue(msg[field], ""); text != "" {
+			msg[field] = rawJSON(m.redactText(text))
+		}
+	}
+}
+
+// redactContent redacts string content or text parts.
+func (m *moderator) redactContent(content json.RawMessage) json.RawMessage {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return rawJSON(m.redactText(text))
+	}
+	parts := decodeArray(content)
+	if parts == nil {
+		return content
+	}
+	for _, part := range parts {
+		if stringValue(part["type"], "") == "text" {
+			part["text"] = rawJSON(m.redactText(stringValue(part["text"], "")))
+		}
+	}
+	return mustMarshal(parts)
+}
+
+// contentTexts returns string content or the text of text parts.
+func contentTexts(content json.RawMessage) []string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return []string{text}
+	}
+	var texts []string
+	for _, part := range decodeArray(content) {
+		if stringValue(part["type"], "") == "text" {
+			texts = append(texts, stringValue(part["text"], ""))
+		}
+	}
+	return texts
+}
+
+// sendContentFilter answers a moderation rejection like OpenAI does and
+// reports whether err was one.
+func (h *handler) sendContentFilter(w http.ResponseWriter, err error) bool {
+	var filtered *contentFilterError
+	if !errors.As(err, &filtered) {
+		return false
+	}
+	h.sendError(w, http.StatusBadRequest, codeContentFilter, filtered.stage, err.Error())
+	return true
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// translateContent rewrites OpenAI content part arrays into GLM vision parts,
+// for text-only models the parts are flattened into a string and media parts
+// are dropped. It returns the number of dropped parts.
+func translateContent(payload map[string]json.RawMessage, vision bool) (int, error) {
+	var messages []map[string]json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return 0, nil
+	}
+	dropped, changed := 0, false
+	for idx, msg := range messages {
+		content := msg["content"]
+		if len(content) == 0 || content[0] != '[' {
+			continue
+		}
+		parts, n, err := contentParts(content, vision)
+		if err != nil {
+			return 0, fmt.Errorf("messages[%d].content%w", idx, err)
+		}
+		msg["content"] = parts
+		dropped += n
+		changed = true
+	}
+	if changed {
+		payload["messages"] = chatMessages(messages)
+	}
+	return dropped, nil
+}
+
+func contentParts(raw json.RawMessage, vision bool) (json.RawMessage, int, error) {
+	var parts []map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, 0, fmt.Errorf(" must be an array of objects")
+	}
+	out := make([]map[string]any, 0, len(parts))
+	var texts []string
+	dropped, marked := 0, false
+	// cache_control is left only for prompt_cache models, it is kept on parts
+	mark := func(part map[string]json.RawMessage, item map[string]any) map[string]any {
+		if cc, ok := part["cache_control"]; ok {
+			item["cache_control"] = cc
+			marked = true
+		}
+		return item
+	}
+	for idx, part := range parts {
+		kind := stringValue(part["type"], "")
+		switch kind {
+		case "text", "input_text", "refusal":
+			field := "text"
+			if kind == "refusal" {
+				field = "refusal"
+			}
+			text := stringValue(part[field], "")
+			texts = append(texts, text)
+			out = append(out, mark(part, map[string]any{"type": "text", "text": text}))
+		case "image_url", "input_image", "video_url", "file_url":
+			field := kind
+			if kind == "input_image" {
+				field = "image_url"
+			}
+			url := mediaURL(part[field])
+			if url == "" {
+				return nil, 0, fmt.Errorf("[%d].%s.url is required", idx, field)
+			}
+			if !vision {
+				dropped++
+				continue
+			}
+			out = append(out, mark(part, map[string]any{"type": field, field: map[string]string{"url": url}}))
+		default:
+			return nil, 0, fmt.Errorf("[%d].type %q is not supported", idx, kind)
+		}
+	}
+	if !vision && !marked {
+		return rawJSON(strings.Join(texts, "\n")), dropped, nil
+	}
+	return mustMarshal(out), dropped, nil
+}
+
+// mediaURL accepts both {"url": "..."} and a bare "..." string.
+func mediaURL(raw json.RawMessage) string {
+	if url := stringValue(raw, ""); url != "" {
+		return url
+	}
+	var media struct {
+		URL string `json:"url"`
+	}
+	json.Unmarshal(raw, &media)
+	return media.URL
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const warningHeader = "X-Freeglm-Warning"
+
+const (
+	logprobsStrip  = "strip"
+	logprobsReject = "reject"
+	logprobsEmpty  = "empty"
+)
+
+var logprobsModes = []string{logprobsStrip, logprobsReject, logprobsEmpty}
+
+// emptyLogprobs is the chat choice logprobs of --logprobs empty.
+var emptyLogprobs = mustMarshal(map[string]any{"content": []any{}, "refusal": nil})
+
+func validLogprobs(mode string) error {
+	if !slices.Contains(logprobsModes, mode) {
+		return fmt.Errorf("logprobs must be one of %v", logprobsModes)
+	}
+	return nil
+}
+
+// translateLogprobs drops logprobs and top_logprobs GLM does not return,
+// with reject mode requests for them fail, with empty mode it reports
+// whether choices get empty logprobs. Legacy completions asked for them
+// before the payload got here.
+func translateLogprobs(payload map[string]json.RawMessage, mode string, legacy bool) (bool, error) {
+	requested, _ := boolValue(payload["logprobs"])
+	if top, _ := intValue(payload["top_logprobs"]); top > 0 {
+		requested = true
+	}
+	delete(payload, "logprobs")
+	delete(payload, "top_logprobs")
+	if mode == logprobsReject && (requested || legacy) {
+		return false, fmt.Errorf("logprobs are not supported by GLM")
+	}
+	return requested && mode == logprobsEmpty, nil
+}
+
+// translateMaxTokens moves max_completion_tokens of newer OpenAI clients to
+// max_tokens GLM takes, so both are clamped alike; it wins over max_tokens
+// sent along. It returns the field the client used, for messages about it.
+func translateMaxTokens(payload map[string]json.RawMessage) (string, []string) {
+	raw, ok := payload["max_completion_tokens"]
+	delete(payload, "max_completion_tokens")
+	if !ok || isNullJSON(raw) {
+		return "max_tokens", nil
+	}
+	var warnings []string
+	if prev := payload["max_tokens"]; !isNullJSON(prev) && !bytes.Equal(prev, raw) {
+		warnings = append(warnings, "max_tokens is ignored for max_completion_tokens")
+	}
+	payload["max_tokens"] = raw
+	return "max_completion_tokens", warnings
+}
+
+// unsupportedParams are OpenAI sampling parameters GLM rejects or ignores,
+// they are dropped with a warning when set to a non-default value.
+var unsupportedParams = []string{"presence_penalty", "frequency_penalty", "seed", "logit_bias"}
+
+// translateParams maps OpenAI sampling parameters to GLM ones in place and
+// returns warnings about dropped or changed values. Streams keep all stop
+// sequences as the stream filter enforces them.
+func translateParams(payload map[string]json.RawMessage, stream bool) ([]string, error) {
+	var warnings []string
+	if raw, ok := payload["stop"]; ok {
+		stop, err := stopSequences(raw)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(stop) == 0:
+			delete(payload, "stop")
+		case len(stop) > 1 && !stream:
+			warnings = append(warnings, fmt.Sprintf("stop supports one sequence, %d dropped", len(stop)-1))
+			fallthrough
+		default:
+			payload["stop"] = rawJSON(stop[:1])
+		}
+	}
+	if raw, ok := payload["top_p"]; ok && !isNullJSON(raw) {
+		var topP float64
+		if err := json.Unmarshal(raw, &topP); err != nil || topP < 0 || topP > 1 {
+			return nil, fmt.Errorf("top_p must be a number between 0 and 1")
+		}
+		if topP < 0.01 {
+			warnings = append(warnings, "top_p raised to 0.01")
+			payload["top_p"] = rawJSON(0.01)
+		}
+	}
+	for _, param := range unsupportedParams {
+		raw, ok := payload[param]
+		if !ok {
+			continue
+		}
+		delete(payload, param)
+		if !defaultParam(raw) {
+			warnings = append(warnings, param+" is not supported, dropped")
+		}
+	}
+	return warnings, nil
+}
+
+func stopSequences(raw json.RawMessage) ([]string, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		if one == "" {
+			return nil, nil
+		}
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, fmt.Errorf("stop must be a string or an array of strings")
+	}
+	out := many[:0]
+	for _, s := range many {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// defaultParam reports whether the value changes nothing: null, zero or {}.
+func defaultParam(raw json.RawMessage) bool {
+	switch strings.TrimSpace(string(raw)) {
+	case "", "null", "0", "0.0", "{}":
+		return true
+	}
+	var f float64
+	return json.Unmarshal(raw, &f) == nil && f == 0
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+)
+
+func passthroughResponse(body []byte, model string) ([]byte, usage, bool) {
+	var probe struct {
+		ID      json.RawMessage              `json:"id"`
+		Object  json.RawMessage              `json:"object"`
+		Created json.RawMessage              `json:"created"`
+		Model   string                       `json:"model"`
+		Choices []map[string]json.RawMessage `json:"choices"`
+		Usage   json.RawMessage              `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &probe); err != nil {
+		return nil, usage{}, false
+	}
+	if isNullJSON(probe.ID) || isNullJSON(probe.Object) || isNullJSON(probe.Created) {
+		return nil, usage{}, false
+	}
+	if len(probe.Choices) == 0 || !choicesConformant(probe.Choices, "message", "delta") {
+		return nil, usage{}, false
+	}
+	if raw := decodeMap(probe.Usage); raw != nil && raw["prompt_tokens_details"] == nil && raw["cache_read_input_tokens"] != nil {
+		return nil, usage{}, false
+	}
+	u := usage{}
+	if !isNullJSON(probe.Usage) {
+		u = extractUsage(map[string]json.RawMessage{"usage": probe.Usage})
+	}
+	if probe.Model == model {
+		return body, u, true
+	}
+	patched, ok := patchModel(body, model)
+	return patched, u, ok
+}
+
+func patchModel(body []byte, model string) ([]byte, bool) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, false
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, false
+		}
+		start := dec.InputOffset()
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, false
+		}
+		if tok != "model" {
+			continue
+		}
+		idx := bytes.Index(body[start:dec.InputOffset()], value)
+		if idx < 0 {
+			return nil, false
+		}
+		idx += int(start)
+		return slices.Concat(body[:idx], rawJSON(model), body[idx+len(value):]), true
+	}
+	return nil, false
+}
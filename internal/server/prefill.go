@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	prefillAuto    = "auto"
+	prefillPass    = "pass"
+	prefillEmulate = "emulate"
+)
+
+var prefillModes = []string{prefillAuto, prefillPass, prefillEmulate}
+
+const prefillPrompt = "Continue your last reply exactly where it stops. Do not repeat any of it and do not comment on it."
+
+func validPrefill(mode string) error {
+	if !slices.Contains(prefillModes, mode) {
+		return fmt.Errorf("prefill must be one of %v", prefillModes)
+	}
+	return nil
+}
+
+// trailingPrefill returns the text of a last assistant message without tool
+// calls, the start of the answer a client prefilled like with Claude.
+func trailingPrefill(raw json.RawMessage) string {
+	messages := decodeArray(raw)
+	if len(messages) == 0 {
+		return ""
+	}
+	last := messages[len(messages)-1]
+	if stringValue(last["role"], "") != "assistant" || !isNullJSON(last["tool_calls"]) {
+		return ""
+	}
+	if text := stringValue(last["content"], ""); text != "" {
+		return text
+	}
+	var text strings.Builder
+	for _, part := range decodeArray(last["content"]) {
+		text.WriteString(stringValue(part["text"], ""))
+	}
+	return text.String()
+}
+
+// emulatePrefill asks upstream to go on with the prefill in a user message
+// after it, for upstreams which reject or ignore a trailing assistant
+// message. Answers starting with the prefill again are cut after it, so
+// clients get only the continuation.
+func emulatePrefill(payload map[string]json.RawMessage, c *call) {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(payload["messages"], &messages); err != nil {
+		return
+	}
+	messages = append(messages, mustMarshal(map[string]string{"role": "user", "content": prefillPrompt}))
+	payload["messages"] = mustMarshal(messages)
+	c.transform.prefill = &prefill{text: strings.TrimSpace(c.prefill), held: map[int]string{}, done: map[int]bool{}}
+}
+
+// prefill cuts a repeated prefill from the start of every choice, stream
+// content which may still turn out to repeat it is held back.
+type prefill struct {
+	text string
+	held map[int]string
+	done map[int]bool
+}
+
+// unprefill cuts a repeated prefill from the content of choice index,
+// finished is true for whole messages and last deltas.
+func (t *transform) unprefill(index int, msg map[string]json.RawMessage, finished bool) {
+	if t == nil || t.prefill == nil || msg == nil {
+		return
+	}
+	p := t.prefill
+	if p.done[index] {
+		return
+	}
+	if isNullJSON(msg["content"]) && p.held[index] == "" {
+		return
+	}
+	text := p.held[index] + stringValue(msg["content"], "")
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	switch {
+	case strings.HasPrefix(trimmed, p.text):
+		text = trimmed[len(p.text):]
+	case strings.HasPrefix(p.text, trimmed) && !finished:
+		p.held[index] = text
+		msg["content"] = rawJSON("")
+		return
+	}
+	delete(p.held, index)
+	p.done[index] = true
+	msg["content"] = rawJSON(text)
+}
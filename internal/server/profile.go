@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+type Profile struct {
+	Tokens      []string
+	Reasoning   string
+	ToolRepair  bool
+	StripParams []string
+}
+
+var profiles = map[string]Profile{
+	"opencode": {
+		Reasoning:  reasoningPassthrough,
+		ToolRepair: true,
+	},
+	"aider": {
+		Reasoning:   reasoningStrip,
+		StripParams: []string{"parallel_tool_calls", "stream_options"},
+	},
+	"openwebui": {
+		Reasoning:   reasoningMerge,
+		ToolRepair:  true,
+		StripParams: []string{"stream_options"},
+	},
+}
+
+func profileRegistry(custom map[string]Profile) (map[string]*Profile, map[string]string, error) {
+	all := map[string]*Profile{}
+	tokens := map[string]string{}
+	for name, p := range profiles {
+		all[name] = &p
+	}
+	for name, p := range custom {
+		all[name] = &p
+	}
+	for name, p := range all {
+		if p.Reasoning != "" {
+			if err := validReasoning(p.Reasoning); err != nil {
+				return nil, nil, fmt.Errorf("profile %s: %w", name, err)
+			}
+		}
+		for _, token := range p.Tokens {
+			if other, ok := tokens[token]; ok {
+				return nil, nil, fmt.Errorf("profile %s token is already used by profile %s", name, other)
+			}
+			tokens[token] = name
+		}
+	}
+	return all, tokens, nil
+}
+
+func (rt *routes) profile(r *http.Request, fallback string) (*Profile, bool, error) {
+	if name := r.Header.Get("X-Freeglm-Profile"); name != "" {
+		p, ok := rt.profiles[name]
+		if !ok {
+			return nil, false, fmt.Errorf("profile must be one of %v", slices.Sorted(maps.Keys(rt.profiles)))
+		}
+		return p, false, nil
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+	if name, ok := rt.proxyTokens[token]; ok && token != "" {
+		return rt.profiles[name], true, nil
+	}
+	return rt.profiles[fallback], false, nil
+}
+
+func (p *Profile) request(payload map[string]json.RawMessage) {
+	if p == nil {
+		return
+	}
+	for _, param := range p.StripParams {
+		delete(payload, param)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import "encoding/json"
+
+// stripCacheControl removes prompt caching marks (cache_control) from
+// messages, their content parts and tools for models without "prompt_cache"
+// in config, upstreams reject or mishandle the unknown field. It returns the
+// number of marks removed.
+func stripCacheControl(payload map[string]json.RawMessage) int {
+	removed := 0
+	strip := func(item map[string]json.RawMessage) bool {
+		if _, ok := item["cache_control"]; ok {
+			delete(item, "cache_control")
+			removed++
+			return true
+		}
+		return false
+	}
+	if messages := decodeArray(payload["messages"]); messages != nil {
+		changed := false
+		for _, msg := range messages {
+			changed = strip(msg) || changed
+			if parts := decodeArray(msg["content"]); parts != nil {
+				partsChanged := false
+				for _, part := range parts {
+					partsChanged = strip(part) || partsChanged
+				}
+				if partsChanged {
+					msg["content"] = mustMarshal(parts)
+					changed = true
+				}
+			}
+		}
+		if changed {
+			payload["messages"] = chatMessages(messages)
+		}
+	}
+	if tools := decodeArray(payload["tools"]); tools != nil {
+		changed := false
+		for _, tool := range tools {
+			changed = strip(tool) || changed
+		}
+		if changed {
+			payload["tools"] = mustMarshal(tools)
+		}
+	}
+	return removed
+}
+
+// normalizeCachedTokens reports prompt cache hits of upstreams speaking
+// Anthropic usage (cache_read_input_tokens) as OpenAI
+// prompt_tokens_details.cached_tokens.
+func normalizeCachedTokens(root map[string]json.RawMessage) {
+	u := decodeMap(root["usage"])
+	if u == nil {
+		return
+	}
+	if _, ok := u["prompt_tokens_details"]; ok {
+		return
+	}
+	cached, ok := intValue(u["cache_read_input_tokens"])
+	if !ok {
+		return
+	}
+	u["prompt_tokens_details"] = mustMarshal(map[string]int{"cached_tokens": cached})
+	root["usage"] = mustMarshal(u)
+}
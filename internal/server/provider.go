@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+type Provider struct {
+	Name      string
+	BaseURL   string
+	Fallbacks []string
+	Keys      []string
+	Models    map[string]int
+	Vision    []string
+	Context   map[string]int
+	// PromptCache models get cache_control marks forwarded, they are
+	// stripped for other models.
+	PromptCache []string
+	// Audio models are served on /audio/transcriptions and /audio/speech
+	// of BaseURL.
+	Audio []string
+	// Images models are served on /images/generations of BaseURL.
+	Images []string
+}
+
+// visionModel matches GLM vision model names like glm-4.5v or glm-4.6v-flash.
+var visionModel = regexp.MustCompile(`^glm-[0-9.]+v(-|$)|vision`)
+
+var providers = []Provider{
+	{
+		Name:    "zhipuai-coding",
+		BaseURL: "https://api.z.ai/api/coding/paas/v4",
+		Models: map[string]int{
+			glm47: 8192,
+		},
+		Context: map[string]int{
+			glm47: 200000,
+		},
+	},
+	{
+		Name:    "zhipuai",
+		BaseURL: "https://api.z.ai/api/paas/v4",
+		Models: map[string]int{
+			glm47flash: 8192,
+		},
+		Audio:  []string{glmASR, glmTTS},
+		Images: []string{cogView},
+	},
+}
+
+// registry builds models and key pools of the built-in and custom providers,
+// model IDs discovered from a provider are added unless already routed; a
+// later provider configuring one takes it over.
+func registry(custom []Provider, global []string, discovered map[string][]string, factory func([]string) keys) (map[string]GLMConfig, map[string]keys, error) {
+	models := map[string]GLMConfig{}
+	pools := map[string]keys{}
+	shared := factory(global)
+	for i, p := range slices.Concat(providers, custom) {
+		if p.Name == "" {
+			return nil, nil, fmt.Errorf("provider name is empty")
+		}
+		if p.BaseURL == "" {
+			return nil, nil, fmt.Errorf("provider %s base url is empty", p.Name)
+		}
+		if len(p.Keys) != 0 {
+			pools[p.Name] = factory(p.Keys)
+		} else {
+			pools[p.Name] = shared
+		}
+		var fallbacks []string
+		for _, url := range p.Fallbacks {
+			fallbacks = append(fallbacks, strings.TrimSuffix(url, "/")+"/chat/completions")
+		}
+		for model, limit := range p.Models {
+			models[model] = GLMConfig{
+				URL:       strings.TrimSuffix(p.BaseURL, "/") + "/chat/completions",
+				Fallbacks: fallbacks,
+				MaxTokens: limit,
+				Provider:  p.Name,
+				Vision:    slices.Contains(p.Vision, model) || visionModel.MatchString(model),
+				Context:   p.Context[model],
+
+				PromptCache: slices.Contains(p.PromptCache, model),
+				Builtin:     i < len(providers),
+			}
+		}
+		for _, model := range discovered[p.Name] {
+			if _, ok := models[model]; ok {
+				continue
+			}
+			models[model] = GLMConfig{
+				URL:       strings.TrimSuffix(p.BaseURL, "/") + "/chat/completions",
+				Fallbacks: fallbacks,
+				MaxTokens: discoverTokens,
+				Provider:  p.Name,
+				Vision:    slices.Contains(p.Vision, model) || visionModel.MatchString(model),
+				Context:   p.Context[model],
+
+				Builtin: i < len(providers),
+			}
+		}
+	}
+	return models, pools, nil
+}
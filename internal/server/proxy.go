@@ -0,0 +1,98 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// upstreamProxy returns the proxy of upstream requests: --upstream-proxy when
+// set, otherwise HTTPS_PROXY/HTTP_PROXY and then ALL_PROXY from environment,
+// hosts in NO_PROXY go direct.
+func upstreamProxy(raw string) (func(*http.Request) (*url.URL, error), error) {
+	if raw != "" {
+		proxy, err := parseProxy(raw)
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy: %w", err)
+		}
+		return http.ProxyURL(proxy), nil
+	}
+	all := cmp.Or(os.Getenv("ALL_PROXY"), os.Getenv("all_proxy"))
+	if all == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxy, err := parseProxy(all)
+	if err != nil {
+		return nil, fmt.Errorf("ALL_PROXY: %w", err)
+	}
+	noProxy := cmp.Or(os.Getenv("NO_PROXY"), os.Getenv("no_proxy"))
+	return func(req *http.Request) (*url.URL, error) {
+		if u, err := http.ProxyFromEnvironment(req); u != nil || err != nil {
+			return u, err
+		}
+		if bypassProxy(req.URL.Hostname(), noProxy) {
+			return nil, nil
+		}
+		return proxy, nil
+	}, nil
+}
+
+// parseProxy accepts http, https, socks5 and socks5h URLs, host:port is
+// taken as http proxy.
+func parseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("scheme must be one of [http https socks5 socks5h], got %q", proxy.Scheme)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("%q has no host", raw)
+	}
+	return proxy, nil
+}
+
+// bypassProxy matches host against NO_PROXY entries: "*", domains (with
+// subdomains), IP addresses and CIDR ranges, localhost is never proxied
+// like with HTTPS_PROXY.
+func bypassProxy(host, noProxy string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		entry = strings.TrimPrefix(entry, "*")
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const maxWaitHeader = "X-Freeglm-Max-Wait"
+
+// cooldowns are keys resting after 429, with store set they are shared with
+// other replicas under "cooldown:" and outlive restarts.
+type cooldowns struct {
+	mu    sync.Mutex
+	until map[string]time.Time
+	store storage.Storage
+}
+
+func newCooldowns(store storage.Storage) *cooldowns {
+	c := &cooldowns{until: map[string]time.Time{}}
+	if storage.Shared(store) {
+		c.store = store
+	}
+	return c
+}
+
+func (c *cooldowns) cool(key string, d time.Duration) {
+	c.mu.Lock()
+	until := time.Now().Add(d)
+	c.until[key] = until
+	c.mu.Unlock()
+	if c.store != nil {
+		value := strconv.AppendInt(nil, until.UnixNano(), 10)
+		if err := c.store.Set(context.Background(), "cooldown:"+keyID(key), value, d); err != nil {
+			log.Println("shared key cooldown error:", err)
+		}
+	}
+}
+
+// reset lets the key ("" for all keys) be picked again at once.
+func (c *cooldowns) reset(key string) {
+	c.mu.Lock()
+	if key == "" {
+		clear(c.until)
+	} else {
+		delete(c.until, key)
+	}
+	c.mu.Unlock()
+	if c.store == nil {
+		return
+	}
+	ctx := context.Background()
+	var err error
+	if key == "" {
+		err = c.store.Scan(ctx, "cooldown:", func(k string, _ []byte) error {
+			return c.store.Delete(ctx, k)
+		})
+	} else {
+		err = c.store.Delete(ctx, "cooldown:"+keyID(key))
+	}
+	if err != nil {
+		log.Println("shared key cooldown error:", err)
+	}
+}
+
+func (c *cooldowns) remaining(key string) time.Duration {
+	c.mu.Lock()
+	until, ok := c.until[key]
+	if ok && time.Until(until) <= 0 {
+		delete(c.until, key)
+	}
+	c.mu.Unlock()
+	if d := time.Until(until); ok && d > 0 {
+		return d
+	}
+	if c.store == nil {
+		return 0
+	}
+	data, err := c.store.Get(context.Background(), "cooldown:"+keyID(key))
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Println("shared key cooldown error:", err)
+		}
+		return 0
+	}
+	nanos, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return max(0, time.Until(time.Unix(0, nanos)))
+}
+
+// resting is how long key is not picked: cooling down after 429 or without
+// daily budget left.
+func (h *handler) resting(key string) time.Duration {
+	return max(h.cooldowns.remaining(key), h.budget.exhausted(key))
+}
+
+// pick returns the next key of the pool which is not resting, or the key
+// which gets ready first and how long it rests. The pool turns once per
+// pick, a resting key is replaced by the next ready one in config order.
+func (h *handler) pick(pool keys) (string, time.Duration) {
+	all := pool.all()
+	rests := make([]time.Duration, len(all))
+	best, ready := -1, false
+	for i, key := range all {
+		rests[i] = h.resting(key)
+		ready = ready || rests[i] == 0
+		if best < 0 || rests[i] < rests[best] {
+			best = i
+		}
+	}
+	if best < 0 {
+		return pool.next(), 0
+	}
+	if !ready {
+		return all[best], rests[best]
+	}
+	key := pool.next()
+	start := max(slices.Index(all, key), 0)
+	for n := range all {
+		if i := (start + n) % len(all); rests[i] == 0 {
+			return all[i], 0
+		}
+	}
+	return key, 0
+}
+
+// maxWait is how long a request may be queued for a key, the client can
+// lower it with X-Freeglm-Max-Wait header (seconds).
+func (h *handler) maxWait(r *http.Request) time.Duration {
+	wait := h.queueWait
+	if v, err := strconv.Atoi(r.Header.Get(maxWaitHeader)); err == nil && v >= 0 {
+		wait = min(wait, time.Duration(v)*time.Second)
+	}
+	return wait
+}
+
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	if v, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return fallback
+}
+
+// dispatch sends the request and on 429 from a pool key cools the key down
+// and retries with the next ready key, waiting for cooldown up to maxWait.
+func (h *handler) dispatch(r *http.Request, req *http.Request, c *call, n int, pooled bool) (*http.Response, error) {
+	deadline := time.Now().Add(h.maxWait(r))
+	for {
+		var (
+			resp *http.Response
+			err  error
+		)
+		switch {
+		case n > 1:
+			resp, err = h.fanout(req, c.model, n, c.stream)
+		case c.rival != nil:
+			resp, err = h.runRace(req, c)
+		default:
+			resp, err = h.send(req, c.model)
+		}
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || !pooled {
+			return resp, err
+		}
+		h.cooldowns.cool(c.token, retryAfter(resp, h.keyCooldown))
+		key, wait := h.pick(c.provider)
+		if key == "" || key == c.token && wait == 0 || wait > 0 && time.Now().Add(wait).After(deadline) {
+			return resp, nil
+		}
+		h.stats.failure(c.token, c.model, c.user, "rate limited, key cooling down")
+		resp.Body.Close()
+		if wait > 0 {
+			logf(r.Context(), "%s all keys are cooling down, queued for %s", c.model, wait.Round(time.Second))
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(wait):
+			}
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+		req.Header.Set("Authorization", "Bearer "+key)
+		c.token = key
+	}
+}
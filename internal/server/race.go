@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const raceHeader = "X-Freeglm-Race"
+
+// rivals are the models which race each other, glm-4.7 is the stronger.
+var rivals = map[string]string{glm47: glm47flash, glm47flash: glm47}
+
+// rival is the model a request races against with the payload for it.
+type rival struct {
+	model   string
+	config  GLMConfig
+	payload map[string]json.RawMessage
+}
+
+// racing reports whether the request races glm-4.7 against glm-4.7-flash,
+// for all requests with --race or per request with the header.
+func (h *handler) racing(r *http.Request) bool {
+	if value := r.Header.Get(raceHeader); value != "" {
+		on, _ := strconv.ParseBool(value)
+		return on
+	}
+	return h.race.all
+}
+
+// newRival returns the rival of the model of c, nil when it has none
+// configured with the same key pool, so the key picked for c works for
+// both. Keys of clients only go to built-in providers.
+func (h *handler) newRival(rt *routes, c *call, payload map[string]json.RawMessage) *rival {
+	other, ok := rivals[c.model]
+	if !ok {
+		return nil
+	}
+	config, ok := rt.models[other]
+	if !ok || rt.pools[config.Provider] != rt.pools[c.config.Provider] || config.Builtin != c.config.Builtin {
+		return nil
+	}
+	alt := maps.Clone(payload)
+	alt["model"] = rawJSON(other)
+	if !h.noClamp && config.MaxTokens > 0 {
+		alt["max_tokens"] = rawJSON(clampTokens(payload["max_tokens"], config.MaxTokens, h.tokens))
+	}
+	return &rival{model: other, config: config, payload: alt}
+}
+
+type racePolicy struct {
+	all    bool
+	margin time.Duration
+}
+
+type raceResult struct {
+	index int
+	resp  *http.Response
+	err   error
+}
+
+func (res raceResult) ok() bool {
+	return res.err == nil && res.resp.StatusCode < 400
+}
+
+// runRace sends req and the same request to the rival concurrently and
+// returns the response which finishes first, a stream finishes with its
+// first bytes. glm-4.7 still wins when it finishes within the margin after
+// glm-4.7-flash. The loser is canceled and c is switched to the winner.
+func (h *handler) runRace(req *http.Request, c *call) (*http.Response, error) {
+	alt, err := newJSONRequest(req.Context(), c.rival.config.URL, c.rival.payload)
+	if err != nil {
+		return nil, err
+	}
+	alt.Header = req.Header.Clone()
+
+	reqs := []*http.Request{req, alt}
+	models := []string{c.model, c.rival.model}
+	strong := 0
+	if c.rival.model == glm47 {
+		strong = 1
+	}
+	cancels := make([]context.CancelFunc, len(reqs))
+	results := make(chan raceResult, len(reqs))
+	for i := range reqs {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[i] = cancel
+		go func() {
+			resp, err := h.send(reqs[i].WithContext(ctx), models[i])
+			if err == nil && resp.StatusCode < 400 {
+				if err = finishRace(resp, c.stream); err != nil {
+					resp = nil
+				}
+			}
+			results <- raceResult{index: i, resp: resp, err: err}
+		}()
+	}
+
+	start := time.Now()
+	var best, failed *raceResult
+	var margin <-chan time.Time
+	pending := len(reqs)
+wait:
+	for pending > 0 && (best == nil || best.index != strong) {
+		select {
+		case res := <-results:
+			pending--
+			switch {
+			case !res.ok() && failed == nil:
+				failed = &res
+			case !res.ok():
+				closeResult(res)
+			case best != nil:
+				// the stronger model made it within the margin
+				closeResult(*best)
+				best = &res
+			default:
+				best = &res
+				margin = time.After(h.race.margin)
+			}
+		case <-margin:
+			break wait
+		}
+	}
+	go func() {
+		for range pending {
+			closeResult(<-results)
+		}
+	}()
+
+	won := best
+	if won == nil {
+		won = failed
+	} else if failed != nil {
+		closeResult(*failed)
+	}
+	for i, cancel := range cancels {
+		if i != won.index {
+			cancel()
+		}
+	}
+	if won.resp != nil {
+		won.resp.Body = readCloser{won.resp.Body, cancelCloser{won.resp.Body, cancels[won.index]}}
+	} else {
+		cancels[won.index]()
+	}
+	if best != nil {
+		logf(req.Context(), "%s won the race against %s in %.1fs", models[best.index], models[1-best.index], time.Since(start).Seconds())
+		if best.index == 1 {
+			if c.alias == c.model {
+				c.alias = c.rival.model
+			}
+			c.model, c.config = c.rival.model, c.rival.config
+		}
+	}
+	return won.resp, won.err
+}
+
+func closeResult(res raceResult) {
+	if res.resp != nil {
+		res.resp.Body.Close()
+	}
+}
+
+// finishRace reads a whole response or the first bytes of a stream.
+func finishRace(resp *http.Response, stream bool) error {
+	if stream {
+		br := bufio.NewReader(resp.Body)
+		if _, err := br.Peek(1); err != nil {
+			resp.Body.Close()
+			return err
+		}
+		resp.Body = readCloser{br, resp.Body}
+		return nil
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	return nil
+}
+
+// cancelCloser cancels the request of the winner when its body is closed.
+type cancelCloser struct {
+	io.Closer
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	err := c.Closer.Close()
+	c.cancel()
+	return err
+}
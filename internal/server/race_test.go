@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRaceFlashWins races glm-4.7 on the coding provider against
+// glm-4.7-flash on the general one, both share the key pool.
+func TestRaceFlashWins(t *testing.T) {
+	canceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server notices a closed connection once the body is read
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(5 * time.Second):
+			writeCompletion(w, glm47, "late")
+		}
+	}))
+	defer slow.Close()
+	keys := make(chan string, 2)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Authorization")
+		var payload struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Model != glm47flash {
+			http.Error(w, "bad payload", http.StatusBadRequest)
+			return
+		}
+		writeCompletion(w, glm47flash, "fast")
+	}))
+	defer fast.Close()
+
+	s, err := New(Options{Keys: []string{"pool-key"}, Model: glm47, Race: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := s.handler.routes.Load()
+	for model, url := range map[string]string{glm47: slow.URL, glm47flash: fast.URL} {
+		config := rt.models[model]
+		config.URL = url
+		rt.models[model] = config
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"glm-4.7","messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Model   string `json:"model"`
+		Choices []struct {
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Model != glm47flash || len(resp.Choices) != 1 || resp.Choices[0].Message.Content != "fast" {
+		t.Fatalf("response = %s", rec.Body)
+	}
+	if key := <-keys; key != "Bearer pool-key" {
+		t.Fatalf("rival got Authorization %q", key)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("losing glm-4.7 request was not canceled")
+	}
+}
+
+func writeCompletion(w http.ResponseWriter, model, content string) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"id":"chatcmpl-1","object":"chat.completion","created":1,"model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`, model, content)
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	limitByIP  = "ip"
+	limitByKey = "key"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	by      string
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+func newLimiter(rate float64, burst int, by string) (*limiter, error) {
+	if rate <= 0 {
+		return nil, nil
+	}
+	if by == "" {
+		by = limitByIP
+	}
+	if by != limitByIP && by != limitByKey && by != limitByUser {
+		return nil, fmt.Errorf("rate limit by must be one of [%s %s %s]", limitByIP, limitByKey, limitByUser)
+	}
+	if burst < 1 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		by:      by,
+		buckets: map[string]*bucket{},
+		swept:   time.Now(),
+	}, nil
+}
+
+func (l *limiter) allow(id string) (bool, int, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if now.Sub(l.swept) > time.Minute {
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > 10*time.Minute {
+				delete(l.buckets, key)
+			}
+		}
+		l.swept = now
+	}
+	b, ok := l.buckets[id]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[id] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), time.Duration((l.burst - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (h *handler) rateLimit(w http.ResponseWriter, r *http.Request) bool {
+	if h.limiter == nil {
+		return true
+	}
+	id := clientIP(r)
+	switch h.limiter.by {
+	case limitByKey:
+		if key := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer")); key != "" {
+			id = "key:" + key
+		}
+	case limitByUser:
+		// chat requests are limited by user field once the body is read
+		if isChatPath(r.URL.Path) {
+			return true
+		}
+	}
+	return h.limit(w, id)
+}
+
+// rateLimitUser limits chat and completion requests by the OpenAI user
+// field with --rate-limit-by user, requests without one by IP.
+func (h *handler) rateLimitUser(w http.ResponseWriter, r *http.Request, user string) bool {
+	if h.limiter == nil || h.limiter.by != limitByUser {
+		return true
+	}
+	id := clientIP(r)
+	if user != "" {
+		id = "user:" + user
+	}
+	return h.limit(w, id)
+}
+
+func (h *handler) limit(w http.ResponseWriter, id string) bool {
+	ok, remaining, reset := h.limiter.allow(id)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(h.limiter.burst)))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+		h.sendErrorJSON(w, http.StatusTooManyRequests, fmt.Sprintf("Rate limit reached, retry in %.1fs", reset.Seconds()))
+	}
+	return ok
+}
+
+func isChatPath(path string) bool {
+	switch path {
+	case "/v1/chat/completions", "/chat/completions", "/v1/completions", "/completions":
+		return true
+	}
+	return false
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
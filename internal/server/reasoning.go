@@ -0,0 +1,120 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+const (
+	reasoningPassthrough = "passthrough"
+	reasoningStrip       = "strip"
+	reasoningMerge       = "merge"
+	reasoningOpenAI      = "openai"
+)
+
+var reasonings = []string{reasoningPassthrough, reasoningStrip, reasoningMerge, reasoningOpenAI}
+
+func validReasoning(mode string) error {
+	if !slices.Contains(reasonings, mode) {
+		return fmt.Errorf("reasoning must be one of %v", reasonings)
+	}
+	return nil
+}
+
+type transform struct {
+	reasoning  string
+	toolRepair bool
+	thinking   map[int]bool
+	tools      map[int]*toolStream
+
+	// logprobs adds empty logprobs to choices
+	logprobs bool
+	// prefill is cut from answers of emulated prefills
+	prefill *prefill
+	// splitTools sends parallel tool calls one per turn, split keeps all
+	// calls of non-stream choices with some held back.
+	splitTools bool
+	split      map[int][]map[string]json.RawMessage
+}
+
+func newTransform(p *Profile, reasoning string) *transform {
+	t := &transform{
+		reasoning: reasoning,
+		thinking:  map[int]bool{},
+		tools:     map[int]*toolStream{},
+		split:     map[int][]map[string]json.RawMessage{},
+	}
+	if p != nil {
+		if p.Reasoning != "" {
+			t.reasoning = p.Reasoning
+		}
+		t.toolRepair = p.ToolRepair
+	}
+	return t
+}
+
+func (t *transform) rewrites() bool {
+	return t != nil && (t.reasoning != reasoningPassthrough || t.toolRepair || t.logprobs || t.prefill != nil || t.splitTools)
+}
+
+func (t *transform) message(msg map[string]json.RawMessage) {
+	if t == nil {
+		return
+	}
+	if t.toolRepair {
+		if raw, ok := msg["tool_calls"]; ok && !isNullJSON(raw) {
+			msg["tool_calls"] = repairToolArguments(raw)
+		}
+	}
+	t.reasoningFields(msg)
+}
+
+// reasoningFields applies the reasoning mode to a message or a delta,
+// streamed argument fragments are never repaired as they are not valid JSON
+// on their own.
+func (t *transform) reasoningFields(msg map[string]json.RawMessage) {
+	reasoning := stringValue(msg["reasoning_content"], "")
+	switch t.reasoning {
+	case reasoningStrip:
+		delete(msg, "reasoning_content")
+	case reasoningOpenAI:
+		if _, ok := msg["reasoning_content"]; ok {
+			msg["reasoning"] = msg["reasoning_content"]
+			delete(msg, "reasoning_content")
+		}
+	case reasoningMerge:
+		delete(msg, "reasoning_content")
+		if reasoning != "" {
+			msg["content"] = rawJSON("<think>" + reasoning + "</think>\n\n" + stringValue(msg["content"], ""))
+		}
+	}
+}
+
+func (t *transform) delta(index int, msg map[string]json.RawMessage, finished bool) {
+	if t == nil {
+		return
+	}
+	if t.reasoning != reasoningMerge {
+		t.reasoningFields(msg)
+		return
+	}
+	reasoning := stringValue(msg["reasoning_content"], "")
+	content := stringValue(msg["content"], "")
+	delete(msg, "reasoning_content")
+	merged := ""
+	if reasoning != "" {
+		if !t.thinking[index] {
+			merged += "<think>"
+			t.thinking[index] = true
+		}
+		merged += reasoning
+	}
+	if t.thinking[index] && (content != "" || finished) {
+		merged += "</think>\n\n"
+		t.thinking[index] = false
+	}
+	if merged != "" {
+		msg["content"] = rawJSON(merged + content)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestReasoningModesInMessage(t *testing.T) {
+	raw := `[{"index":0,"message":{"role":"assistant","reasoning_content":"think hard","content":"answer"},"finish_reason":"stop"}]`
+	tests := []struct {
+		mode      string
+		content   string
+		reasoning map[string]string
+	}{
+		{reasoningPassthrough, "answer", map[string]string{"reasoning_content": "think hard"}},
+		{reasoningStrip, "answer", nil},
+		{reasoningMerge, "<think>think hard</think>\n\nanswer", nil},
+		{reasoningOpenAI, "answer", map[string]string{"reasoning": "think hard"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			choices := decodeArray(normalizeChoices(json.RawMessage(raw), newTransform(nil, tt.mode)))
+			msg := decodeMap(choices[0]["message"])
+			if content := stringValue(msg["content"], ""); content != tt.content {
+				t.Fatalf("content = %q, want %q", content, tt.content)
+			}
+			for _, field := range []string{"reasoning_content", "reasoning"} {
+				got, ok := msg[field]
+				want, wantOK := tt.reasoning[field]
+				if ok != wantOK || stringValue(got, "") != want {
+					t.Fatalf("%s = %s, want %q", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReasoningModesInStream(t *testing.T) {
+	chunks := []string{
+		`[{"index":0,"delta":{"role":"assistant","reasoning_content":"think "}}]`,
+		`[{"index":0,"delta":{"reasoning_content":"hard"}}]`,
+		`[{"index":0,"delta":{"content":"ans"}}]`,
+		`[{"index":0,"delta":{"content":"wer"}}]`,
+		`[{"index":0,"delta":{},"finish_reason":"stop"}]`,
+	}
+	tests := []struct {
+		mode      string
+		content   string
+		field     string
+		reasoning string
+	}{
+		{reasoningPassthrough, "answer", "reasoning_content", "think hard"},
+		{reasoningStrip, "answer", "", ""},
+		{reasoningMerge, "<think>think hard</think>\n\nanswer", "", ""},
+		{reasoningOpenAI, "answer", "reasoning", "think hard"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			content, reasoning := streamDeltas(chunks, newTransform(nil, tt.mode))
+			if content[0] != tt.content {
+				t.Fatalf("content = %q, want %q", content[0], tt.content)
+			}
+			for _, field := range []string{"reasoning_content", "reasoning"} {
+				want := ""
+				if field == tt.field {
+					want = tt.reasoning
+				}
+				if reasoning[field] != want {
+					t.Fatalf("%s = %q, want %q", field, reasoning[field], want)
+				}
+			}
+		})
+	}
+}
+
+func TestMergedThinkClosedInLaterChunk(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		content []string
+	}{
+		{
+			"by content",
+			[]string{
+				`[{"index":0,"delta":{"reasoning_content":"a"}}]`,
+				`[{"index":0,"delta":{"reasoning_content":"b"}}]`,
+				`[{"index":0,"delta":{"role":"assistant"}}]`,
+				`[{"index":0,"delta":{"content":"c"}}]`,
+			},
+			[]string{"<think>ab</think>\n\nc"},
+		},
+		{
+			"by finish",
+			[]string{
+				`[{"index":0,"delta":{"reasoning_content":"a"}}]`,
+				`[{"index":0,"delta":{},"finish_reason":"length"}]`,
+			},
+			[]string{"<think>a</think>\n\n"},
+		},
+		{
+			"per choice",
+			[]string{
+				`[{"index":0,"delta":{"reasoning_content":"a"}},{"index":1,"delta":{"reasoning_content":"x"}}]`,
+				`[{"index":0,"delta":{"content":"b"}}]`,
+				`[{"index":1,"delta":{"reasoning_content":"y"}}]`,
+				`[{"index":1,"delta":{"content":"z"}}]`,
+			},
+			[]string{"<think>a</think>\n\nb", "<think>xy</think>\n\nz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, _ := streamDeltas(tt.chunks, newTransform(nil, reasoningMerge))
+			for i, want := range tt.content {
+				if content[i] != want {
+					t.Fatalf("content of choice %d = %q, want %q", i, content[i], want)
+				}
+			}
+		})
+	}
+}
+
+// streamDeltas joins content per choice index and reasoning fields of the
+// normalized deltas of chunks.
+func streamDeltas(chunks []string, tr *transform) (map[int]string, map[string]string) {
+	content, reasoning := map[int]string{}, map[string]string{}
+	for _, chunk := range chunks {
+		for _, choice := range decodeArray(normalizeStreamChoices(json.RawMessage(chunk), tr)) {
+			delta := decodeMap(choice["delta"])
+			index, _ := intValue(choice["index"])
+			content[index] += stringValue(delta["content"], "")
+			for _, field := range []string{"reasoning_content", "reasoning"} {
+				reasoning[field] += stringValue(delta[field], "")
+			}
+		}
+	}
+	return content, reasoning
+}
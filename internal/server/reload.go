@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"freeglm/internal/storage"
+)
+
+type routes struct {
+	models      map[string]GLMConfig
+	pools       map[string]keys
+	profiles    map[string]*Profile
+	proxyTokens map[string]string
+	aliases     map[string]string
+	failover    map[string][]string
+	audio       map[string]GLMConfig
+	images      map[string]GLMConfig
+	rewrites    []Rewrite
+	templates   map[string]Template
+	tenants     []*tenantRoutes
+	redact      *strings.Replacer
+}
+
+func newRoutes(opts Options, store storage.Storage) (*routes, error) {
+	factory, err := strategy(opts.KeyStrategy, opts.KeyQuota, store)
+	if err != nil {
+		return nil, err
+	}
+	models, pools, err := registry(opts.Providers, opts.Keys, opts.discovered, factory)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := models[opts.Model]; !ok {
+		return nil, fmt.Errorf("model tag must be one of %v", slices.Sorted(maps.Keys(models)))
+	}
+	for model, limit := range opts.MaxTokens {
+		config, ok := models[model]
+		if !ok {
+			return nil, fmt.Errorf("max tokens model tag must be one of %v", slices.Sorted(maps.Keys(models)))
+		}
+		if limit < 0 {
+			return nil, fmt.Errorf("max tokens for %s must not be negative", model)
+		}
+		config.MaxTokens = limit
+		models[model] = config
+	}
+	_profiles, proxyTokens, err := profileRegistry(opts.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := _profiles[opts.Profile]; !ok && opts.Profile != "" {
+		return nil, fmt.Errorf("profile must be one of %v", slices.Sorted(maps.Keys(_profiles)))
+	}
+	for alias, model := range opts.Aliases {
+		if _, ok := models[model]; !ok {
+			return nil, fmt.Errorf("alias %s model tag must be one of %v", alias, slices.Sorted(maps.Keys(models)))
+		}
+	}
+	aliases, err := templateAliases(opts.Aliases, opts.Templates, models)
+	if err != nil {
+		return nil, err
+	}
+	if err := validRewrites(opts.Rewrites); err != nil {
+		return nil, err
+	}
+	tenants, err := newTenants(opts, store)
+	if err != nil {
+		return nil, err
+	}
+	return &routes{
+		aliases:     aliases,
+		audio:       mediaRegistry(opts.Providers, func(p Provider) []string { return p.Audio }),
+		images:      mediaRegistry(opts.Providers, func(p Provider) []string { return p.Images }),
+		failover:    failoverGroups(models),
+		models:      models,
+		pools:       pools,
+		profiles:    _profiles,
+		proxyTokens: proxyTokens,
+		rewrites:    opts.Rewrites,
+		templates:   opts.Templates,
+		tenants:     tenants,
+		redact:      keyRedactor(opts),
+	}, nil
+}
+
+// Handler returns the proxy handler for mounting under another mux, wrap it
+// in http.StripPrefix for a path prefix. Listener and TLS options are not
+// used, --stats-file and --cache-file are saved every 10 sec. Zero values
+// are not flag defaults, only an empty Model is set to glm-4.7-flash.
+func Handler(opts Options) (http.Handler, error) {
+	if opts.Model == "" {
+		opts.Model = glm47flash
+	}
+	_server, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	return _server.Handler, nil
+}
+
+func (s *Server) Reload(opts Options) error {
+	return s.handler.reload(opts)
+}
+
+// resolve maps the requested model through aliases and falls back to
+// glm-4.7-flash for unknown models.
+func (rt *routes) resolve(name string) (string, GLMConfig) {
+	if model, ok := rt.aliases[name]; ok {
+		name = model
+	}
+	if config, ok := rt.models[name]; ok {
+		return name, config
+	}
+	return glm47flash, rt.models[glm47flash]
+}
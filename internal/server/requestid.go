@@ -0,0 +1,52 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID takes X-Request-ID of the client or generates one, sets it
+// on the response and keeps it in the request context for logs and upstream.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	if requestID(r.Context()) != "" {
+		return r
+	}
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = randomID("req_", 24)
+	}
+	w.Header().Set(requestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+}
+
+// validRequestID accepts up to 128 printable ASCII characters so client IDs
+// can not break log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// logf logs with request ID of the context as prefix.
+func logf(ctx context.Context, format string, args ...any) {
+	if id := requestID(ctx); id != "" {
+		format, args = "[%s] "+format, append([]any{id}, args...)
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxResumeEvents is how many last events of a stream are kept for replay.
+const maxResumeEvents = 8192
+
+// resumer keeps streamed events by chat ID so a client reconnecting with
+// Last-Event-ID gets the rest of the completion instead of a new one.
+type resumer struct {
+	window time.Duration
+
+	mu      sync.Mutex
+	streams map[string]*replay
+}
+
+type replay struct {
+	id string
+
+	mu      sync.Mutex
+	base    int
+	events  [][]byte
+	done    bool
+	notify  chan struct{}
+	expires time.Time
+}
+
+func newResumer(window time.Duration) *resumer {
+	if window <= 0 {
+		return nil
+	}
+	return &resumer{window: window, streams: map[string]*replay{}}
+}
+
+func (rs *resumer) open(id string) *replay {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	for key, rp := range rs.streams {
+		if rp.expired(now) {
+			delete(rs.streams, key)
+		}
+	}
+	rp := &replay{id: id, base: 1, notify: make(chan struct{})}
+	rs.streams[id] = rp
+	return rp
+}
+
+func (rs *resumer) get(id string) *replay {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rp, ok := rs.streams[id]
+	if !ok || rp.expired(time.Now()) {
+		return nil
+	}
+	return rp
+}
+
+func (rp *replay) expired(now time.Time) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	return rp.done && now.After(rp.expires)
+}
+
+// add stores the event with "id:" line in front and returns it.
+func (rp *replay) add(event []byte) []byte {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	seq := rp.base + len(rp.events)
+	out := make([]byte, 0, len(event)+len(rp.id)+16)
+	out = fmt.Appendf(out, "id: %s:%d\n", rp.id, seq)
+	out = append(out, event...)
+	if len(rp.events) == maxResumeEvents {
+		rp.events = rp.events[1:]
+		rp.base++
+	}
+	rp.events = append(rp.events, out)
+	close(rp.notify)
+	rp.notify = make(chan struct{})
+	return out
+}
+
+// finish keeps the events for window after the stream ends.
+func (rp *replay) finish(window time.Duration) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rp.done = true
+	rp.expires = time.Now().Add(window)
+	close(rp.notify)
+	rp.notify = make(chan struct{})
+}
+
+// since returns the events after seq, whether the stream is finished and
+// the channel closed on the next event, ok is false when seq is gone.
+func (rp *replay) since(seq int) (events [][]byte, done bool, notify <-chan struct{}, ok bool) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if seq+1 < rp.base {
+		return nil, false, nil, false
+	}
+	if idx := seq + 1 - rp.base; idx < len(rp.events) {
+		events = rp.events[idx:]
+	}
+	return events, rp.done, rp.notify, true
+}
+
+func parseEventID(id string) (string, int, bool) {
+	idx := strings.LastIndexByte(id, ':')
+	if idx <= 0 {
+		return "", 0, false
+	}
+	seq, err := strconv.Atoi(id[idx+1:])
+	if err != nil || seq < 0 {
+		return "", 0, false
+	}
+	return id[:idx], seq, true
+}
+
+// resumeStream replays the events after Last-Event-ID and follows the stream
+// until it is finished.
+func (h *handler) resumeStream(w http.ResponseWriter, r *http.Request, lastID string) {
+	id, seq, ok := parseEventID(lastID)
+	if !ok {
+		h.sendErrorJSON(w, http.StatusBadRequest, "Last-Event-ID must be {id}:{seq}")
+		return
+	}
+	rp := h.resume.get(id)
+	if rp == nil {
+		h.sendErrorJSON(w, http.StatusNotFound, fmt.Sprintf("Stream %s is expired or unknown", id))
+		return
+	}
+	events, done, notify, ok := rp.since(seq)
+	if !ok {
+		h.sendErrorJSON(w, http.StatusGone, fmt.Sprintf("Events of stream %s after %d are not kept anymore", id, seq))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.sendErrorJSON(w, http.StatusInternalServerError, "Streaming unsupported")
+		return
+	}
+	h.addCORSHeaders(w)
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	logf(r.Context(), "stream %s resumed after event %d", id, seq)
+	for {
+		for _, event := range events {
+			w.Write(event)
+		}
+		flusher.Flush()
+		seq += len(events)
+		if done {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		}
+		if events, done, notify, ok = rp.since(seq); !ok {
+			return
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestResumeMidStream drops the client after some events, reconnects with
+// Last-Event-ID while upstream is still generating and checks every event
+// arrives once and in order.
+func TestResumeMidStream(t *testing.T) {
+	const events, before = 10, 4
+	more := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := range events {
+			if i == before {
+				<-more
+			}
+			fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"%d \"}}]}\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer upstream.Close()
+	defer close(more)
+
+	s, err := New(Options{Keys: []string{"pool-key"}, Model: glm47, ResumeWindow: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := s.handler.routes.Load()
+	config := rt.models[glm47]
+	config.URL = upstream.URL
+	rt.models[glm47] = config
+	proxy := httptest.NewServer(s.handler)
+	defer proxy.Close()
+
+	post := func(ctx context.Context, lastID string) *bufio.Reader {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxy.URL+"/v1/chat/completions",
+			strings.NewReader(`{"model":"glm-4.7","stream":true,"messages":[{"role":"user","content":"count"}]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", resp.StatusCode)
+		}
+		return bufio.NewReader(resp.Body)
+	}
+
+	var content strings.Builder
+	chatID, seq := "", 0
+	// read takes the next event and checks it follows the previous one.
+	read := func(br *bufio.Reader) string {
+		t.Helper()
+		id, data := readEvent(t, br)
+		stream, n, ok := parseEventID(id)
+		if !ok || chatID != "" && stream != chatID || n != seq+1 {
+			t.Fatalf("event id %q after %s:%d", id, chatID, seq)
+		}
+		chatID, seq = stream, n
+		if data != "[DONE]" {
+			choices := decodeArray(decodeMap([]byte(data))["choices"])
+			content.WriteString(stringValue(decodeMap(choices[0]["delta"])["content"], ""))
+		}
+		return data
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	br := post(ctx, "")
+	for content.Len() < len("0 1 2 3 ") {
+		read(br)
+	}
+	cancel()
+
+	br = post(t.Context(), fmt.Sprintf("%s:%d", chatID, seq))
+	more <- struct{}{}
+	for read(br) != "[DONE]" {
+	}
+	if want := "0 1 2 3 4 5 6 7 8 9 "; content.String() != want {
+		t.Fatalf("content = %q, want %q", content.String(), want)
+	}
+}
+
+// readEvent returns the id and data of the next SSE event, skipping
+// comments.
+func readEvent(t *testing.T, br *bufio.Reader) (id, data string) {
+	t.Helper()
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && data != "":
+			return id, data
+		case strings.HasPrefix(line, "id: "):
+			id = line[len("id: "):]
+		case strings.HasPrefix(line, "data: "):
+			data = line[len("data: "):]
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+func retryable(status int) bool {
+	switch status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// delay is exponential backoff with jitter in [d/2, d).
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.backoff << attempt
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// send retries connection errors and 502/503/504 responses, the body is
+// rebuilt from req.GetBody for every attempt. Open circuits fail at once.
+func (h *handler) send(req *http.Request, model string) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := h.sendFailover(req, model, attempt > 0)
+		var reason string
+		var open *circuitOpenError
+		switch {
+		case errors.As(err, &open):
+			return nil, err
+		case err != nil:
+			reason = err.Error()
+		case retryable(resp.StatusCode):
+			reason = fmt.Sprintf("upstream %d", resp.StatusCode)
+		default:
+			if attempt > 0 {
+				logf(ctx, "%s ok after %d retries", model, attempt)
+			}
+			return resp, nil
+		}
+		if ctx.Err() != nil || attempt >= h.retry.attempts {
+			if attempt > 0 {
+				logf(ctx, "%s retry budget exhausted (%d/%d): %s", model, attempt, h.retry.attempts, reason)
+			}
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		wait := h.retry.delay(attempt)
+		logf(ctx, "%s retry %d/%d in %s: %s", model, attempt+1, h.retry.attempts, wait.Round(time.Millisecond), reason)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
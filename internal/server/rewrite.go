@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+)
+
+// Rewrite changes payloads of chat and completion requests matching all
+// of Match, remove goes first, then set, override and system messages.
+type Rewrite struct {
+	Match RewriteMatch
+	// Set fields missing in the payload.
+	Set map[string]json.RawMessage
+	// Override fields whether present or not.
+	Override      map[string]json.RawMessage
+	Remove        []string
+	PrependSystem string
+	AppendSystem  string
+}
+
+// RewriteMatch patterns are path.Match globs, empty ones match anything.
+type RewriteMatch struct {
+	Model  string
+	Path   string
+	Header map[string]string
+}
+
+func validRewrites(rewrites []Rewrite) error {
+	for idx, rw := range rewrites {
+		patterns := []string{rw.Match.Model, rw.Match.Path}
+		for _, pattern := range rw.Match.Header {
+			patterns = append(patterns, pattern)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rewrites[%d] pattern %q: %w", idx, pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (m RewriteMatch) matches(r *http.Request, model string) bool {
+	if !glob(m.Model, model) || !glob(m.Path, r.URL.Path) {
+		return false
+	}
+	for name, pattern := range m.Header {
+		value := r.Header.Get(name)
+		if value == "" || !glob(pattern, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func glob(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, value)
+	return ok
+}
+
+// rewrite applies every matching rule in config order, a rule overriding
+// model makes the next rules match the new model.
+func (rt *routes) rewrite(r *http.Request, payload map[string]json.RawMessage) {
+	for _, rw := range rt.rewrites {
+		if !rw.Match.matches(r, stringValue(payload["model"], "")) {
+			continue
+		}
+		for _, field := range rw.Remove {
+			delete(payload, field)
+		}
+		for field, value := range rw.Set {
+			if _, ok := payload[field]; !ok {
+				payload[field] = value
+			}
+		}
+		for field, value := range rw.Override {
+			payload[field] = value
+		}
+		if rw.PrependSystem == "" && rw.AppendSystem == "" {
+			continue
+		}
+		messages := decodeArray(payload["messages"])
+		if rw.PrependSystem != "" {
+			messages = append([]map[string]json.RawMessage{systemMessage(rw.PrependSystem)}, messages...)
+		}
+		if rw.AppendSystem != "" {
+			messages = append(messages, systemMessage(rw.AppendSystem))
+		}
+		payload["messages"] = chatMessages(messages)
+	}
+}
+
+func systemMessage(content string) map[string]json.RawMessage {
+	return map[string]json.RawMessage{"role": rawJSON("system"), "content": rawJSON(content)}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// logLineLimit is the default size of a log line, longer lines lose their
+// middle so prompts and completions quoted in errors stay out of logs.
+const logLineLimit = 2048
+
+var (
+	authorizationValue = regexp.MustCompile(`(?i)(authorization"?\s*[:=]\s*"?(?:bearer\s+)?)[^"\s,}]+`)
+	bearerToken        = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]{4,}`)
+)
+
+// scrubber redacts Authorization values, bearer tokens and configured keys
+// in log output and truncates long lines unless full is set.
+type scrubber struct {
+	mu   sync.Mutex
+	out  io.Writer
+	full bool
+	keys func() *strings.Replacer
+}
+
+func (s *scrubber) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	line := p
+	if s.keys != nil {
+		if keys := s.keys(); keys != nil {
+			line = []byte(keys.Replace(string(line)))
+		}
+	}
+	line = authorizationValue.ReplaceAll(line, []byte("${1}****"))
+	line = bearerToken.ReplaceAll(line, []byte("${1}****"))
+	if !s.full && len(line) > logLineLimit {
+		newline := bytes.HasSuffix(line, []byte("\n"))
+		cut := len(line) - logLineLimit
+		head := slices.Clone(line[:logLineLimit/2])
+		tail := line[len(line)-logLineLimit/2:]
+		line = append(append(head, fmt.Sprintf(" ... %d bytes truncated (--log-full-bodies) ... ", cut)...), bytes.TrimSuffix(tail, []byte("\n"))...)
+		if newline {
+			line = append(line, '\n')
+		}
+	}
+	if _, err := s.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// scrubLog puts the scrubber in front of the standard logger once, a later
+// server takes over its settings.
+func scrubLog(full bool, keys func() *strings.Replacer) *scrubber {
+	if s, ok := log.Writer().(*scrubber); ok {
+		s.mu.Lock()
+		s.full, s.keys = full, keys
+		s.mu.Unlock()
+		return s
+	}
+	s := &scrubber{out: log.Writer(), full: full, keys: keys}
+	log.SetOutput(s)
+	return s
+}
+
+// keyRedactor masks every configured key, shared, of providers and of
+// tenants.
+func keyRedactor(opts Options) *strings.Replacer {
+	keys := slices.Clone(opts.Keys)
+	for _, p := range opts.Providers {
+		keys = append(keys, p.Keys...)
+	}
+	for _, t := range opts.Tenants {
+		keys = append(keys, t.Keys...)
+		for _, p := range t.Providers {
+			keys = append(keys, p.Keys...)
+		}
+	}
+	var pairs []string
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			pairs = append(pairs, key, maskKey(key))
+		}
+	}
+	if len(pairs) == 0 {
+		return nil
+	}
+	return strings.NewReplacer(pairs...)
+}
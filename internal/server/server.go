@@ -1,20 +1,29 @@
 package server
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"maps"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"freeglm/internal/storage"
+	"freeglm/internal/threads"
+	"freeglm/internal/usagedb"
 )
 
 const (
@@ -23,36 +32,250 @@ const (
 )
 
 const (
-	letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	letters       = "abcdefghijklmnopqrstuvwxyz0123456789"
+	defaultTokens = 4096
 )
 
 type GLMConfig struct {
 	URL       string
+	Fallbacks []string
 	MaxTokens int
+	Provider  string
+	Vision    bool
+	Context   int
+
+	PromptCache bool
+	// Builtin is set for the built-in z.ai providers, only they get keys
+	// of clients and GLM specific translations.
+	Builtin bool
 }
 
 type keys interface {
 	next() string
+	// all keys of the pool in config order
+	all() []string
 }
 
 func Generator(_e []string) keys {
 	return &robin{e: _e}
 }
 
+type call struct {
+	model     string
+	alias     string
+	config    GLMConfig
+	token     string
+	stream    bool
+	start     time.Time
+	provider  keys
+	profile   *Profile
+	transform *transform
+	legacy    *completion
+	format    *responseFormat
+	used      usage
+	failed    string
+	cacheKey  string
+	virtual   string
+	tenant    string
+	dryRun    bool
+	stop      *stopFilter
+	user      string
+	rival     *rival
+	// tokensField is max_tokens or max_completion_tokens as the client sent.
+	tokensField string
+	// client is the fairness client, empty without --fair.
+	client string
+	// prefill is the text of a trailing assistant message.
+	prefill string
+}
+
+type Options struct {
+	Keys          []string
+	KeyStrategy   string
+	KeyQuota      int
+	Providers     []Provider
+	Model         string
+	Listen        string
+	Timeout       int
+	MaxTimeout    int
+	MaxTokens     map[string]int
+	NoClamp       bool
+	DefaultTokens int
+	MinTokens     int
+	TrustTokens   bool
+	Keepalive     int
+	StatsFile     string
+	UsageDB       string
+	Profiles      map[string]Profile
+	Profile       string
+	Reasoning     string
+	MaxBodySize   int64
+	RateLimit     float64
+	RateBurst     int
+	RateLimitBy   string
+	Retries       int
+	RetryBackoff  int
+	ThreadsDB     string
+	Storage       string
+	Hooks         []Hook
+	HookPlugins   []string
+	HookScripts   []string
+	Moderation    Moderation
+	TeeDir        string
+	Logprobs      string
+	SocketMode    os.FileMode
+	ReusePort     bool
+	TLSCert       string
+	TLSKey        string
+	TLSSelfSigned bool
+	JSONRepair    bool
+	JSONRetries   int
+	Aliases       map[string]string
+	Rewrites      []Rewrite
+	Templates     map[string]Template
+	Tenants       map[string]Tenant
+	AccessLog     string
+	AccessLogFile string
+	KeyCooldown   int
+	QueueWait     int
+	ResumeWindow  int
+	ReadyCheck    bool
+	Lanes         map[string]int
+	BatchDir      string
+	BatchWorkers  int
+	AdminToken    string
+	CacheSize     int
+	CacheTTL      int
+	CacheFile     string
+	NoCompress    bool
+	DryRun        bool
+	Affinity      bool
+	Dedup         bool
+	SplitTools    bool
+	Fair          bool
+	Race          bool
+	RaceMargin    int
+	Shadow        string
+	ShadowRate    float64
+	WebSocket     bool
+	LogFullBodies bool
+	Transport     Transport
+	UpstreamProxy string
+	VirtualKeys   string
+
+	BreakerThreshold int
+	BreakerCooldown  int
+
+	ContextStrategy string
+
+	// WebSocketOrigins are host patterns of other origins allowed to open
+	// WebSockets, the host of the server is always allowed.
+	WebSocketOrigins []string
+
+	// Prefill is auto, pass or emulate for requests ending with an
+	// assistant message.
+	Prefill string
+
+	// ImageUpload is "provider" or the URL base64 images over
+	// ImageUploadOver KiB are uploaded to, up to ImageUploadMax MiB.
+	ImageUpload     string
+	ImageUploadOver int
+	ImageUploadMax  int
+
+	// DailyBudget and KeyBudget are tokens per day of all keys and of
+	// every key, BudgetAction is reject or queue when used up.
+	DailyBudget    int
+	KeyBudget      int
+	BudgetAction   string
+	BudgetTimezone string
+
+	// DiscoverModels adds models listed by upstream /models of providers
+	// on start and every DiscoverInterval minutes (0 only on start).
+	DiscoverModels   bool
+	DiscoverInterval int
+
+	// NoKeepAlive closes every client connection after its response,
+	// IdleTimeout is seconds a kept alive connection waits for the next.
+	NoKeepAlive bool
+	IdleTimeout int
+
+	// discovered are model IDs by provider set by discovery.
+	discovered map[string][]string
+
+	// ConnectTimeout and FirstByteTimeout are seconds to connect upstream
+	// and to get response headers, Timeout is for the whole request.
+	ConnectTimeout   int
+	FirstByteTimeout int
+}
+
 type handler struct {
-	keys   keys
-	client *http.Client
+	routes    atomic.Pointer[routes]
+	client    *http.Client
+	stats     *stats
+	noClamp   bool
+	tokens    tokenPolicy
+	keepalive time.Duration
+	maxBody   int64
+	limiter   *limiter
+	retry     retryPolicy
+	timeouts  timeoutPolicy
+	race      racePolicy
+	shadow    shadowPolicy
+	fair      *fairness
+	budget    *budget
+	threads   threads.Store
+	usageDB   *usagedb.Store
+	store     storage.Storage
+	hooks     hooks
+
+	jsonRepair  bool
+	jsonRetries int
+	resume      *resumer
+	readiness   readiness
+	lanes       lanes
+	batches     *batchRunner
+	admin       *admin
+	upstreams   *upstreams
+	breakers    *breakers
+	cache       *responseCache
+	virtual     *virtualKeys
+	// tenantQuotas outlive reloads of tenants.
+	tenantQuotas *quotas
+
+	contextStrategy string
+	prefill         string
+	splitTools      bool
+	upload          *imageUpload
+
+	cooldowns   *cooldowns
+	keyCooldown time.Duration
+	queueWait   time.Duration
+
+	defaultProfile string
+	reasoning      string
+	dryRunAll      bool
+	teeDir         string
+	logprobs       string
+	affinity       bool
+	flights        *flights
+	webSocket      bool
+	wsOrigins      []string
 }
 
-var m = map[string]GLMConfig{
-	glm47: {
-		URL:       "https://api.z.ai/api/coding/paas/v4/chat/completions",
-		MaxTokens: 8192,
-	},
-	glm47flash: {
-		URL:       "https://api.z.ai/api/paas/v4/chat/completions",
-		MaxTokens: 8192,
-	},
+type Server struct {
+	*http.Server
+	handler    *handler
+	socketMode os.FileMode
+	reusePort  bool
+	certFile   string
+	keyFile    string
+
+	mu       sync.Mutex
+	listener net.Listener
+}
+
+func (s *Server) Stats() Stats {
+	return s.handler.stats.snapshot()
 }
 
 var messageLevels = []string{
@@ -65,42 +288,368 @@ var messageLevels = []string{
 	"mcp_metadata",
 }
 
-func New(
-	keys []string,
-	model string,
-	listen string,
-	timeout int,
-) (*http.Server, error) {
-	if _, ok := m[model]; !ok {
-		return nil, fmt.Errorf("model tag must be one of %v", slices.Collect(maps.Keys(m)))
-	}
-	return &http.Server{
-		Addr: listen,
-		Handler: &handler{
-			keys: Generator(keys),
-			client: &http.Client{
-				Timeout:   time.Duration(timeout) * time.Second,
-				Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
-			},
+func New(opts Options) (*Server, error) {
+	store, err := storage.Open(opts.Storage)
+	if err != nil {
+		return nil, fmt.Errorf("open storage: %w", err)
+	}
+	_routes, err := newRoutes(opts, store)
+	if err != nil {
+		return nil, err
+	}
+	_limiter, err := newLimiter(opts.RateLimit, opts.RateBurst, opts.RateLimitBy)
+	if err != nil {
+		return nil, err
+	}
+	_lanes, err := newLanes(opts.Lanes)
+	if err != nil {
+		return nil, err
+	}
+	_batches, err := newBatchRunner(opts.BatchDir, opts.BatchWorkers)
+	if err != nil {
+		return nil, err
+	}
+	if opts.TeeDir != "" {
+		if err := os.MkdirAll(opts.TeeDir, 0o700); err != nil {
+			return nil, fmt.Errorf("tee dir: %w", err)
+		}
+	}
+	_shadow, err := newShadowPolicy(opts.Shadow, opts.ShadowRate, _routes)
+	if err != nil {
+		return nil, err
+	}
+	_budget, err := newBudget(opts.DailyBudget, opts.KeyBudget, opts.BudgetAction, opts.BudgetTimezone, store)
+	if err != nil {
+		return nil, err
+	}
+	_upload, err := newImageUpload(opts.ImageUpload, opts.ImageUploadOver, opts.ImageUploadMax)
+	if err != nil {
+		return nil, err
+	}
+	_moderator, err := newModerator(opts.Moderation)
+	if err != nil {
+		return nil, err
+	}
+	if _moderator != nil {
+		opts.Hooks = append([]Hook{_moderator}, opts.Hooks...)
+	}
+	for _, path := range opts.HookPlugins {
+		hook, err := LoadPlugin(path)
+		if err != nil {
+			return nil, fmt.Errorf("load hook plugin %s: %w", path, err)
+		}
+		opts.Hooks = append(opts.Hooks, hook)
+	}
+	for _, command := range opts.HookScripts {
+		opts.Hooks = append(opts.Hooks, NewScriptHook(command))
+	}
+	_hooks, err := newHooks(opts.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Reasoning == "" {
+		opts.Reasoning = reasoningPassthrough
+	}
+	if err := validReasoning(opts.Reasoning); err != nil {
+		return nil, err
+	}
+	if opts.Logprobs == "" {
+		opts.Logprobs = logprobsStrip
+	}
+	if err := validLogprobs(opts.Logprobs); err != nil {
+		return nil, err
+	}
+	if opts.ContextStrategy == "" {
+		opts.ContextStrategy = contextOff
+	}
+	if err := validContextStrategy(opts.ContextStrategy); err != nil {
+		return nil, err
+	}
+	if opts.Prefill == "" {
+		opts.Prefill = prefillAuto
+	}
+	if err := validPrefill(opts.Prefill); err != nil {
+		return nil, err
+	}
+	if opts.DefaultTokens < 0 || opts.MinTokens < 0 {
+		return nil, fmt.Errorf("default and min max tokens must not be negative")
+	}
+	if opts.Transport.MaxIdleConnsPerHost < 0 || opts.Transport.IdleConnTimeout < 0 {
+		return nil, fmt.Errorf("transport max idle conns and idle timeout must not be negative")
+	}
+	if opts.DefaultTokens == 0 {
+		opts.DefaultTokens = defaultTokens
+	}
+	_client, err := newClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	_virtual, err := newVirtualKeys(opts.VirtualKeys, store)
+	if err != nil {
+		return nil, fmt.Errorf("load virtual keys: %w", err)
+	}
+	_handler := &handler{
+		client:  _client,
+		stats:   newStats(),
+		noClamp: opts.NoClamp,
+		tokens: tokenPolicy{
+			base:  opts.DefaultTokens,
+			floor: opts.MinTokens,
+			trust: opts.TrustTokens,
+		},
+		keepalive: time.Duration(opts.Keepalive) * time.Second,
+		maxBody:   opts.MaxBodySize,
+		limiter:   _limiter,
+		hooks:     _hooks,
+
+		jsonRepair:   opts.JSONRepair,
+		jsonRetries:  max(0, opts.JSONRetries),
+		resume:       newResumer(time.Duration(opts.ResumeWindow) * time.Second),
+		readiness:    readiness{upstream: opts.ReadyCheck},
+		lanes:        _lanes,
+		batches:      _batches,
+		admin:        newAdmin(opts),
+		cache:        newResponseCache(opts.CacheSize, time.Duration(opts.CacheTTL)*time.Second),
+		store:        store,
+		virtual:      _virtual,
+		tenantQuotas: newQuotas(nil, "tenant", store),
+
+		contextStrategy: opts.ContextStrategy,
+		prefill:         opts.Prefill,
+		splitTools:      opts.SplitTools,
+		upload:          _upload,
+
+		breakers:    newBreakers(opts.BreakerThreshold, opts.BreakerCooldown),
+		cooldowns:   newCooldowns(store),
+		keyCooldown: time.Duration(max(1, opts.KeyCooldown)) * time.Second,
+		queueWait:   time.Duration(max(0, opts.QueueWait)) * time.Second,
+		retry: retryPolicy{
+			attempts: max(0, opts.Retries),
+			backoff:  time.Duration(max(1, opts.RetryBackoff)) * time.Millisecond,
+		},
+		timeouts: timeoutPolicy{
+			total: time.Duration(max(0, opts.Timeout)) * time.Second,
+			max:   time.Duration(max(0, opts.MaxTimeout)) * time.Second,
+		},
+		race: racePolicy{
+			all:    opts.Race,
+			margin: time.Duration(max(0, opts.RaceMargin)) * time.Millisecond,
+		},
+		shadow:  _shadow,
+		fair:    newFairness(opts.Fair),
+		budget:  _budget,
+		flights: newFlights(opts.Dedup),
+
+		defaultProfile: opts.Profile,
+		reasoning:      opts.Reasoning,
+		dryRunAll:      opts.DryRun,
+		teeDir:         opts.TeeDir,
+		logprobs:       opts.Logprobs,
+		affinity:       opts.Affinity,
+		webSocket:      opts.WebSocket,
+		wsOrigins:      opts.WebSocketOrigins,
+	}
+	_handler.upstreams = newUpstreams(_handler.client)
+	_handler.routes.Store(_routes)
+	redact := func() *strings.Replacer { return _handler.routes.Load().redact }
+	scrubLog(opts.LogFullBodies, redact)
+	var logOut io.Writer = os.Stdout
+	if opts.AccessLog != "" && opts.AccessLogFile != "" {
+		f, err := os.OpenFile(opts.AccessLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("open access log: %w", err)
+		}
+		logOut = f
+	}
+	logOut = &scrubber{out: logOut, full: opts.LogFullBodies, keys: redact}
+	root, err := newAccessLog(newCompressor(_handler, opts.NoCompress), opts.AccessLog, logOut)
+	if err != nil {
+		return nil, err
+	}
+	_server := &Server{
+		Server: &http.Server{
+			Addr:        opts.Listen,
+			Handler:     root,
+			IdleTimeout: time.Duration(opts.IdleTimeout) * time.Second,
+		},
+		handler:    _handler,
+		socketMode: opts.SocketMode,
+		reusePort:  opts.ReusePort,
+	}
+	if _server.certFile, _server.keyFile, err = tlsFiles(opts); err != nil {
+		return nil, err
+	}
+	if _server.socketMode == 0 {
+		_server.socketMode = 0o600
+	}
+	// agents send requests back to back, reusing the connection saves a
+	// TCP (and TLS) handshake on each
+	_server.SetKeepAlivesEnabled(!opts.NoKeepAlive)
+	_server.RegisterOnShutdown(func() {
+		_handler.store.Close()
+	})
+	// with memory storage the cache stays a sized LRU and threads need --threads-db
+	_, memory := _handler.store.(*storage.Memory)
+	if !memory && _handler.cache != nil {
+		_handler.cache.store = _handler.store
+	}
+	// persist loops stop with the server
+	persist, stop := context.WithCancel(context.Background())
+	_server.RegisterOnShutdown(stop)
+	if opts.StatsFile != "" {
+		if err := _handler.stats.load(opts.StatsFile); err != nil {
+			return nil, fmt.Errorf("load stats: %w", err)
+		}
+		go _handler.stats.persist(persist, opts.StatsFile, 10*time.Second)
+		_server.RegisterOnShutdown(func() {
+			if err := _handler.stats.save(opts.StatsFile); err != nil {
+				log.Println("stats save error:", err)
+			}
+		})
+	}
+	if _virtual != nil {
+		go _virtual.persist(persist, 10*time.Second)
+		_server.RegisterOnShutdown(func() {
+			if err := _virtual.save(); err != nil {
+				log.Println("virtual keys usage save error:", err)
+			}
+		})
+	}
+	if opts.CacheFile != "" && _handler.cache != nil && _handler.cache.store == nil {
+		if err := _handler.cache.load(opts.CacheFile); err != nil {
+			return nil, fmt.Errorf("load cache: %w", err)
+		}
+		go _handler.cache.persist(persist, opts.CacheFile, 10*time.Second)
+		_server.RegisterOnShutdown(func() {
+			if err := _handler.cache.save(opts.CacheFile); err != nil {
+				log.Println("cache save error:", err)
+			}
+		})
+	}
+	switch {
+	case opts.ThreadsDB != "":
+		if _handler.threads, err = threads.Open(opts.ThreadsDB); err != nil {
+			return nil, fmt.Errorf("open threads: %w", err)
+		}
+		_server.RegisterOnShutdown(func() {
+			_handler.threads.Close()
+		})
+	case !memory:
+		_handler.threads = threads.New(_handler.store)
+	}
+	if opts.DiscoverModels {
+		ctx, cancel := context.WithCancel(context.Background())
+		_handler.discoverModels(ctx)
+		if opts.DiscoverInterval > 0 {
+			go _handler.discover(ctx, time.Duration(opts.DiscoverInterval)*time.Minute)
+		}
+		_server.RegisterOnShutdown(cancel)
+	}
+	if opts.UsageDB != "" {
+		if _handler.usageDB, err = usagedb.Open(opts.UsageDB); err != nil {
+			return nil, fmt.Errorf("open usage db: %w", err)
+		}
+		_server.RegisterOnShutdown(func() {
+			_handler.usageDB.Close()
+		})
+	}
+	return _server, nil
+}
+
+// Transport tunes upstream connections, zero values keep Go defaults.
+type Transport struct {
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     int
+	HTTP2               bool
+	DisableKeepAlives   bool
+}
+
+func newClient(opts Options) (*http.Client, error) {
+	proxy, err := upstreamProxy(opts.UpstreamProxy)
+	if err != nil {
+		return nil, err
+	}
+	// chat requests get their own total timeout, the client one is the
+	// longest they may ask for
+	timeout := opts.Timeout
+	if timeout > 0 {
+		timeout = max(timeout, opts.MaxTimeout)
+	}
+	dialer := &net.Dialer{
+		Timeout:   time.Duration(opts.ConnectTimeout) * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Timeout: time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			MaxIdleConnsPerHost: opts.Transport.MaxIdleConnsPerHost,
+			IdleConnTimeout:     time.Duration(opts.Transport.IdleConnTimeout) * time.Second,
+			ForceAttemptHTTP2:   opts.Transport.HTTP2,
+			DisableKeepAlives:   opts.Transport.DisableKeepAlives,
+			Proxy:               proxy,
+
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: time.Duration(opts.FirstByteTimeout) * time.Second,
 		},
 	}, nil
 }
 
 func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r = withRequestID(w, r)
+	r, tenant := h.tenant(r)
+	allowed := methods(r.URL.Path)
+	if r.Method == http.MethodOptions {
+		h.handleOptions(w, allowed)
+		return
+	}
+	if !h.allowMethod(w, r, allowed) {
+		return
+	}
 	switch r.Method {
-	case http.MethodOptions:
-		h.handleOptions(w)
-	case http.MethodGet:
+	case http.MethodGet, http.MethodHead:
+		if isWebSocketPath(r.URL.Path) {
+			h.handleWebSocket(w, r, tenant)
+			return
+		}
+		if isThreadsPath(r.URL.Path) || isBatchesPath(r.URL.Path) {
+			var ok bool
+			if r, ok = h.authorizeVirtual(w, r); !ok {
+				return
+			}
+		}
 		h.handleGet(w, r)
 	case http.MethodPost:
+		if !h.rateLimit(w, r) {
+			return
+		}
+		r, ok := h.authorizeVirtual(w, r)
+		if !ok || !h.admitTenant(w, tenant) {
+			return
+		}
 		h.handlePost(w, r)
-	default:
-		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+	case http.MethodDelete:
+		r, ok := h.authorizeVirtual(w, r)
+		if !ok {
+			return
+		}
+		if isBatchesPath(r.URL.Path) {
+			h.handleBatches(w, r)
+			return
+		}
+		h.handleThreads(w, r)
 	}
 }
 
-func (h *handler) handleOptions(w http.ResponseWriter) {
+// handleOptions answers preflights of any path with the methods of the path,
+// all methods for unknown paths and "*".
+func (h *handler) handleOptions(w http.ResponseWriter, allowed []string) {
+	if allowed == nil {
+		allowed = allMethods
+	}
 	h.addCORSHeaders(w)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowed, ", "))
 	w.Header().Set("Content-Length", "0")
 	w.WriteHeader(http.StatusOK)
 }
@@ -108,25 +657,57 @@ func (h *handler) handleOptions(w http.ResponseWriter) {
 func (h *handler) handleGet(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/v1/models", "/models":
-		data := make([]map[string]any, 0, len(m))
-		for id := range m {
+		_routes := h.routesFor(r.Context())
+		models := _routes.models
+		data := make([]map[string]any, 0, len(models))
+		for id, config := range models {
 			data = append(data, map[string]any{
 				"id":       id,
 				"object":   "model",
 				"created":  1700000000,
-				"owned_by": "zhipuai",
+				"owned_by": config.Provider,
+			})
+		}
+		for alias, model := range _routes.aliases {
+			if _, ok := models[alias]; ok {
+				continue
+			}
+			data = append(data, map[string]any{
+				"id":       alias,
+				"object":   "model",
+				"created":  1700000000,
+				"owned_by": models[model].Provider,
 			})
 		}
 		h.sendJSON(w, http.StatusOK, map[string]any{
 			"object": "list",
 			"data":   data,
 		})
-	case "/health":
-		h.sendJSON(w, http.StatusOK, map[string]any{
-			"status": "ok",
-			"models": slices.Collect(maps.Keys(m)),
-		})
+	case "/stats", "/v1/stats":
+		h.sendJSON(w, http.StatusOK, h.stats.snapshot())
+	case "/metrics":
+		h.handleMetrics(w)
+	case "/dashboard", "/dashboard/":
+		h.handleDashboard(w)
+	case "/dashboard/events":
+		h.handleDashboardEvents(w, r)
+	case "/health", "/healthz":
+		h.handleLive(w)
+	case "/version", "/v1/version":
+		h.handleVersion(w)
+	case "/readyz":
+		h.handleReady(w, r)
+	case "/admin/keys":
+		h.handleAdminKeys(w, r)
 	default:
+		if isThreadsPath(r.URL.Path) {
+			h.handleThreads(w, r)
+			return
+		}
+		if isBatchesPath(r.URL.Path) {
+			h.handleBatches(w, r)
+			return
+		}
 		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
 	}
 }
@@ -135,109 +716,472 @@ func (h *handler) handlePost(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/v1/chat/completions", "/chat/completions":
 		h.handleChat(w, r)
+	case "/v1/completions", "/completions":
+		h.handleCompletion(w, r)
+	case "/admin/reset":
+		h.handleAdminReset(w, r)
+	case "/admin/keys":
+		h.handleAdminKeys(w, r)
 	default:
+		if isThreadsPath(r.URL.Path) {
+			h.handleThreads(w, r)
+			return
+		}
+		if isBatchesPath(r.URL.Path) {
+			h.handleBatches(w, r)
+			return
+		}
+		if isAudioPath(r.URL.Path) {
+			h.handleAudio(w, r)
+			return
+		}
+		if isImagesPath(r.URL.Path) {
+			h.handleImages(w, r)
+			return
+		}
 		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
 	}
 }
 
 func (h *handler) handleChat(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	ensureMessages(payload)
+	if err := validateMessages(payload["messages"]); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.forward(w, r, payload, nil)
+}
+
+func (h *handler) decodeBody(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, bool) {
 	defer r.Body.Close()
-	payload, err := decodeJSONMap(r.Body)
+	if h.maxBody > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	}
+	payload, err := decodePayload(r)
 	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.sendErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+			return nil, false
+		}
+		if errors.Is(err, errUnsupportedMedia) {
+			h.sendErrorJSON(w, http.StatusUnsupportedMediaType, err.Error())
+			return nil, false
+		}
 		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid body: %v", err))
-		return
+		return nil, false
 	}
+	return payload, true
+}
 
+// clientKey returns Authorization of the client to send upstream, empty
+// when pool keys are used: for proxy tokens, clients without a key and
+// custom providers.
+func clientKey(r *http.Request, proxied bool, config GLMConfig) string {
 	key := r.Header.Get("Authorization")
-	if key == "" || key == "Bearer" {
-		key = "Bearer " + h.keys.next()
+	if proxied || !config.Builtin || key == "" || key == "Bearer" {
+		return ""
 	}
+	return key
+}
 
-	model := stringValue(payload["model"], glm47flash)
-	config, ok := m[model]
-	if !ok {
-		model = glm47flash
-		config = m[glm47flash]
+func (h *handler) forward(w http.ResponseWriter, r *http.Request, payload map[string]json.RawMessage, legacy *completion) {
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" && h.resume != nil {
+		h.resumeStream(w, r, lastID)
+		return
+	}
+	if err := h.hooks.onRequest(r, payload); err != nil {
+		if h.sendContentFilter(w, err) {
+			return
+		}
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_routes := h.routesFor(r.Context())
+	_routes.rewrite(r, payload)
+	_routes.template(payload)
+	requested := stringValue(payload["model"], glm47flash)
+	model, config := _routes.resolve(requested)
+
+	user := stringValue(payload["user"], "")
+	if !h.rateLimitUser(w, r, user) {
+		return
+	}
+
+	profile, proxied, err := _routes.profile(r, h.defaultProfile)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c := &call{
+		model:     model,
+		alias:     model,
+		config:    config,
+		provider:  _routes.pools[config.Provider],
+		profile:   profile,
+		transform: newTransform(profile, h.reasoning),
+		legacy:    legacy,
+		virtual:   virtualKeyName(r.Context()),
+		tenant:    tenantName(r.Context()),
+		user:      user,
+	}
+	if h.fair != nil {
+		c.client = fairClient(r)
+	}
+	key := clientKey(r, proxied, config)
+	pooled := key == ""
+	if pooled {
+		session := h.session(r, payload)
+		if session == "" && h.fair.over(c.client) {
+			// a heavy client keeps to its key and leaves the rest to others
+			session = c.client
+		}
+		next := h.sticky(c.provider, session)
+		key = "Bearer " + next
+	}
+	c.token = strings.TrimPrefix(key, "Bearer ")
+	c.stream, _ = boolValue(payload["stream"])
+	if _, ok := _routes.aliases[requested]; ok {
+		c.alias = requested
 	}
-	stream, _ := boolValue(payload["stream"])
 	payload["model"] = rawJSON(model)
-	payload["stream"] = rawJSON(stream)
-	ensureMessages(payload)
+	payload["stream"] = rawJSON(c.stream)
+	parallel, ok := boolValue(payload["parallel_tool_calls"])
+	c.transform.splitTools = h.splitTools || ok && !parallel
+	profile.request(payload)
+	if err := translateTools(payload, config.Builtin); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.transform.splitTools && c.legacy == nil {
+		if next := h.joinToolCalls(r.Context(), payload); next != nil {
+			h.sendHeldToolCall(w, r, c, next)
+			return
+		}
+	}
+	if !config.PromptCache {
+		stripCacheControl(payload)
+	}
+	if dropped, err := translateContent(payload, config.Vision); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	} else if dropped > 0 {
+		logf(r.Context(), "%s is text-only, dropped %d media parts", model, dropped)
+	}
+	if _, err := h.uploadImages(r.Context(), payload, c); err != nil {
+		switch {
+		case errors.Is(err, errImageTooLarge):
+			h.sendErrorJSON(w, http.StatusRequestEntityTooLarge, err.Error())
+		case errors.Is(err, errUnsupportedMedia):
+			h.sendErrorJSON(w, http.StatusUnsupportedMediaType, err.Error())
+		case errors.Is(err, errUpload):
+			h.sendErrorJSON(w, http.StatusBadGateway, err.Error())
+		default:
+			h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	if c.prefill = trailingPrefill(payload["messages"]); c.prefill != "" && h.prefill == prefillEmulate {
+		emulatePrefill(payload, c)
+	}
+	if c.format, err = translateFormat(payload); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if c.stream {
+		stops, _ := stopSequences(payload["stop"])
+		choices, _ := intValue(payload["n"])
+		c.stop = newStopFilter(stops, choices)
+	}
+	translateUser(payload, config.Provider)
+	timeout, err := h.requestTimeout(r, payload)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	warnings, err := translateParams(payload, c.stream)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var tokenWarnings []string
+	c.tokensField, tokenWarnings = translateMaxTokens(payload)
+	for _, warning := range append(warnings, tokenWarnings...) {
+		w.Header().Add(warningHeader, warning)
+	}
+	if c.transform.logprobs, err = translateLogprobs(payload, h.logprobs, legacy != nil && legacy.logprobs); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	ensureTemperature(payload)
-	payload["max_tokens"] = rawJSON(clampTokens(payload["max_tokens"], config.MaxTokens))
+	n, _ := intValue(payload["n"])
+	if n > maxChoices {
+		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("n must not be greater than %d", maxChoices))
+		return
+	}
+	delete(payload, "n")
+	if !h.noClamp && config.MaxTokens > 0 {
+		payload["max_tokens"] = rawJSON(clampTokens(payload["max_tokens"], config.MaxTokens, h.tokens))
+	}
+	if !h.noClamp {
+		if prompt, lowered := fitTokens(payload, config); lowered > 0 {
+			logf(r.Context(), "%s %s lowered to %d for ~%d prompt tokens", model, c.tokensField, lowered, prompt)
+			w.Header().Add(warningHeader, fmt.Sprintf("%s lowered to %d to fit ~%d prompt tokens in the %s context", c.tokensField, lowered, prompt, model))
+		}
+	}
 
-	data, err := json.Marshal(payload)
-	if err != nil {
-		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Encode error: %v", err))
+	if err := h.fitContext(r.Context(), payload, c); err != nil {
+		h.sendError(w, http.StatusBadRequest, codeContextLength, "messages", err.Error())
+		return
+	}
+	if h.dryRun(r) {
+		c.dryRun = true
+		c.start = time.Now()
+		resp := dryRunResponse(r, c, payload)
+		annotate(w, c, nil)
+		w.Header().Set(dryRunHeader, "true")
+		logf(r.Context(), "%s dry run", c.alias)
+		if c.stream {
+			h.handleStream(w, r, resp, c)
+			return
+		}
+		h.handleNormal(w, resp, c)
 		return
 	}
+	if h.cache != nil && !c.stream {
+		lookup, store, err := cacheMode(r.Header.Get(cacheHeader))
+		if err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if lookup || store {
+			buf := getBuffer()
+			encodeJSONMap(buf, payload)
+			key := cacheKey(buf.Bytes(), c, n, pooled)
+			putBuffer(buf)
+			if body, ok := h.cache.get(key); ok && lookup {
+				logf(r.Context(), "%s -> cache hit", c.alias)
+				annotate(w, c, nil)
+				w.Header().Set(cacheHeader, "hit")
+				h.writeJSONBytes(w, http.StatusOK, body)
+				return
+			}
+			if store {
+				c.cacheKey = key
+			}
+		}
+		w.Header().Set(cacheHeader, "miss")
+	}
+	if h.flights != nil && !c.stream {
+		buf := getBuffer()
+		encodeJSONMap(buf, payload)
+		key := cacheKey(buf.Bytes(), c, n, pooled)
+		putBuffer(buf)
+		fl, leader := h.flights.join(key)
+		if !leader {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-fl.done:
+			}
+			if sendShared(w, fl) {
+				logf(r.Context(), "%s -> shared with identical request in flight", c.alias)
+				return
+			}
+		} else {
+			defer h.flights.land(key, fl)
+			w = &flightWriter{ResponseWriter: w, flight: fl}
+		}
+	}
+	if !h.admitBudget(w, r, c.token) {
+		return
+	}
+	if n <= 1 && h.racing(r) {
+		c.rival = h.newRival(_routes, c, payload)
+	}
+	if n <= 1 {
+		h.mirror(r, _routes, c, payload)
+	}
 
-	req, err := http.NewRequest(http.MethodPost, config.URL, bytes.NewReader(data))
+	ctx := r.Context()
+	if c.stream && h.resume != nil {
+		// upstream keeps generating for a client which may reconnect
+		ctx = context.WithoutCancel(ctx)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := newJSONRequest(ctx, config.URL, payload)
 	if err != nil {
 		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Request error: %v", err))
 		return
 	}
-
 	req.Header.Set("Authorization", key)
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(requestIDHeader, requestID(r.Context()))
 
-	start := time.Now()
-	resp, err := h.client.Do(req)
+	release, err := h.lanes.acquire(r, h.fair.weight(c.client))
 	if err != nil {
-		h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Connection error: %v", err))
+		if r.Context().Err() == nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+	defer release()
+	h.fair.begin(c.client)
+	defer h.fair.end(c.client)
+
+	h.stats.request(c.token, c.model, c.user, c.stream)
+
+	c.start = time.Now()
+	h.stats.begin(c.stream)
+	defer func() {
+		h.stats.finish(c, r.Context().Err() != nil)
+	}()
+	resp, err := h.dispatch(r, req, c, n, pooled)
+	if err == nil && resp.StatusCode == http.StatusBadRequest && c.prefill != "" && h.prefill == prefillAuto {
+		resp.Body.Close()
+		logf(r.Context(), "%s rejected the prefill, asking again to continue it", model)
+		emulatePrefill(payload, c)
+		setJSONBody(req, payload)
+		resp, err = h.dispatch(r, req, c, n, pooled)
+	}
+	annotate(w, c, resp)
+	if err != nil {
+		if r.Context().Err() != nil {
+			logf(r.Context(), "%s canceled by client (%.1fs)", model, time.Since(c.start).Seconds())
+			return
+		}
+		h.failure(c, err.Error())
+		h.sendConnectionError(w, err)
 		return
 	}
 
 	if resp.StatusCode >= 400 {
-		h.handleUpstreamError(w, resp, start)
+		h.failure(c, h.handleUpstreamError(w, resp, c.start))
 		return
 	}
 
-	if stream {
-		h.handleStream(w, resp, model)
+	if c.stream {
+		h.handleStream(w, r, resp, c)
 		return
 	}
 
-	defer resp.Body.Close()
-	h.handleNormal(w, resp, model, time.Since(start))
+	h.handleNormal(w, resp, c)
 }
 
-func (h *handler) handleUpstreamError(w http.ResponseWriter, resp *http.Response, start time.Time) {
-	defer resp.Body.Close()
-	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
-	msg := strings.TrimSpace(string(bodyBytes))
-	var parsed map[string]any
-	if err := json.Unmarshal(bodyBytes, &parsed); err == nil {
-		if errMap, ok := parsed["error"].(map[string]any); ok {
-			if text, ok := errMap["message"].(string); ok && text != "" {
-				msg = text
-			}
-		}
+func (h *handler) usage(c *call, u usage) {
+	c.used = u
+	if c.dryRun {
+		return
+	}
+	h.stats.usage(c.token, c.model, c.user, u)
+	if c.virtual != "" {
+		h.virtual.used(c.virtual, u.total)
+	}
+	if c.tenant != "" {
+		h.tenantQuotas.used(c.tenant, u.total)
 	}
-	if msg == "" {
-		msg = fmt.Sprintf("upstream error %d", resp.StatusCode)
+	h.fair.used(c.client, u.total)
+	h.budget.used(c.token, u.total)
+	if t, ok := c.provider.(tracker); ok {
+		t.used(c.token, u.total)
 	}
-	log.Printf("upstream %d (%.1fs)", resp.StatusCode, time.Since(start).Seconds())
-	h.sendErrorJSON(w, resp.StatusCode, msg)
+	if h.usageDB != nil {
+		if err := h.usageDB.Add(time.Now(), keyID(c.token), c.model, u.prompt, u.completion, u.cached); err != nil {
+			log.Println("usage db error:", err)
+		}
+	}
+}
+
+func (h *handler) failure(c *call, msg string) {
+	c.failed = msg
+	h.stats.failure(c.token, c.model, c.user, msg)
 }
 
-func (h *handler) handleNormal(w http.ResponseWriter, resp *http.Response, model string, elapsed time.Duration) {
-	body, err := io.ReadAll(resp.Body)
+func (h *handler) handleUpstreamError(w http.ResponseWriter, resp *http.Response, start time.Time) string {
+	defer resp.Body.Close()
+	bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	msg := upstreamMessage(resp.StatusCode, bodyBytes)
+	logf(resp.Request.Context(), "upstream %d (%.1fs)", resp.StatusCode, time.Since(start).Seconds())
+	h.sendError(w, resp.StatusCode, upstreamCode(resp.StatusCode, bodyBytes), "", msg)
+	return msg
+}
+
+func (h *handler) handleNormal(w http.ResponseWriter, resp *http.Response, c *call) {
+	body, out := getBuffer(), getBuffer()
+	defer putBuffer(body)
+	defer putBuffer(out)
+	normalized, u, err := h.readResponse(resp, c, body, out)
+	if err == nil && c.format != nil && h.jsonRepair {
+		normalized, u, err = h.repairFormat(resp, c, normalized, u, body, out)
+	}
 	if err != nil {
-		h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Read error: %v", err))
+		if resp.Request.Context().Err() != nil {
+			logf(resp.Request.Context(), "%s canceled by client (%.1fs)", c.model, time.Since(c.start).Seconds())
+			return
+		}
+		h.failure(c, err.Error())
+		h.sendErrorJSON(w, http.StatusBadGateway, err.Error())
 		return
 	}
-
-	normalized, tokens, err := normalizeResponse(body, model)
+	normalized, err = h.hooks.onResponse(resp.Request, normalized)
 	if err != nil {
-		h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Invalid response: %v", err))
+		h.failure(c, err.Error())
+		if h.sendContentFilter(w, err) {
+			return
+		}
+		h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Response hook: %v", err))
 		return
 	}
-	log.Printf("%s -> %s tok, %.1fs", model, tokens, elapsed.Seconds())
+	if c.legacy != nil {
+		out := getBuffer()
+		defer putBuffer(out)
+		if err := c.legacy.response(out, normalized); err != nil {
+			h.failure(c, err.Error())
+			h.sendErrorJSON(w, http.StatusBadGateway, fmt.Sprintf("Invalid response: %v", err))
+			return
+		}
+		normalized = out.Bytes()
+	}
+	h.usage(c, u)
+	logf(resp.Request.Context(), "%s -> %s tok, %.1fs", c.model, u.String(), time.Since(c.start).Seconds())
+	h.holdToolCalls(resp.Request.Context(), c)
+	if c.cacheKey != "" {
+		h.cache.put(c.cacheKey, normalized)
+	}
 	h.writeJSONBytes(w, http.StatusOK, normalized)
 }
 
-func (h *handler) handleStream(w http.ResponseWriter, resp *http.Response, model string) {
+func (h *handler) readResponse(resp *http.Response, c *call, body, out *bytes.Buffer) ([]byte, usage, error) {
+	defer resp.Body.Close()
+	body.Reset()
+	out.Reset()
+	if resp.ContentLength > 0 {
+		body.Grow(int(resp.ContentLength))
+	}
+	if _, err := body.ReadFrom(resp.Body); err != nil {
+		return nil, usage{}, fmt.Errorf("Read error: %v", err)
+	}
+	if !c.transform.rewrites() {
+		if normalized, u, ok := passthroughResponse(body.Bytes(), c.alias); ok {
+			return normalized, u, nil
+		}
+	}
+	u, err := normalizeResponse(out, body.Bytes(), c.alias, c.transform)
+	if err != nil {
+		return nil, usage{}, fmt.Errorf("Invalid response: %v", err)
+	}
+	return out.Bytes(), u, nil
+}
+
+func (h *handler) handleStream(w http.ResponseWriter, r *http.Request, resp *http.Response, c *call) {
 	defer resp.Body.Close()
 	flusher, ok := w.(http.Flusher)
 	if !ok {
@@ -246,93 +1190,183 @@ func (h *handler) handleStream(w http.ResponseWriter, resp *http.Response, model
 	}
 
 	h.addCORSHeaders(w)
-	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
 	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "close")
 	w.WriteHeader(http.StatusOK)
 	flusher.Flush()
 
 	chatID := openAIID()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 64*1024), 1<<20)
-	doneSent := false
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || !strings.HasPrefix(line, "data:") {
-			continue
+	if c.legacy != nil {
+		chatID = completionID()
+	}
+	modelRaw := rawJSON(c.alias)
+	var rp *replay
+	if h.resume != nil {
+		rp = h.resume.open(chatID)
+		defer rp.finish(h.resume.window)
+	}
+	tee := h.openTee(r, c)
+	if tee != nil {
+		defer tee.Close()
+	}
+	gone, canceled := false, r.Context().Done()
+	var first time.Time
+	defer func() {
+		if !first.IsZero() && !c.dryRun {
+			h.streamed(r, c, first)
+		}
+	}()
+	send := func(event []byte) {
+		if rp != nil {
+			event = rp.add(event)
 		}
-		payload := strings.TrimSpace(line[5:])
-		if payload == "[DONE]" {
-			fmt.Fprintf(w, "data: [DONE]\n\n")
+		if tee != nil {
+			tee.Write(event)
+		}
+		if !gone {
+			w.Write(event)
 			flusher.Flush()
-			doneSent = true
-			break
 		}
+	}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	done := make(chan struct{})
+	defer close(done)
+	upstream := readEvents(resp.Body, done)
 
-		frame, err := normalizeStreamChunk([]byte(payload), model, chatID)
-		if err != nil {
-			continue
+	var (
+		ticker    *time.Ticker
+		keepalive <-chan time.Time
+	)
+	if h.keepalive > 0 {
+		ticker = time.NewTicker(h.keepalive)
+		defer ticker.Stop()
+		keepalive = ticker.C
+	}
+	// deliver sends the normalized chunk in buf through hooks and legacy
+	// completions format to the client.
+	deliver := func(u usage) {
+		if keep, err := h.hooks.onChunk(r, buf, 6); !keep {
+			if err != nil {
+				logf(r.Context(), "stream chunk hook: %v", err)
+			}
+			return
+		}
+		if c.legacy != nil {
+			if err := c.legacy.chunk(buf, 6); err != nil {
+				return
+			}
+		}
+		if u.total != 0 {
+			h.usage(c, u)
+		}
+		buf.WriteString("\n\n")
+		if first.IsZero() {
+			first = time.Now()
+		}
+		send(buf.Bytes())
+		if ticker != nil {
+			ticker.Reset(h.keepalive)
 		}
-		fmt.Fprintf(w, "data: %s\n\n", frame)
-		flusher.Flush()
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Println("stream error:", err)
+loop:
+	for {
+		select {
+		case <-canceled:
+			if rp == nil {
+				logf(r.Context(), "%s stream canceled by client", c.model)
+				return
+			}
+			logf(r.Context(), "%s client gone, stream %s kept for resume", c.model, chatID)
+			gone, canceled = true, nil
+		case <-keepalive:
+			if !gone {
+				fmt.Fprintf(w, ": keepalive\n\n")
+				flusher.Flush()
+			}
+		case payload, ok := <-upstream.data:
+			if !ok {
+				if err := upstream.err; err != nil {
+					h.failure(c, err.Error())
+					logf(r.Context(), "stream error: %v", err)
+				}
+				break loop
+			}
+			if bytes.Equal(payload, []byte("[DONE]")) {
+				break loop
+			}
+
+			buf.Reset()
+			buf.WriteString("data: ")
+			u, err := normalizeStreamChunk(buf, payload, modelRaw, chatID, c.transform)
+			if err != nil {
+				continue
+			}
+			if c.stop != nil {
+				if keep, err := c.stop.chunk(buf, 6); !keep || err != nil {
+					continue
+				}
+			}
+			deliver(u)
+			if c.stop != nil && c.stop.finished() {
+				logf(r.Context(), "%s stop sequence matched, upstream cut off", c.model)
+				break loop
+			}
+		}
 	}
-	if !doneSent {
-		fmt.Fprintf(w, "data: [DONE]\n\n")
-		flusher.Flush()
+
+	h.holdToolCalls(r.Context(), c)
+	buf.Reset()
+	buf.WriteString("data: ")
+	if c.stop != nil && c.stop.flush(buf, chatID, modelRaw) {
+		deliver(usage{})
 	}
+	send([]byte("data: [DONE]\n\n"))
 }
 
 func (h *handler) sendJSON(w http.ResponseWriter, status int, data any) {
-	body, err := json.Marshal(data)
-	if err != nil {
+	body := getBuffer()
+	defer putBuffer(body)
+	if err := encode(body, data); err != nil {
 		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Marshal error: %v", err))
 		return
 	}
-	h.writeJSONBytes(w, status, body)
+	h.writeJSONBytes(w, status, bytes.TrimSuffix(body.Bytes(), []byte("\n")))
 }
 
 func (h *handler) writeJSONBytes(w http.ResponseWriter, status int, body []byte) {
 	h.addCORSHeaders(w)
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
-	w.Header().Set("Connection", "close")
 	w.WriteHeader(status)
 	w.Write(body)
 }
 
-func (h *handler) sendErrorJSON(w http.ResponseWriter, status int, message string) {
-	payload := map[string]any{
-		"error": map[string]any{
-			"message": message,
-			"type":    "api_error",
-			"code":    status,
-		},
-	}
-	h.sendJSON(w, status, payload)
-}
-
 func (h *handler) addCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "*")
 }
 
 func decodeJSONMap(r io.Reader) (map[string]json.RawMessage, error) {
-	data, err := io.ReadAll(r)
-	if err != nil {
+	var payload map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&payload); err != nil && err != io.EOF {
 		return nil, err
 	}
-	if len(bytes.TrimSpace(data)) == 0 {
-		return map[string]json.RawMessage{}, nil
+	if payload == nil {
+		payload = map[string]json.RawMessage{}
 	}
+	return payload, nil
+}
+
+func decodeJSONBytes(data []byte) (map[string]json.RawMessage, error) {
 	var payload map[string]json.RawMessage
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return nil, err
+	if len(bytes.TrimSpace(data)) != 0 {
+		if err := json.Unmarshal(data, &payload); err != nil {
+			return nil, err
+		}
 	}
 	if payload == nil {
 		payload = map[string]json.RawMessage{}
@@ -340,27 +1374,132 @@ func decodeJSONMap(r io.Reader) (map[string]json.RawMessage, error) {
 	return payload, nil
 }
 
+type jsonWriter interface {
+	io.Writer
+	io.ByteWriter
+}
+
+func encodeJSONMap(w jsonWriter, m map[string]json.RawMessage) error {
+	w.WriteByte('{')
+	first := true
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if len(m[key]) == 0 {
+			continue
+		}
+		if !first {
+			w.WriteByte(',')
+		}
+		first = false
+		w.Write(mustMarshal(key))
+		w.WriteByte(':')
+		w.Write(m[key])
+	}
+	return w.WriteByte('}')
+}
+
+// newJSONRequest builds a POST request with m as a JSON body which
+// GetBody can replay for retries and failover.
+func newJSONRequest(ctx context.Context, url string, m map[string]json.RawMessage) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	setJSONBody(req, m)
+	return req, nil
+}
+
+// setJSONBody sends m as a JSON body streamed from the values decoded from
+// the client instead of a copy, large tool results are not held twice. The
+// parts are taken once, so later changes of m do not race with the body
+// being sent, and GetBody replays them for retries and failover.
+func setJSONBody(req *http.Request, m map[string]json.RawMessage) {
+	parts := jsonParts(m)
+	req.ContentLength = 0
+	for _, part := range parts {
+		req.ContentLength += int64(len(part))
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		body := slices.Clone(parts)
+		return io.NopCloser(&body), nil
+	}
+	req.Body, _ = req.GetBody()
+}
+
+// jsonParts are the pieces of m encoded as by encodeJSONMap, values are
+// not copied.
+func jsonParts(m map[string]json.RawMessage) net.Buffers {
+	parts := net.Buffers{[]byte("{")}
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		if len(m[key]) == 0 {
+			continue
+		}
+		if len(parts) > 1 {
+			parts = append(parts, []byte(","))
+		}
+		parts = append(parts, append(mustMarshal(key), ':'), m[key])
+	}
+	return append(parts, []byte("}"))
+}
+
 func ensureMessages(m map[string]json.RawMessage) {
 	if raw := m["messages"]; isNullJSON(raw) {
 		m["messages"] = rawJSON([]any{})
 	}
 }
 
+var roles = []string{"system", "developer", "user", "assistant", "tool", "function"}
+
+func validateMessages(raw json.RawMessage) error {
+	var messages []json.RawMessage
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return fmt.Errorf("messages must be an array")
+	}
+	for idx, item := range messages {
+		msg := decodeMap(item)
+		if msg == nil {
+			return fmt.Errorf("messages[%d] must be an object", idx)
+		}
+		role := stringValue(msg["role"], "")
+		if !slices.Contains(roles, role) {
+			return fmt.Errorf("messages[%d].role must be one of %v", idx, roles)
+		}
+		content, ok := msg["content"]
+		switch {
+		case !ok || isNullJSON(content):
+			if role != "assistant" || isNullJSON(msg["tool_calls"]) && isNullJSON(msg["function_call"]) {
+				return fmt.Errorf("messages[%d].content is required", idx)
+			}
+		case content[0] != '"' && content[0] != '[':
+			return fmt.Errorf("messages[%d].content must be a string or an array of parts", idx)
+		}
+	}
+	return nil
+}
+
 func ensureTemperature(m map[string]json.RawMessage) {
 	if raw, ok := m["temperature"]; !ok || isNullJSON(raw) {
 		m["temperature"] = rawJSON(0.7)
 	}
 }
 
-func clampTokens(raw json.RawMessage, limit int) int {
+type tokenPolicy struct {
+	base  int
+	floor int
+	trust bool
+}
+
+func clampTokens(raw json.RawMessage, limit int, policy tokenPolicy) int {
 	if limit <= 0 {
 		return 0
 	}
-	base := min(4096, limit)
+	base := min(policy.base, limit)
 	if n, ok := intValue(raw); ok {
 		if n < 1 {
 			n = base
 		}
+		if n < policy.floor && !policy.trust {
+			n = policy.floor
+		}
 		if n > limit {
 			n = limit
 		}
@@ -369,13 +1508,10 @@ func clampTokens(raw json.RawMessage, limit int) int {
 	return base
 }
 
-func normalizeResponse(body []byte, model string) ([]byte, string, error) {
-	resp, err := decodeJSONMap(bytes.NewReader(body))
+func normalizeResponse(out *bytes.Buffer, body []byte, model string, t *transform) (usage, error) {
+	resp, err := decodeJSONBytes(body)
 	if err != nil {
-		return nil, "", err
-	}
-	if len(resp) == 0 {
-		resp = map[string]json.RawMessage{}
+		return usage{}, err
 	}
 	if _, ok := resp["id"]; !ok {
 		resp["id"] = rawJSON(openAIID())
@@ -387,22 +1523,18 @@ func normalizeResponse(body []byte, model string) ([]byte, string, error) {
 		resp["created"] = rawJSON(time.Now().Unix())
 	}
 	resp["model"] = rawJSON(model)
-	resp["choices"] = normalizeChoices(resp["choices"])
-	tokens := rawToText(extractNested(resp, "usage", "total_tokens"))
-	if tokens == "" {
-		tokens = "?"
-	}
-	encoded, err := json.Marshal(resp)
-	if err != nil {
-		return nil, "", err
+	resp["choices"] = normalizeChoices(resp["choices"], t)
+	normalizeCachedTokens(resp)
+	if err := encodeJSONMap(out, resp); err != nil {
+		return usage{}, err
 	}
-	return encoded, tokens, nil
+	return extractUsage(resp), nil
 }
 
-func normalizeStreamChunk(raw []byte, model, fallbackID string) ([]byte, error) {
-	chunk, err := decodeJSONMap(bytes.NewReader(raw))
+func normalizeStreamChunk(buf *bytes.Buffer, raw []byte, model json.RawMessage, fallbackID string, t *transform) (usage, error) {
+	chunk, err := decodeJSONBytes(raw)
 	if err != nil {
-		return nil, err
+		return usage{}, err
 	}
 	if _, ok := chunk["id"]; !ok {
 		chunk["id"] = rawJSON(fallbackID)
@@ -413,12 +1545,32 @@ func normalizeStreamChunk(raw []byte, model, fallbackID string) ([]byte, error)
 	if _, ok := chunk["created"]; !ok {
 		chunk["created"] = rawJSON(time.Now().Unix())
 	}
-	chunk["model"] = rawJSON(model)
-	chunk["choices"] = normalizeStreamChoices(chunk["choices"])
-	return json.Marshal(chunk)
+	chunk["model"] = model
+	chunk["choices"] = normalizeStreamChoices(chunk["choices"], t)
+	normalizeCachedTokens(chunk)
+	if err := encodeJSONMap(buf, chunk); err != nil {
+		return usage{}, err
+	}
+	return extractUsage(chunk), nil
 }
 
-func normalizeChoices(raw json.RawMessage) json.RawMessage {
+func extractUsage(root map[string]json.RawMessage) usage {
+	u := usage{}
+	if _, ok := root["usage"]; !ok {
+		return u
+	}
+	u.prompt, _ = intValue(extractNested(root, "usage", "prompt_tokens"))
+	u.completion, _ = intValue(extractNested(root, "usage", "completion_tokens"))
+	u.total, _ = intValue(extractNested(root, "usage", "total_tokens"))
+	if cached, ok := intValue(extractNested(root, "usage", "prompt_tokens_details", "cached_tokens")); ok {
+		u.cached = cached
+	} else {
+		u.cached, _ = intValue(extractNested(root, "usage", "cache_read_input_tokens"))
+	}
+	return u
+}
+
+func normalizeChoices(raw json.RawMessage, t *transform) json.RawMessage {
 	choices := decodeArray(raw)
 	if len(choices) == 0 {
 		return mustMarshal([]map[string]json.RawMessage{defaultChoice()})
@@ -427,24 +1579,49 @@ func normalizeChoices(raw json.RawMessage) json.RawMessage {
 		if _, ok := choices[idx]["index"]; !ok {
 			choices[idx]["index"] = rawJSON(idx)
 		}
+		normalizeFinish(choices[idx])
+		if t != nil && t.logprobs {
+			choices[idx]["logprobs"] = emptyLogprobs
+		}
 		msg := buildChoiceMessage(choices[idx])
+		enforceToolCalls(msg, false)
+		index, _ := intValue(choices[idx]["index"])
+		t.unprefill(index, msg, true)
+		t.splitToolCalls(index, msg)
+		t.message(msg)
 		choices[idx]["message"] = mustMarshal(msg)
 		delete(choices[idx], "delta")
 	}
 	return mustMarshal(choices)
 }
 
-func normalizeStreamChoices(raw json.RawMessage) json.RawMessage {
+func normalizeStreamChoices(raw json.RawMessage, t *transform) json.RawMessage {
 	choices := decodeArray(raw)
 	if len(choices) == 0 {
 		return mustMarshal(choices)
 	}
+	if !t.rewrites() && choicesConformant(choices, "delta", "message") {
+		return raw
+	}
 	for idx := range choices {
 		if _, ok := choices[idx]["index"]; !ok {
 			choices[idx]["index"] = rawJSON(idx)
 		}
+		normalizeFinish(choices[idx])
+		if t != nil && t.logprobs {
+			choices[idx]["logprobs"] = emptyLogprobs
+		}
 		msg := buildDeltaMessage(choices[idx])
+		if msg == nil && t.rewrites() && !isNullJSON(choices[idx]["finish_reason"]) {
+			msg = map[string]json.RawMessage{}
+		}
 		if msg != nil {
+			index, _ := intValue(choices[idx]["index"])
+			t.toolDeltas(index, msg)
+			t.splitToolDeltas(msg)
+			enforceToolCalls(msg, true)
+			t.unprefill(index, msg, !isNullJSON(choices[idx]["finish_reason"]))
+			t.delta(index, msg, !isNullJSON(choices[idx]["finish_reason"]))
 			choices[idx]["delta"] = mustMarshal(msg)
 		} else {
 			delete(choices[idx], "delta")
@@ -454,6 +1631,43 @@ func normalizeStreamChoices(raw json.RawMessage) json.RawMessage {
 	return mustMarshal(choices)
 }
 
+func choicesConformant(choices []map[string]json.RawMessage, field, other string) bool {
+	for _, choice := range choices {
+		if _, ok := choice["index"]; !ok {
+			return false
+		}
+		if _, ok := choice[other]; ok || !canonicalFinish(choice) {
+			return false
+		}
+		for _, level := range messageLevels {
+			if _, ok := choice[level]; ok {
+				return false
+			}
+		}
+		raw, ok := choice[field]
+		if !ok {
+			if field == "message" {
+				return false
+			}
+			continue
+		}
+		msg := decodeMap(raw)
+		if msg == nil {
+			return false
+		}
+		if _, ok := msg["content"]; !ok {
+			return false
+		}
+		if _, ok := msg["tool_calls"]; ok {
+			return false
+		}
+		if stringValue(msg["role"], "") == "" {
+			return false
+		}
+	}
+	return true
+}
+
 func buildChoiceMessage(choice map[string]json.RawMessage) map[string]json.RawMessage {
 	if msg := decodeMap(choice["message"]); len(msg) != 0 {
 		enforceMessageDefaults(msg)
@@ -491,6 +1705,12 @@ func enforceMessageDefaults(msg map[string]json.RawMessage) {
 	}
 }
 
+func enforceToolCalls(msg map[string]json.RawMessage, stream bool) {
+	if raw, ok := msg["tool_calls"]; ok && !isNullJSON(raw) {
+		msg["tool_calls"] = normalizeToolCalls(raw, stream)
+	}
+}
+
 func mergeMessageFields(choice, msg map[string]json.RawMessage) {
 	for _, field := range messageLevels {
 		if val, ok := choice[field]; ok {
@@ -501,6 +1721,27 @@ func mergeMessageFields(choice, msg map[string]json.RawMessage) {
 	}
 }
 
+const maxPooledBuffer = 4 << 20
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
 func decodeArray(raw json.RawMessage) []map[string]json.RawMessage {
 	if isNullJSON(raw) {
 		return nil
@@ -542,8 +1783,22 @@ func isNullJSON(raw json.RawMessage) bool {
 	return len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null"))
 }
 
+func encode(w io.Writer, value any) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(value)
+}
+
+func marshal(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
 func rawJSON(value any) json.RawMessage {
-	b, err := json.Marshal(value)
+	b, err := marshal(value)
 	if err != nil {
 		return nil
 	}
@@ -551,7 +1806,7 @@ func rawJSON(value any) json.RawMessage {
 }
 
 func mustMarshal(value any) json.RawMessage {
-	b, _ := json.Marshal(value)
+	b, _ := marshal(value)
 	return b
 }
 
@@ -615,25 +1870,6 @@ func intValue(raw json.RawMessage) (int, bool) {
 	return 0, false
 }
 
-func rawToText(raw json.RawMessage) string {
-	if isNullJSON(raw) {
-		return ""
-	}
-	var s string
-	if err := json.Unmarshal(raw, &s); err == nil && s != "" {
-		return s
-	}
-	var n int
-	if err := json.Unmarshal(raw, &n); err == nil {
-		return strconv.Itoa(n)
-	}
-	var f float64
-	if err := json.Unmarshal(raw, &f); err == nil {
-		return strconv.Itoa(int(f))
-	}
-	return ""
-}
-
 func extractNested(root map[string]json.RawMessage, keys ...string) json.RawMessage {
 	current := root
 	for idx, key := range keys {
@@ -657,9 +1893,13 @@ func extractNested(root map[string]json.RawMessage, keys ...string) json.RawMess
 }
 
 func openAIID() string {
-	b := make([]byte, 29)
+	return randomID("chatcmpl-", 29)
+}
+
+func randomID(prefix string, n int) string {
+	b := make([]byte, n)
 	for i := range b {
 		b[i] = letters[rand.Intn(len(letters))]
 	}
-	return "chatcmpl-" + string(b)
+	return prefix + string(b)
 }
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+)
+
+func TestJSONBodyReplays(t *testing.T) {
+	payload := map[string]json.RawMessage{
+		"model":    rawJSON("glm-4.7"),
+		"messages": json.RawMessage(`[{"role":"tool","content":"` + string(bytes.Repeat([]byte("x"), 1<<20)) + `"}]`),
+		"stop":     nil,
+	}
+	var want bytes.Buffer
+	encodeJSONMap(&want, payload)
+	req, err := newJSONRequest(t.Context(), "http://127.0.0.1/chat/completions", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changes after the request is built are not sent
+	payload["model"] = rawJSON("glm-4.7-flash")
+	for i := range 2 {
+		body := req.Body
+		if i > 0 {
+			if body, err = req.GetBody(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want.Bytes()) || req.ContentLength != int64(len(got)) {
+			t.Fatalf("body %d = %.80s (%d bytes, content length %d)", i, got, len(got), req.ContentLength)
+		}
+	}
+}
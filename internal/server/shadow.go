@@ -0,0 +1,154 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// maxShadows bounds mirrored requests in flight, requests over it are not
+// mirrored.
+const maxShadows = 16
+
+// shadowTimeout bounds a mirrored request which nobody waits for.
+const shadowTimeout = 5 * time.Minute
+
+var shadowBuckets = []float64{0.5, 1, 2, 4, 8, 16, 32, 64, 128}
+
+// shadowPolicy mirrors rate percent of chat requests to model.
+type shadowPolicy struct {
+	model    string
+	rate     float64
+	inflight chan struct{}
+}
+
+func newShadowPolicy(model string, rate float64, rt *routes) (shadowPolicy, error) {
+	if model == "" {
+		return shadowPolicy{}, nil
+	}
+	if _, ok := rt.models[cmp.Or(rt.aliases[model], model)]; !ok {
+		return shadowPolicy{}, fmt.Errorf("shadow model must be one of %v", slices.Sorted(maps.Keys(rt.models)))
+	}
+	if rate < 0 || rate > 100 {
+		return shadowPolicy{}, errors.New("shadow rate must be between 0 and 100")
+	}
+	return shadowPolicy{model: model, rate: rate, inflight: make(chan struct{}, maxShadows)}, nil
+}
+
+// shadowMetrics are the results of mirrored requests by shadow model.
+type shadowMetrics struct {
+	usage   map[string]Usage
+	skipped int64
+	latency map[string]*histogram
+}
+
+func newShadowMetrics() shadowMetrics {
+	return shadowMetrics{usage: map[string]Usage{}, latency: map[string]*histogram{}}
+}
+
+func (s *stats) shadowed(model string, latency time.Duration, u usage, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := s.shadow.usage[model]
+	m.Requests++
+	if failed {
+		m.Errors++
+	} else {
+		m.add(u)
+		observe(s.shadow.latency, model, shadowBuckets, latency.Seconds())
+	}
+	s.shadow.usage[model] = m
+}
+
+func (s *stats) shadowSkipped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shadow.skipped++
+}
+
+// mirror sends a copy of payload to the shadow model in the background for
+// a sampled request. The copy is never streamed, so latency is the whole
+// completion; the answer is discarded and only recorded in /metrics and the
+// log next to the primary request.
+func (h *handler) mirror(r *http.Request, rt *routes, c *call, payload map[string]json.RawMessage) {
+	if h.shadow.model == "" || rand.Float64()*100 >= h.shadow.rate {
+		return
+	}
+	model := cmp.Or(rt.aliases[h.shadow.model], h.shadow.model)
+	config, ok := rt.models[model]
+	if !ok || model == c.model {
+		return
+	}
+	token := c.token
+	if config.Provider != c.config.Provider {
+		pool, ok := rt.pools[config.Provider]
+		if !ok {
+			return
+		}
+		token, _ = h.pick(pool)
+	}
+	select {
+	case h.shadow.inflight <- struct{}{}:
+	default:
+		h.stats.shadowSkipped()
+		return
+	}
+	shadow := maps.Clone(payload)
+	shadow["model"] = rawJSON(model)
+	shadow["stream"] = rawJSON(false)
+	delete(shadow, "stream_options")
+	if !h.noClamp && config.MaxTokens > 0 {
+		shadow["max_tokens"] = rawJSON(clampTokens(payload["max_tokens"], config.MaxTokens, h.tokens))
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), shadowTimeout)
+	go func() {
+		defer func() { <-h.shadow.inflight }()
+		defer cancel()
+		start := time.Now()
+		u, err := h.sendShadow(ctx, config.URL, token, shadow)
+		latency := time.Since(start)
+		h.stats.shadowed(model, latency, u, err != nil)
+		if err != nil {
+			logf(ctx, "shadow %s failed after %.1fs: %v", model, latency.Seconds(), err)
+			return
+		}
+		logf(ctx, "shadow %s -> %s tok, %.1fs", model, u.String(), latency.Seconds())
+	}()
+}
+
+// sendShadow sends the mirrored payload once, without retries, failover or
+// circuit breakers which belong to real traffic.
+func (h *handler) sendShadow(ctx context.Context, url, token string, payload map[string]json.RawMessage) (usage, error) {
+	req, err := newJSONRequest(ctx, url, payload)
+	if err != nil {
+		return usage{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(requestIDHeader, requestID(ctx))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return usage{}, err
+	}
+	defer resp.Body.Close()
+	if err := decodeBody(resp); err != nil {
+		return usage{}, err
+	}
+	if resp.StatusCode >= 400 {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return usage{}, fmt.Errorf("upstream %d", resp.StatusCode)
+	}
+	body, err := decodeJSONMap(resp.Body)
+	if err != nil {
+		return usage{}, err
+	}
+	return extractUsage(body), nil
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+// syncInterval is how often counters of other replicas are read from
+// shared storage when they are not needed per request.
+const syncInterval = 5 * time.Second
+
+// keyID names an API key in shared storage without storing the key.
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+func spentKey(key string, now time.Time) string {
+	return "spent:" + keyID(key) + ":" + now.UTC().Format(time.DateOnly)
+}
+
+// sharedRobin takes keys in turn with a counter in shared storage, so
+// replicas behind a load balancer do not all start from the first key.
+// It falls back to its own turn when storage is unavailable.
+type sharedRobin struct {
+	robin
+	store storage.Storage
+	id    string
+}
+
+func newSharedRobin(_e []string, store storage.Storage) *sharedRobin {
+	return &sharedRobin{robin: robin{e: _e}, store: store, id: keyID(strings.Join(_e, "\n"))}
+}
+
+func (g *sharedRobin) next() string {
+	if len(g.e) == 0 {
+		return ""
+	}
+	n, err := g.store.Incr(context.Background(), "rotation:"+g.id, 1, 0)
+	if err != nil {
+		log.Println("shared key rotation error:", err)
+		return g.robin.next()
+	}
+	return g.e[(n-1)%int64(len(g.e))]
+}
+
+// sharedLRU takes the key least recently used by any replica, last use
+// times live in shared storage under "lastused:". Replicas picking at the
+// same moment may take the same key. It falls back to its own times when
+// storage is unavailable.
+type sharedLRU struct {
+	lru
+	store storage.Storage
+}
+
+func newSharedLRU(_e []string, store storage.Storage) *sharedLRU {
+	return &sharedLRU{lru: lru{e: _e, last: make([]time.Time, len(_e))}, store: store}
+}
+
+func (g *sharedLRU) next() string {
+	if len(g.e) == 0 {
+		return ""
+	}
+	ctx := context.Background()
+	i, oldest := 0, int64(0)
+	for j, key := range g.e {
+		var last int64
+		data, err := g.store.Get(ctx, lastUsedKey(key))
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+		case err != nil:
+			log.Println("shared key rotation error:", err)
+			return g.lru.next()
+		default:
+			last, _ = strconv.ParseInt(string(data), 10, 64)
+		}
+		if j == 0 || last < oldest {
+			i, oldest = j, last
+		}
+	}
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err := g.store.Set(ctx, lastUsedKey(g.e[i]), []byte(now), 0); err != nil {
+		log.Println("shared key rotation error:", err)
+	}
+	return g.e[i]
+}
+
+func lastUsedKey(key string) string {
+	return "lastused:" + keyID(key)
+}
+
+// sync reads tokens spent today by all replicas every syncInterval, storage
+// is read without holding g.mu so picks do not wait for it.
+func (g *remaining) sync() {
+	g.mu.Lock()
+	now := time.Now()
+	if now.Sub(g.synced) <= syncInterval {
+		g.mu.Unlock()
+		return
+	}
+	g.synced = now
+	g.mu.Unlock()
+	spent := make([]int, len(g.e))
+	for i, key := range g.e {
+		data, err := g.store.Get(context.Background(), spentKey(key, now))
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Println("shared key usage error:", err)
+			return
+		}
+		spent[i], _ = strconv.Atoi(string(data))
+	}
+	g.mu.Lock()
+	copy(g.spent, spent)
+	g.mu.Unlock()
+}
+
+// resetShared drops rotation counters, last use times and tokens spent by
+// keys of all replicas.
+func resetShared(store storage.Storage) error {
+	ctx := context.Background()
+	for _, prefix := range []string{"rotation:", "lastused:", "spent:"} {
+		err := store.Scan(ctx, prefix, func(key string, _ []byte) error {
+			return store.Delete(ctx, key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+// splitTTL is how long tool calls held back by --split-tool-calls wait for
+// the client to come back with results.
+const splitTTL = time.Hour
+
+func splitKey(id string) string {
+	return "toolsplit:" + id
+}
+
+// splitToolCalls keeps only the first of parallel tool calls of a message
+// and holds back the others, for clients which run one call per turn.
+func (t *transform) splitToolCalls(index int, msg map[string]json.RawMessage) {
+	if t == nil || !t.splitTools {
+		return
+	}
+	calls := decodeArray(msg["tool_calls"])
+	if len(calls) < 2 {
+		return
+	}
+	t.split[index] = calls
+	msg["tool_calls"] = mustMarshal(calls[:1])
+}
+
+// splitToolDeltas drops stream deltas of all but the first tool call, the
+// dropped calls are collected by toolDeltas.
+func (t *transform) splitToolDeltas(msg map[string]json.RawMessage) {
+	if t == nil || !t.splitTools {
+		return
+	}
+	raw, ok := msg["tool_calls"]
+	if !ok || isNullJSON(raw) {
+		return
+	}
+	calls := decodeArray(raw)
+	first := calls[:0]
+	for _, call := range calls {
+		if index, _ := intValue(call["index"]); index == 0 {
+			first = append(first, call)
+		}
+	}
+	if len(first) == 0 {
+		delete(msg, "tool_calls")
+		return
+	}
+	msg["tool_calls"] = mustMarshal(first)
+}
+
+// heldToolCalls returns all calls of every choice which had some held back.
+func (t *transform) heldToolCalls() [][]map[string]json.RawMessage {
+	if t == nil || !t.splitTools {
+		return nil
+	}
+	var held [][]map[string]json.RawMessage
+	for _, calls := range t.split {
+		held = append(held, calls)
+	}
+	for _, s := range t.tools {
+		if len(s.calls) < 2 {
+			continue
+		}
+		calls := make([]map[string]json.RawMessage, 0, len(s.calls))
+		for _, st := range s.calls {
+			calls = append(calls, map[string]json.RawMessage{
+				"id":   rawJSON(st.id),
+				"type": rawJSON("function"),
+				"function": mustMarshal(map[string]string{
+					"name":      st.name,
+					"arguments": st.args,
+				}),
+			})
+		}
+		held = append(held, calls)
+	}
+	return held
+}
+
+// holdToolCalls stores the calls of split messages under the ID of every
+// call, so the turn answering any of them finds the rest.
+func (h *handler) holdToolCalls(ctx context.Context, c *call) {
+	for _, calls := range c.transform.heldToolCalls() {
+		value := mustMarshal(calls)
+		for _, call := range calls {
+			if err := h.store.Set(ctx, splitKey(stringValue(call["id"], "")), value, splitTTL); err != nil {
+				log.Println("split tool calls error:", err)
+				return
+			}
+		}
+		logf(ctx, "%s -> %d tool calls split, %d held back", c.alias, len(calls), len(calls)-1)
+	}
+}
+
+// joinToolCalls undoes split tool calls in messages. When the client sent
+// the results of the calls it got so far it returns the next held back
+// call, otherwise the assistant messages with one call each are joined
+// into the message upstream sent, followed by the results.
+func (h *handler) joinToolCalls(ctx context.Context, payload map[string]json.RawMessage) map[string]json.RawMessage {
+	type split struct {
+		calls []map[string]json.RawMessage
+		sent  map[string]bool
+		first int
+	}
+	messages := decodeArray(payload["messages"])
+	owners := make([]*split, len(messages))
+	splits := map[string]*split{}
+	last := -1
+	for i, msg := range messages {
+		if stringValue(msg["role"], "") != "assistant" {
+			continue
+		}
+		last = i
+		calls := decodeArray(msg["tool_calls"])
+		if len(calls) != 1 {
+			continue
+		}
+		id := stringValue(calls[0]["id"], "")
+		value, err := h.store.Get(ctx, splitKey(id))
+		if err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				log.Println("split tool calls error:", err)
+			}
+			continue
+		}
+		var all []map[string]json.RawMessage
+		if json.Unmarshal(value, &all) != nil || len(all) == 0 {
+			continue
+		}
+		first := stringValue(all[0]["id"], "")
+		s := splits[first]
+		if s == nil {
+			s = &split{calls: all, sent: map[string]bool{}, first: i}
+			splits[first] = s
+		}
+		s.sent[id] = true
+		owners[i] = s
+	}
+	if len(splits) == 0 {
+		return nil
+	}
+	if s := owners[last]; s != nil {
+		answered := true
+		for _, msg := range messages[last+1:] {
+			answered = answered && stringValue(msg["role"], "") == "tool"
+		}
+		for _, call := range s.calls {
+			if answered && !s.sent[stringValue(call["id"], "")] {
+				return call
+			}
+		}
+	}
+	joined := make([]map[string]json.RawMessage, 0, len(messages))
+	for i, msg := range messages {
+		s := owners[i]
+		switch {
+		case s == nil:
+			joined = append(joined, msg)
+		case s.first == i:
+			var sent []map[string]json.RawMessage
+			for _, call := range s.calls {
+				if s.sent[stringValue(call["id"], "")] {
+					sent = append(sent, call)
+				}
+			}
+			msg["tool_calls"] = mustMarshal(sent)
+			joined = append(joined, msg)
+		}
+	}
+	payload["messages"] = mustMarshal(joined)
+	return nil
+}
+
+// sendHeldToolCall answers with a held back tool call without asking
+// upstream, in the format the client asked for.
+func (h *handler) sendHeldToolCall(w http.ResponseWriter, r *http.Request, c *call, next map[string]json.RawMessage) {
+	// like a dry run it is not counted as upstream usage and latency
+	c.dryRun, c.start = true, time.Now()
+	message := map[string]any{"role": "assistant", "content": nil, "tool_calls": []any{next}}
+	resp := fakeResponse(r, c, "chatcmpl-", message, "tool_calls")
+	annotate(w, c, nil)
+	logf(r.Context(), "%s -> held back tool call %s", c.alias, stringValue(next["id"], ""))
+	if c.stream {
+		h.handleStream(w, r, resp, c)
+		return
+	}
+	h.handleNormal(w, resp, c)
+}
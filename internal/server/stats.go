@@ -0,0 +1,314 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type Usage struct {
+	Requests         int64 `json:"requests"`
+	Errors           int64 `json:"errors"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	CachedTokens     int64 `json:"cached_tokens"`
+}
+
+// KeyStats is usage of a key, listed under its keyID since masks of
+// different keys can be the same.
+type KeyStats struct {
+	Usage
+	Key       string    `json:"key"`
+	LastError string    `json:"last_error,omitempty"`
+	LastUsed  time.Time `json:"last_used"`
+}
+
+type Stats struct {
+	Usage
+	Started time.Time           `json:"started"`
+	Streams int64               `json:"streams"`
+	Keys    map[string]KeyStats `json:"keys"`
+	Models  map[string]Usage    `json:"models"`
+	Users   map[string]Usage    `json:"users,omitempty"`
+}
+
+type Recent struct {
+	Time    time.Time `json:"time"`
+	Model   string    `json:"model"`
+	Key     string    `json:"key"`
+	Stream  bool      `json:"stream"`
+	Latency float64   `json:"latency"`
+	Tokens  int       `json:"tokens"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type Live struct {
+	Stats
+	Active        int      `json:"active"`
+	ActiveStreams int      `json:"active_streams"`
+	Recent        []Recent `json:"recent"`
+}
+
+type usage struct {
+	prompt     int
+	completion int
+	total      int
+	cached     int
+}
+
+type stats struct {
+	mu    sync.Mutex
+	s     Stats
+	dirty bool
+
+	active  int
+	streams int
+	recent  []Recent
+	metrics streamMetrics
+	shadow  shadowMetrics
+}
+
+const recentSize = 50
+
+// maxUsers bounds users counted by name, the rest is counted as otherUser.
+const (
+	maxUsers  = 1000
+	otherUser = "(other)"
+)
+
+func newStats() *stats {
+	return &stats{
+		s: Stats{
+			Started: time.Now(),
+			Keys:    map[string]KeyStats{},
+			Models:  map[string]Usage{},
+			Users:   map[string]Usage{},
+		},
+		metrics: newStreamMetrics(),
+		shadow:  newShadowMetrics(),
+	}
+}
+
+func (s *stats) request(key, model, user string, stream bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+	s.s.Requests++
+	if stream {
+		s.s.Streams++
+	}
+	k := s.s.Keys[keyID(key)]
+	k.Key = maskKey(key)
+	k.Requests++
+	k.LastUsed = time.Now()
+	s.s.Keys[keyID(key)] = k
+	m := s.s.Models[model]
+	m.Requests++
+	s.s.Models[model] = m
+	if user = s.userName(user); user != "" {
+		u := s.s.Users[user]
+		u.Requests++
+		s.s.Users[user] = u
+	}
+}
+
+// userName is the stats entry of the OpenAI user field, empty for none.
+func (s *stats) userName(user string) string {
+	if _, ok := s.s.Users[user]; ok || user == "" || len(s.s.Users) < maxUsers {
+		return user
+	}
+	return otherUser
+}
+
+func (s *stats) failure(key, model, user string, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+	s.s.Errors++
+	k := s.s.Keys[keyID(key)]
+	k.Key = maskKey(key)
+	k.Errors++
+	k.LastError = msg
+	s.s.Keys[keyID(key)] = k
+	m := s.s.Models[model]
+	m.Errors++
+	s.s.Models[model] = m
+	if user = s.userName(user); user != "" {
+		u := s.s.Users[user]
+		u.Errors++
+		s.s.Users[user] = u
+	}
+}
+
+func (s *stats) usage(key, model, user string, u usage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+	s.s.Usage.add(u)
+	k := s.s.Keys[keyID(key)]
+	k.Key = maskKey(key)
+	k.Usage.add(u)
+	s.s.Keys[keyID(key)] = k
+	m := s.s.Models[model]
+	m.add(u)
+	s.s.Models[model] = m
+	if user = s.userName(user); user != "" {
+		n := s.s.Users[user]
+		n.add(u)
+		s.s.Users[user] = n
+	}
+}
+
+func (s *stats) begin(stream bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active++
+	if stream {
+		s.streams++
+	}
+}
+
+func (s *stats) finish(c *call, canceled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if c.stream {
+		s.streams--
+	}
+	r := Recent{
+		Time:    c.start,
+		Model:   c.model,
+		Key:     maskKey(c.token),
+		Stream:  c.stream,
+		Latency: time.Since(c.start).Seconds(),
+		Tokens:  c.used.total,
+		Error:   c.failed,
+	}
+	if r.Error == "" && canceled {
+		r.Error = "canceled by client"
+	}
+	if len(s.recent) == recentSize {
+		s.recent = s.recent[1:]
+	}
+	s.recent = append(s.recent, r)
+}
+
+// reset zeroes usage counters, stream metrics and recent requests, requests in flight are
+// still counted as active.
+func (s *stats) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s = newStats().s
+	s.recent = nil
+	s.metrics = newStreamMetrics()
+	s.dirty = true
+}
+
+func (s *stats) live() Live {
+	snap := s.snapshot()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Live{
+		Stats:         snap,
+		Active:        s.active,
+		ActiveStreams: s.streams,
+		Recent:        slices.Clone(s.recent),
+	}
+}
+
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.s
+	snap.Keys = maps.Clone(s.s.Keys)
+	snap.Models = maps.Clone(s.s.Models)
+	snap.Users = maps.Clone(s.s.Users)
+	return snap
+}
+
+func (s *stats) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(data, &s.s); err != nil {
+		return err
+	}
+	if s.s.Keys == nil {
+		s.s.Keys = map[string]KeyStats{}
+	}
+	if s.s.Models == nil {
+		s.s.Models = map[string]Usage{}
+	}
+	if s.s.Users == nil {
+		s.s.Users = map[string]Usage{}
+	}
+	return nil
+}
+
+func (s *stats) save(path string) error {
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dirty = false
+	s.mu.Unlock()
+	data, err := json.MarshalIndent(s.snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *stats) persist(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.save(path); err != nil {
+				log.Println("stats save error:", err)
+			}
+		}
+	}
+}
+
+func (u *Usage) add(n usage) {
+	u.PromptTokens += int64(n.prompt)
+	u.CompletionTokens += int64(n.completion)
+	u.TotalTokens += int64(n.total)
+	u.CachedTokens += int64(n.cached)
+}
+
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:4] + "****" + key[len(key)-4:]
+}
+
+func (u usage) String() string {
+	if u.total == 0 {
+		return "?"
+	}
+	return strconv.Itoa(u.total)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// stopFilter enforces all client stop sequences on stream content, as GLM
+// takes only the first one and does not always honor it. The tail of the
+// content which may start a stop sequence is held back until the next chunk
+// tells whether it does; on a match content is cut before it and the choice
+// finishes with "stop".
+type stopFilter struct {
+	stops   []string
+	choices int
+	held    map[int]string
+	done    map[int]bool
+}
+
+func newStopFilter(stops []string, choices int) *stopFilter {
+	if len(stops) == 0 {
+		return nil
+	}
+	return &stopFilter{stops: stops, choices: max(1, choices), held: map[int]string{}, done: map[int]bool{}}
+}
+
+// chunk filters content of the JSON chunk written to buf after offset in
+// place. It returns false when nothing is left to send.
+func (f *stopFilter) chunk(buf *bytes.Buffer, offset int) (bool, error) {
+	chunk, err := decodeJSONBytes(bytes.Clone(buf.Bytes()[offset:]))
+	if err != nil {
+		return false, err
+	}
+	choices := decodeArray(chunk["choices"])
+	out := choices[:0]
+	for _, choice := range choices {
+		index, _ := intValue(choice["index"])
+		if f.done[index] {
+			continue
+		}
+		delta := decodeMap(choice["delta"])
+		text := f.held[index] + stringValue(delta["content"], "")
+		finished := !isNullJSON(choice["finish_reason"])
+		if cut, ok := f.match(text); ok {
+			text, finished = text[:cut], true
+			f.done[index] = true
+			choice["finish_reason"] = rawJSON("stop")
+		}
+		if finished {
+			delete(f.held, index)
+		} else {
+			if keep := f.partial(text); keep > 0 {
+				f.held[index] = text[len(text)-keep:]
+				text = text[:len(text)-keep]
+			} else {
+				delete(f.held, index)
+			}
+		}
+		if _, ok := delta["content"]; ok || text != "" {
+			if delta == nil {
+				delta = map[string]json.RawMessage{}
+			}
+			delta["content"] = rawJSON(text)
+			choice["delta"] = mustMarshal(delta)
+		}
+		out = append(out, choice)
+	}
+	if len(out) == 0 && len(chunk["usage"]) == 0 {
+		return false, nil
+	}
+	chunk["choices"] = mustMarshal(out)
+	buf.Truncate(offset)
+	return true, encodeJSONMap(buf, chunk)
+}
+
+// finished reports whether every choice hit a stop sequence, so upstream
+// can be cut off.
+func (f *stopFilter) finished() bool {
+	return len(f.done) >= f.choices
+}
+
+// flush writes a chunk with content held back when upstream ended without
+// finish_reason, it returns false when nothing was held.
+func (f *stopFilter) flush(buf *bytes.Buffer, id string, model json.RawMessage) bool {
+	if len(f.held) == 0 {
+		return false
+	}
+	choices := make([]map[string]json.RawMessage, 0, len(f.held))
+	for index, text := range f.held {
+		choices = append(choices, map[string]json.RawMessage{
+			"index": rawJSON(index),
+			"delta": mustMarshal(map[string]string{"content": text}),
+		})
+	}
+	clear(f.held)
+	encodeJSONMap(buf, map[string]json.RawMessage{
+		"id":      rawJSON(id),
+		"object":  rawJSON("chat.completion.chunk"),
+		"created": rawJSON(time.Now().Unix()),
+		"model":   model,
+		"choices": mustMarshal(choices),
+	})
+	return true
+}
+
+// match returns where the first stop sequence starts in text.
+func (f *stopFilter) match(text string) (int, bool) {
+	cut := -1
+	for _, stop := range f.stops {
+		if i := strings.Index(text, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	return cut, cut >= 0
+}
+
+// partial returns the length of the longest text suffix which is a prefix of
+// a stop sequence.
+func (f *stopFilter) partial(text string) int {
+	longest := 0
+	for _, stop := range f.stops {
+		for n := min(len(stop)-1, len(text)); n > longest; n-- {
+			if strings.HasSuffix(text, stop[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
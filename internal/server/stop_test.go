@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestStopFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		stops  []string
+		chunks []string
+		want   string
+		stop   bool
+	}{
+		{"no match", []string{"END"}, []string{"hello ", "world"}, "hello world", false},
+		{"in one chunk", []string{"END"}, []string{"hello END world"}, "hello ", true},
+		{"split across chunks", []string{"END"}, []string{"hello E", "ND world"}, "hello ", true},
+		{"split over three chunks", []string{"<|end|>"}, []string{"a <|", "en", "d|> b"}, "a ", true},
+		{"held prefix released", []string{"END"}, []string{"hello EN", "ough"}, "hello ENough", false},
+		{"held at end of stream", []string{"END"}, []string{"hello EN"}, "hello EN", false},
+		{"at start of chunk", []string{"END"}, []string{"hello ", "END world"}, "hello ", true},
+		{"at start of stream", []string{"END"}, []string{"END world"}, "", true},
+		{"several stops, first in text wins", []string{"zz", "b"}, []string{"a b c zz"}, "a ", true},
+		{"several stops, split one wins", []string{"STOP", "###"}, []string{"x #", "## STOP"}, "x ", true},
+		{"several stops, overlapping prefixes", []string{"ab", "abc"}, []string{"x a", "bc"}, "x ", true},
+		{"content after stop dropped", []string{"END"}, []string{"aEND", "more", "text"}, "a", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newStopFilter(tt.stops, 1)
+			var got bytes.Buffer
+			stopped := false
+			for _, content := range tt.chunks {
+				buf := &bytes.Buffer{}
+				fmt.Fprintf(buf, `data: {"choices":[{"index":0,"delta":{"content":%s}}]}`, mustMarshal(content))
+				keep, err := f.chunk(buf, 6)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if keep {
+					stopped = stopContent(t, &got, buf.Bytes()[6:]) || stopped
+				}
+			}
+			buf := &bytes.Buffer{}
+			if f.flush(buf, "chatcmpl-test", rawJSON("glm-4.7")) {
+				stopContent(t, &got, buf.Bytes())
+			}
+			if got.String() != tt.want || stopped != tt.stop || f.finished() != tt.stop {
+				t.Fatalf("content = %q stop %v finished %v, want %q stop %v", got.String(), stopped, f.finished(), tt.want, tt.stop)
+			}
+		})
+	}
+}
+
+func TestStopFilterChoices(t *testing.T) {
+	f := newStopFilter([]string{"END"}, 2)
+	buf := &bytes.Buffer{}
+	buf.WriteString(`{"choices":[{"index":0,"delta":{"content":"aEN"}},{"index":1,"delta":{"content":"bEND"}}]}`)
+	if keep, err := f.chunk(buf, 0); !keep || err != nil {
+		t.Fatalf("chunk = %v, %v", keep, err)
+	}
+	if f.finished() {
+		t.Fatal("finished with choice 0 still open")
+	}
+	buf.Reset()
+	buf.WriteString(`{"choices":[{"index":0,"delta":{"content":"D"}},{"index":1,"delta":{"content":"more"}}]}`)
+	if keep, err := f.chunk(buf, 0); !keep || err != nil {
+		t.Fatalf("chunk = %v, %v", keep, err)
+	}
+	choices := decodeArray(decodeMap(buf.Bytes())["choices"])
+	if len(choices) != 1 || stringValue(choices[0]["finish_reason"], "") != "stop" || !f.finished() {
+		t.Fatalf("second chunk = %s, finished %v", buf, f.finished())
+	}
+}
+
+// stopContent appends content of the chunk to out and reports whether a
+// choice finished with "stop".
+func stopContent(t *testing.T, out *bytes.Buffer, data []byte) bool {
+	t.Helper()
+	chunk, err := decodeJSONBytes(data)
+	if err != nil {
+		t.Fatalf("decode %s: %v", data, err)
+	}
+	stop := false
+	for _, choice := range decodeArray(chunk["choices"]) {
+		out.WriteString(stringValue(decodeMap(choice["delta"])["content"], ""))
+		stop = stop || stringValue(choice["finish_reason"], "") == "stop"
+	}
+	return stop
+}
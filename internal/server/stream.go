@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+type events struct {
+	data <-chan []byte
+	err  error
+}
+
+func readEvents(body io.Reader, done <-chan struct{}) *events {
+	data := make(chan []byte)
+	e := &events{data: data}
+	go func() {
+		defer close(data)
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+		for scanner.Scan() {
+			payload, ok := eventPayload(scanner.Bytes())
+			if !ok {
+				continue
+			}
+			select {
+			case data <- bytes.Clone(payload):
+			case <-done:
+				return
+			}
+		}
+		e.err = scanner.Err()
+	}()
+	return e
+}
+
+// eventPayload returns the payload of an SSE "data:" line or of a line of
+// newline delimited JSON some compatible upstreams stream instead, other
+// SSE fields, comments and empty lines have none.
+func eventPayload(line []byte) ([]byte, bool) {
+	line = bytes.TrimSpace(line)
+	if payload, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+		return bytes.TrimSpace(payload), true
+	}
+	if bytes.HasPrefix(line, []byte("{")) || bytes.Equal(line, []byte("[DONE]")) {
+		return line, true
+	}
+	return nil, false
+}
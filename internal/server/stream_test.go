@@ -0,0 +1,124 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// pieceReader returns at most n bytes per read, so runes are split across
+// reads of the upstream body.
+type pieceReader struct {
+	data []byte
+	n    int
+}
+
+func (r *pieceReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.n)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestStreamKeepsMultibyteText(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		escape bool
+	}{
+		{"chinese", "你好，世界！这是一个测试。", false},
+		{"japanese", "こんにちは、世界", false},
+		{"korean", "안녕하세요 세계", false},
+		{"emoji", "👋🏽 hi 🇺🇦 ok 🎉", false},
+		{"zwj sequence", "family 👨‍👩‍👧‍👦 done", false},
+		{"mixed", "GLM 回答: ✅ 完成 🚀", false},
+		{"escaped", "你好 👋🏽 世界", true},
+	}
+	for _, tt := range tests {
+		for _, runes := range []int{1, 2, 3} {
+			for _, piece := range []int{1, 2, 3, 5} {
+				t.Run(fmt.Sprintf("%s/%d runes/%d bytes", tt.name, runes, piece), func(t *testing.T) {
+					body := streamBody(tt.text, runes, tt.escape)
+					for _, tr := range []*transform{nil, newTransform(nil, reasoningMerge)} {
+						got := streamText(t, &pieceReader{data: body, n: piece}, tr)
+						if got != tt.text {
+							t.Fatalf("text = %q, want %q", got, tt.text)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// streamBody sends text in SSE events of n runes each, escape sends non
+// ASCII runes as \u sequences with surrogate pairs.
+func streamBody(text string, n int, escape bool) []byte {
+	var body bytes.Buffer
+	runes := []rune(text)
+	for i := 0; i < len(runes); i += n {
+		content := mustMarshal(string(runes[i:min(i+n, len(runes))]))
+		if escape {
+			content = escapeUnicode(content)
+		}
+		fmt.Fprintf(&body, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%s}}]}\n\n", content)
+	}
+	body.WriteString("data: [DONE]\n\n")
+	return body.Bytes()
+}
+
+func escapeUnicode(raw []byte) []byte {
+	var out strings.Builder
+	for _, r := range string(raw) {
+		switch {
+		case r < 0x80:
+			out.WriteRune(r)
+		case r > 0xffff:
+			r -= 0x10000
+			fmt.Fprintf(&out, `\u%04x\u%04x`, 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+		default:
+			fmt.Fprintf(&out, `\u%04x`, r)
+		}
+	}
+	return []byte(out.String())
+}
+
+func streamText(t *testing.T, body io.Reader, tr *transform) string {
+	t.Helper()
+	done := make(chan struct{})
+	defer close(done)
+	upstream := readEvents(body, done)
+	var text strings.Builder
+	buf := &bytes.Buffer{}
+	for payload := range upstream.data {
+		if bytes.Equal(payload, []byte("[DONE]")) {
+			continue
+		}
+		buf.Reset()
+		if _, err := normalizeStreamChunk(buf, payload, rawJSON("glm-4.7"), "chatcmpl-test", tr); err != nil {
+			t.Fatalf("normalize %s: %v", payload, err)
+		}
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &chunk); err != nil {
+			t.Fatalf("decode %s: %v", buf.Bytes(), err)
+		}
+		for _, choice := range chunk.Choices {
+			text.WriteString(choice.Delta.Content)
+		}
+	}
+	if upstream.err != nil {
+		t.Fatal(upstream.err)
+	}
+	return text.String()
+}
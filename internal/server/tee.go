@@ -0,0 +1,27 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// openTee creates the file in --tee-dir that a stream is copied to as it is
+// relayed, named by start time (UTC), request ID and model. Errors are only
+// logged, the stream goes on without the copy.
+func (h *handler) openTee(r *http.Request, c *call) *os.File {
+	if h.teeDir == "" || c.dryRun {
+		return nil
+	}
+	name := fmt.Sprintf("%s_%s_%s.sse", c.start.UTC().Format("20060102T150405.000Z"), requestID(r.Context()), unsafeFileChars.ReplaceAllString(c.alias, "_"))
+	f, err := os.OpenFile(filepath.Join(h.teeDir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		logf(r.Context(), "stream tee: %v", err)
+		return nil
+	}
+	return f
+}
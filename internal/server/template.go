@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// contentPlaceholder is replaced with the user text in Template.User.
+const contentPlaceholder = "{{content}}"
+
+// Template is a persona served as its own model ID in /v1/models: requests
+// for it go to Model with System prepended, the last user message wrapped
+// in User and ResponseFormat set unless the request has one.
+type Template struct {
+	Model string
+	// System is prepended as a system message.
+	System string
+	// User wraps the last user text at {{content}}, without the placeholder
+	// the text follows User after a blank line.
+	User           string
+	ResponseFormat json.RawMessage
+}
+
+// templateAliases adds templates to aliases so they are resolved, listed
+// and answered under their own name.
+func templateAliases(aliases map[string]string, templates map[string]Template, models map[string]GLMConfig) (map[string]string, error) {
+	if len(templates) == 0 {
+		return aliases, nil
+	}
+	aliases = maps.Clone(aliases)
+	if aliases == nil {
+		aliases = map[string]string{}
+	}
+	for name, t := range templates {
+		if _, ok := models[t.Model]; !ok {
+			return nil, fmt.Errorf("template %s model tag must be one of %v", name, slices.Sorted(maps.Keys(models)))
+		}
+		if len(t.ResponseFormat) != 0 && !json.Valid(t.ResponseFormat) {
+			return nil, fmt.Errorf("template %s response_format is not valid JSON", name)
+		}
+		aliases[name] = t.Model
+	}
+	return aliases, nil
+}
+
+// template applies the template of the requested model to the payload.
+func (rt *routes) template(payload map[string]json.RawMessage) {
+	t, ok := rt.templates[stringValue(payload["model"], "")]
+	if !ok {
+		return
+	}
+	if len(t.ResponseFormat) != 0 && isNullJSON(payload["response_format"]) {
+		payload["response_format"] = t.ResponseFormat
+	}
+	if t.System == "" && t.User == "" {
+		return
+	}
+	messages := decodeArray(payload["messages"])
+	if t.User != "" {
+		for i := len(messages) - 1; i >= 0; i-- {
+			if stringValue(messages[i]["role"], "") == "user" {
+				messages[i]["content"] = wrapContent(messages[i]["content"], t.User)
+				break
+			}
+		}
+	}
+	if t.System != "" {
+		messages = append([]map[string]json.RawMessage{systemMessage(t.System)}, messages...)
+	}
+	payload["messages"] = chatMessages(messages)
+}
+
+// wrapContent wraps string content or every text part of content parts.
+func wrapContent(content json.RawMessage, wrapper string) json.RawMessage {
+	wrap := func(text string) string {
+		if strings.Contains(wrapper, contentPlaceholder) {
+			return strings.ReplaceAll(wrapper, contentPlaceholder, text)
+		}
+		return wrapper + "\n\n" + text
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return rawJSON(wrap(text))
+	}
+	parts := decodeArray(content)
+	if parts == nil {
+		return content
+	}
+	for _, part := range parts {
+		if stringValue(part["type"], "") == "text" {
+			part["text"] = rawJSON(wrap(stringValue(part["text"], "")))
+		}
+	}
+	return mustMarshal(parts)
+}
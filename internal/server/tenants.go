@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"freeglm/internal/storage"
+)
+
+// Tenant is a logical proxy served by the same process, selected by Host
+// header or path prefix. Keys replace the shared keys, providers and
+// aliases extend the global ones, zero quotas are unlimited.
+type Tenant struct {
+	Hosts       []string
+	Prefix      string
+	Keys        []string
+	Providers   []Provider
+	Aliases     map[string]string
+	DailyTokens int
+	RPM         int
+}
+
+type tenantRoutes struct {
+	name   string
+	hosts  []string
+	prefix string
+	daily  int
+	rpm    int
+	routes *routes
+}
+
+type tenantContext struct{}
+
+func newTenants(opts Options, store storage.Storage) ([]*tenantRoutes, error) {
+	var tenants []*tenantRoutes
+	seen := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(opts.Tenants)) {
+		t := opts.Tenants[name]
+		if len(t.Hosts) == 0 && t.Prefix == "" {
+			return nil, fmt.Errorf("tenant %s needs hosts or prefix", name)
+		}
+		if t.Prefix != "" && (!strings.HasPrefix(t.Prefix, "/") || strings.HasSuffix(t.Prefix, "/")) {
+			return nil, fmt.Errorf("tenant %s prefix must start and not end with /", name)
+		}
+		if t.DailyTokens < 0 || t.RPM < 0 {
+			return nil, fmt.Errorf("tenant %s daily tokens and rpm must not be negative", name)
+		}
+		// hosts and prefixes are matched case-insensitively
+		prefix := strings.ToLower(t.Prefix)
+		hosts := make([]string, 0, len(t.Hosts))
+		for _, host := range append(slices.Clone(t.Hosts), prefix) {
+			if host == "" {
+				continue
+			}
+			host = strings.ToLower(host)
+			if other, ok := seen[host]; ok {
+				return nil, fmt.Errorf("tenant %s and %s share %s", other, name, host)
+			}
+			seen[host] = name
+			if host != prefix {
+				hosts = append(hosts, host)
+			}
+		}
+
+		tenantOpts := opts
+		tenantOpts.Tenants = nil
+		if len(t.Keys) != 0 {
+			tenantOpts.Keys = t.Keys
+		}
+		tenantOpts.Providers = slices.Concat(opts.Providers, t.Providers)
+		tenantOpts.Aliases = maps.Clone(opts.Aliases)
+		if tenantOpts.Aliases == nil {
+			tenantOpts.Aliases = map[string]string{}
+		}
+		maps.Copy(tenantOpts.Aliases, t.Aliases)
+		_routes, err := newRoutes(tenantOpts, store)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", name, err)
+		}
+		tenants = append(tenants, &tenantRoutes{
+			name:   name,
+			hosts:  hosts,
+			prefix: prefix,
+			daily:  t.DailyTokens,
+			rpm:    t.RPM,
+			routes: _routes,
+		})
+	}
+	return tenants, nil
+}
+
+// tenant picks the tenant by Host header first and path prefix second, the
+// prefix is cut from the path so /team-a/v1/models is served as /v1/models.
+func (h *handler) tenant(r *http.Request) (*http.Request, *tenantRoutes) {
+	tenants := h.routes.Load().tenants
+	if len(tenants) == 0 {
+		return r, nil
+	}
+	host := strings.ToLower(r.Host)
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	for _, t := range tenants {
+		if slices.Contains(t.hosts, host) {
+			return r.WithContext(context.WithValue(r.Context(), tenantContext{}, t)), t
+		}
+	}
+	for _, t := range tenants {
+		if !hasPrefixFold(r.URL.Path, t.prefix) {
+			continue
+		}
+		r = r.WithContext(context.WithValue(r.Context(), tenantContext{}, t))
+		u := *r.URL
+		u.Path = "/" + strings.TrimLeft(u.Path[len(t.prefix):], "/")
+		u.RawPath = ""
+		r.URL = &u
+		return r, t
+	}
+	return r, nil
+}
+
+// hasPrefixFold tells whether path is the prefix or under it, ignoring case.
+func hasPrefixFold(path, prefix string) bool {
+	if prefix == "" || len(path) < len(prefix) || !strings.EqualFold(path[:len(prefix)], prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || path[len(prefix)] == '/'
+}
+
+// routesFor returns routes of the request tenant or the global routes.
+func (h *handler) routesFor(ctx context.Context) *routes {
+	if t, ok := ctx.Value(tenantContext{}).(*tenantRoutes); ok {
+		return t.routes
+	}
+	return h.routes.Load()
+}
+
+func tenantName(ctx context.Context) string {
+	if t, ok := ctx.Value(tenantContext{}).(*tenantRoutes); ok {
+		return t.name
+	}
+	return ""
+}
+
+func (h *handler) admitTenant(w http.ResponseWriter, t *tenantRoutes) bool {
+	if t == nil {
+		return true
+	}
+	code, msg, retry := h.tenantQuotas.admit(t.name, t.daily, t.rpm)
+	if code == "" {
+		return true
+	}
+	h.sendLimitError(w, code, msg, retry)
+	return false
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"freeglm/internal/threads"
+)
+
+func isThreadsPath(path string) bool {
+	return path == "/v1/threads" || strings.HasPrefix(path, "/v1/threads/")
+}
+
+func (h *handler) handleThreads(w http.ResponseWriter, r *http.Request) {
+	if h.threads == nil {
+		h.sendErrorJSON(w, http.StatusNotFound, "Threads are disabled, run server with --threads-db or --storage")
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/v1/threads"), "/"), "/")
+	if parts[0] != "" {
+		if t, err := h.threads.Get(parts[0]); err == nil && t.Owner != virtualKeyName(r.Context()) {
+			// threads of other virtual keys do not exist for the client
+			h.sendThreadJSON(w, nil, threads.ErrNotFound)
+			return
+		}
+	}
+	switch {
+	case parts[0] == "" && r.Method == http.MethodPost:
+		h.createThread(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		t, err := h.threads.Get(parts[0])
+		h.sendThreadJSON(w, t, err)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		err := h.threads.Delete(parts[0])
+		h.sendThreadJSON(w, map[string]any{"id": parts[0], "object": "thread.deleted", "deleted": true}, err)
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodGet:
+		messages, err := h.threads.Messages(parts[0])
+		if messages == nil {
+			messages = []threads.Message{}
+		}
+		h.sendThreadJSON(w, map[string]any{"object": "list", "data": messages}, err)
+	case len(parts) == 2 && parts[1] == "messages" && r.Method == http.MethodPost:
+		h.appendMessage(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "runs" && r.Method == http.MethodPost:
+		h.runThread(w, r, parts[0])
+	default:
+		h.sendErrorJSON(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (h *handler) sendThreadJSON(w http.ResponseWriter, data any, err error) {
+	switch {
+	case errors.Is(err, threads.ErrNotFound):
+		h.sendErrorJSON(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		h.sendErrorJSON(w, http.StatusInternalServerError, fmt.Sprintf("Threads error: %v", err))
+	default:
+		h.sendJSON(w, http.StatusOK, data)
+	}
+}
+
+func (h *handler) createThread(w http.ResponseWriter, r *http.Request) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	messages, err := threadMessages(payload["messages"])
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var metadata map[string]string
+	if raw := payload["metadata"]; !isNullJSON(raw) {
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			h.sendErrorJSON(w, http.StatusBadRequest, "metadata must be an object of strings")
+			return
+		}
+	}
+	t, err := h.threads.Create(virtualKeyName(r.Context()), metadata, messages...)
+	h.sendThreadJSON(w, t, err)
+}
+
+func (h *handler) appendMessage(w http.ResponseWriter, r *http.Request, id string) {
+	body, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	messages, err := threadMessages(mustMarshal([]map[string]json.RawMessage{body}))
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, strings.TrimPrefix(err.Error(), "messages[0]."))
+		return
+	}
+	err = h.threads.Append(id, messages...)
+	h.sendThreadJSON(w, messages[0], err)
+}
+
+// runThread appends messages from the request to the thread, runs the chat
+// completion over the whole history and stores the assistant reply.
+func (h *handler) runThread(w http.ResponseWriter, r *http.Request, id string) {
+	payload, ok := h.decodeBody(w, r)
+	if !ok {
+		return
+	}
+	messages, err := threadMessages(payload["messages"])
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.threads.Append(id, messages...); err != nil {
+		h.sendThreadJSON(w, nil, err)
+		return
+	}
+	history, err := h.threads.Messages(id)
+	if err != nil {
+		h.sendThreadJSON(w, nil, err)
+		return
+	}
+	chat := make([]map[string]json.RawMessage, 0, len(history))
+	for _, m := range history {
+		msg := map[string]json.RawMessage{
+			"role":       rawJSON(m.Role),
+			"content":    m.Content,
+			"tool_calls": m.ToolCalls,
+		}
+		if m.ToolCallID != "" {
+			msg["tool_call_id"] = rawJSON(m.ToolCallID)
+		}
+		if m.Name != "" {
+			msg["name"] = rawJSON(m.Name)
+		}
+		chat = append(chat, msg)
+	}
+	payload["messages"] = chatMessages(chat)
+
+	capture := &captureWriter{ResponseWriter: w}
+	h.forward(capture, r, payload, nil)
+	if reply, ok := capture.reply(); ok {
+		if err := h.threads.Append(id, reply); err != nil {
+			logf(r.Context(), "threads append error: %v", err)
+		}
+	}
+}
+
+func chatMessages(messages []map[string]json.RawMessage) json.RawMessage {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	buf.WriteByte('[')
+	for i, msg := range messages {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		encodeJSONMap(buf, msg)
+	}
+	buf.WriteByte(']')
+	return bytes.Clone(buf.Bytes())
+}
+
+func threadMessages(raw json.RawMessage) ([]threads.Message, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+	if err := validateMessages(raw); err != nil {
+		return nil, err
+	}
+	var messages []threads.Message
+	if err := json.Unmarshal(raw, &messages); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// captureWriter keeps the assistant reply passing through to the client.
+type captureWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	pending []byte
+	content strings.Builder
+	stream  bool
+}
+
+func (c *captureWriter) WriteHeader(status int) {
+	c.status = status
+	c.stream = strings.HasPrefix(c.Header().Get("Content-Type"), "text/event-stream")
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.status == http.StatusOK {
+		if c.stream {
+			c.scan(p)
+		} else {
+			c.body.Write(p)
+		}
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *captureWriter) Flush() {
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (c *captureWriter) scan(p []byte) {
+	c.pending = append(c.pending, p...)
+	for {
+		idx := bytes.IndexByte(c.pending, '\n')
+		if idx < 0 {
+			return
+		}
+		line := bytes.TrimSpace(c.pending[:idx])
+		c.pending = c.pending[idx+1:]
+		payload, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		chunk, err := decodeJSONBytes(bytes.TrimSpace(payload))
+		if err != nil {
+			continue
+		}
+		choices := decodeArray(chunk["choices"])
+		if len(choices) == 0 {
+			continue
+		}
+		var delta struct {
+			Content string `json:"content"`
+		}
+		json.Unmarshal(choices[0]["delta"], &delta)
+		c.content.WriteString(delta.Content)
+	}
+}
+
+func (c *captureWriter) reply() (threads.Message, bool) {
+	if c.status != http.StatusOK {
+		return threads.Message{}, false
+	}
+	if c.stream {
+		return threads.Message{Role: "assistant", Content: rawJSON(c.content.String())}, true
+	}
+	resp, err := decodeJSONBytes(c.body.Bytes())
+	if err != nil {
+		return threads.Message{}, false
+	}
+	choices := decodeArray(resp["choices"])
+	if len(choices) == 0 {
+		return threads.Message{}, false
+	}
+	var m threads.Message
+	if err := json.Unmarshal(choices[0]["message"], &m); err != nil {
+		return threads.Message{}, false
+	}
+	m.Role = "assistant"
+	if len(m.Content) == 0 {
+		m.Content = json.RawMessage("null")
+	}
+	return m, true
+}
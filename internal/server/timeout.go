@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const timeoutHeader = "X-Freeglm-Timeout"
+
+// timeoutPolicy is the total timeout of chat requests and how long clients
+// may ask for, zero max is total so clients may only shorten it.
+type timeoutPolicy struct {
+	total time.Duration
+	max   time.Duration
+}
+
+func (p timeoutPolicy) limit() time.Duration {
+	if p.max > 0 {
+		return p.max
+	}
+	return p.total
+}
+
+// requestTimeout returns the total timeout of the request: seconds or a
+// duration like 30s from X-Freeglm-Timeout header or "timeout" payload
+// field, a number or a string which is not sent upstream, or --timeout by
+// default. Zero is no timeout.
+func (h *handler) requestTimeout(r *http.Request, payload map[string]json.RawMessage) (time.Duration, error) {
+	raw, ok := payload["timeout"]
+	delete(payload, "timeout")
+	value, name := r.Header.Get(timeoutHeader), timeoutHeader
+	if value == "" {
+		if !ok || isNullJSON(raw) {
+			return h.timeouts.total, nil
+		}
+		value, name = stringValue(raw, string(raw)), "timeout"
+	}
+	timeout, err := parseTimeout(value)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("%s must be a positive number of seconds or a duration", name)
+	}
+	if limit := h.timeouts.limit(); limit > 0 && timeout > limit {
+		return 0, fmt.Errorf("%s must not exceed %d seconds", name, int(limit.Seconds()))
+	}
+	return timeout, nil
+}
+
+func parseTimeout(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(value)
+}
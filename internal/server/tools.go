@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var toolName = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// translateTools validates tools and tool_choice. GLM only knows "auto",
+// so for glm a function choice keeps only that tool and "required" is
+// rejected, other upstreams get tool_choice as sent.
+func translateTools(payload map[string]json.RawMessage, glm bool) error {
+	if raw, ok := payload["functions"]; ok {
+		if _, exists := payload["tools"]; !exists && !isNullJSON(raw) {
+			functions := decodeArray(raw)
+			if functions == nil {
+				return fmt.Errorf("functions must be an array of objects")
+			}
+			tools := make([]map[string]json.RawMessage, 0, len(functions))
+			for _, fn := range functions {
+				tools = append(tools, map[string]json.RawMessage{
+					"type":     rawJSON("function"),
+					"function": mustMarshal(fn),
+				})
+			}
+			payload["tools"] = mustMarshal(tools)
+		}
+		delete(payload, "functions")
+	}
+	if raw, ok := payload["function_call"]; ok {
+		if _, exists := payload["tool_choice"]; !exists && !isNullJSON(raw) {
+			if fn := decodeMap(raw); fn != nil {
+				payload["tool_choice"] = mustMarshal(map[string]any{
+					"type":     "function",
+					"function": fn,
+				})
+			} else {
+				payload["tool_choice"] = raw
+			}
+		}
+		delete(payload, "function_call")
+	}
+
+	raw, ok := payload["tools"]
+	if !ok || isNullJSON(raw) {
+		delete(payload, "tools")
+		delete(payload, "tool_choice")
+		return nil
+	}
+	tools := decodeArray(raw)
+	if tools == nil {
+		return fmt.Errorf("tools must be an array of objects")
+	}
+	names := map[string]bool{}
+	for idx, tool := range tools {
+		kind := stringValue(tool["type"], "function")
+		tool["type"] = rawJSON(kind)
+		if kind != "function" {
+			continue
+		}
+		fn := decodeMap(tool["function"])
+		if fn == nil {
+			return fmt.Errorf("tools[%d].function must be an object", idx)
+		}
+		name := stringValue(fn["name"], "")
+		if !toolName.MatchString(name) {
+			return fmt.Errorf("tools[%d].function.name must match %s", idx, toolName)
+		}
+		if names[name] {
+			return fmt.Errorf("tools[%d].function.name %s is duplicated", idx, name)
+		}
+		names[name] = true
+		if params, ok := fn["parameters"]; ok && !isNullJSON(params) {
+			schema := decodeMap(params)
+			if schema == nil {
+				return fmt.Errorf("tools[%d].function.parameters must be a JSON schema object", idx)
+			}
+			if kind := stringValue(schema["type"], "object"); kind != "object" {
+				return fmt.Errorf("tools[%d].function.parameters type must be object", idx)
+			}
+		}
+	}
+
+	switch choice := payload["tool_choice"]; {
+	case isNullJSON(choice):
+	case stringValue(choice, "") == "none":
+		delete(payload, "tools")
+		delete(payload, "tool_choice")
+		return nil
+	case stringValue(choice, "") == "auto":
+	case stringValue(choice, "") == "required":
+		if glm {
+			return fmt.Errorf("tool_choice required is not supported by %s, use auto or a function object", stringValue(payload["model"], "GLM"))
+		}
+	default:
+		fn := decodeMap(decodeMap(choice)["function"])
+		name := stringValue(fn["name"], "")
+		if name == "" {
+			return fmt.Errorf("tool_choice must be none, auto, required or a function object")
+		}
+		if !names[name] {
+			return fmt.Errorf("tool_choice function %s is not in tools", name)
+		}
+		if !glm {
+			break
+		}
+		forced := tools[:0]
+		for _, tool := range tools {
+			if stringValue(decodeMap(tool["function"])["name"], "") == name {
+				forced = append(forced, tool)
+			}
+		}
+		tools = forced
+	}
+	payload["tools"] = mustMarshal(tools)
+	if glm {
+		payload["tool_choice"] = rawJSON("auto")
+	}
+	return nil
+}
+
+func normalizeToolCalls(raw json.RawMessage, stream bool) json.RawMessage {
+	calls := decodeArray(raw)
+	if calls == nil {
+		return raw
+	}
+	for idx, call := range calls {
+		if _, ok := call["index"]; !ok && stream {
+			call["index"] = rawJSON(idx)
+		}
+		id := stringValue(call["id"], "")
+		if id == "" && !stream {
+			id = randomID("call_", 24)
+			call["id"] = rawJSON(id)
+		}
+		if _, ok := call["type"]; !ok && id != "" {
+			call["type"] = rawJSON("function")
+		}
+		fn := decodeMap(call["function"])
+		if fn == nil {
+			continue
+		}
+		if args, ok := fn["arguments"]; ok {
+			switch {
+			case isNullJSON(args) && !stream:
+				fn["arguments"] = rawJSON("{}")
+			case !isNullJSON(args) && bytes.TrimSpace(args)[0] != '"':
+				fn["arguments"] = rawJSON(string(bytes.TrimSpace(args)))
+			}
+		} else if !stream {
+			fn["arguments"] = rawJSON("{}")
+		}
+		call["function"] = mustMarshal(fn)
+	}
+	return mustMarshal(calls)
+}
+
+func repairToolArguments(raw json.RawMessage) json.RawMessage {
+	calls := decodeArray(raw)
+	if calls == nil {
+		return raw
+	}
+	for _, call := range calls {
+		fn := decodeMap(call["function"])
+		if fn == nil {
+			continue
+		}
+		args := stringValue(fn["arguments"], "")
+		if args == "" || json.Valid([]byte(args)) {
+			continue
+		}
+		start, end := bytes.IndexByte([]byte(args), '{'), bytes.LastIndexByte([]byte(args), '}')
+		if start >= 0 && end > start && json.Valid([]byte(args[start:end+1])) {
+			fn["arguments"] = rawJSON(args[start : end+1])
+		} else {
+			fn["arguments"] = rawJSON("{}")
+		}
+		call["function"] = mustMarshal(fn)
+	}
+	return mustMarshal(calls)
+}
+
+// toolStream is the tool call state of one streamed choice.
+type toolStream struct {
+	byID    map[string]int
+	byIndex map[int]int
+	calls   []toolCallState
+	current int
+}
+
+type toolCallState struct {
+	id      string
+	name    string
+	args    string
+	started bool
+	named   bool
+}
+
+// toolDeltas re-emits streamed tool calls as OpenAI deltas: the first delta
+// of a call has its id, type and name, indexes count calls from 0 in order
+// of appearance and the following deltas carry only argument fragments.
+// Upstream fragments without index or id continue the current call.
+func (t *transform) toolDeltas(choice int, msg map[string]json.RawMessage) {
+	if t == nil {
+		return
+	}
+	raw, ok := msg["tool_calls"]
+	if !ok || isNullJSON(raw) {
+		return
+	}
+	calls := decodeArray(raw)
+	if calls == nil {
+		return
+	}
+	s := t.tools[choice]
+	if s == nil {
+		s = &toolStream{byID: map[string]int{}, byIndex: map[int]int{}, current: -1}
+		t.tools[choice] = s
+	}
+	out := make([]map[string]any, 0, len(calls))
+	for _, call := range calls {
+		fn := decodeMap(call["function"])
+		id := stringValue(call["id"], "")
+		index, hasIndex := intValue(call["index"])
+		name := stringValue(fn["name"], "")
+		args := argumentsText(fn["arguments"])
+
+		our, known := -1, false
+		switch {
+		case id != "":
+			our, known = s.byID[id]
+		case hasIndex:
+			our, known = s.byIndex[index]
+		case s.current >= 0 && (name == "" || !s.calls[s.current].named):
+			our, known = s.current, true
+		}
+		if !known {
+			if id == "" {
+				id = randomID("call_", 24)
+			}
+			our = len(s.calls)
+			s.byID[id] = our
+			s.calls = append(s.calls, toolCallState{id: id})
+		}
+		if hasIndex {
+			s.byIndex[index] = our
+		}
+		s.current = our
+
+		st := &s.calls[our]
+		// some upstreams repeat the whole arguments in the last chunk
+		if st.args != "" && json.Valid([]byte(args)) {
+			if strings.HasPrefix(args, st.args) {
+				args = args[len(st.args):]
+			} else if compactJSON(args) == compactJSON(st.args) {
+				args = ""
+			}
+		}
+		st.args += args
+		delta := map[string]any{"index": our}
+		function := map[string]any{"arguments": args}
+		if !st.started {
+			delta["id"], delta["type"] = st.id, "function"
+			st.started = true
+		}
+		if name != "" && !st.named {
+			function["name"] = name
+			st.name, st.named = name, true
+		}
+		delta["function"] = function
+		out = append(out, delta)
+	}
+	msg["tool_calls"] = mustMarshal(out)
+}
+
+// argumentsText returns arguments string, objects sent by upstream instead
+// of a JSON string are compacted into one.
+func argumentsText(raw json.RawMessage) string {
+	raw = bytes.TrimSpace(raw)
+	if isNullJSON(raw) {
+		return ""
+	}
+	if raw[0] == '"' {
+		return stringValue(raw, "")
+	}
+	return compactJSON(string(raw))
+}
+
+func compactJSON(text string) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, []byte(text)) != nil {
+		return text
+	}
+	return buf.String()
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStreamToolArgumentsSplitAcrossChunks(t *testing.T) {
+	profile := profiles["opencode"]
+	tr := newTransform(&profile, reasoningPassthrough)
+	chunks := []string{
+		`[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"read","arguments":"{\"path\":"}}]}}]`,
+		`[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"a.go\"}"}}]}}]`,
+		`[{"index":0,"delta":{},"finish_reason":"tool_calls"}]`,
+	}
+	args := ""
+	for _, chunk := range chunks {
+		choices := decodeArray(normalizeStreamChoices(json.RawMessage(chunk), tr))
+		for _, call := range decodeArray(decodeMap(choices[0]["delta"])["tool_calls"]) {
+			args += stringValue(decodeMap(call["function"])["arguments"], "")
+		}
+	}
+	if args != `{"path":"a.go"}` {
+		t.Fatalf("assembled arguments = %q", args)
+	}
+}
+
+func TestToolArgumentsRepairedInMessage(t *testing.T) {
+	profile := profiles["opencode"]
+	tr := newTransform(&profile, reasoningPassthrough)
+	raw := `[{"index":0,"message":{"role":"assistant","tool_calls":[{"id":"call_1","type":"function","function":{"name":"read","arguments":"args: {\"path\":\"a.go\"}"}}]},"finish_reason":"tool_calls"}]`
+	choices := decodeArray(normalizeChoices(json.RawMessage(raw), tr))
+	calls := decodeArray(decodeMap(choices[0]["message"])["tool_calls"])
+	if args := stringValue(decodeMap(calls[0]["function"])["arguments"], ""); args != `{"path":"a.go"}` {
+		t.Fatalf("repaired arguments = %q", args)
+	}
+}
+
+func TestToolDeltasReassembled(t *testing.T) {
+	type call struct{ id, name, args string }
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []call
+	}{
+		{
+			"no index or id",
+			[]string{
+				`[{"function":{"name":"read","arguments":"{\"path\":"}}]`,
+				`[{"function":{"arguments":"\"a.go\"}"}}]`,
+				`[{"function":{"name":"write","arguments":"{}"}}]`,
+				`[{"function":{"arguments":""}}]`,
+			},
+			[]call{{"", "read", `{"path":"a.go"}`}, {"", "write", `{}`}},
+		},
+		{
+			"id only on first fragment",
+			[]string{
+				`[{"id":"call_1","function":{"name":"read","arguments":"{\"path\""}}]`,
+				`[{"function":{"arguments":":\"a.go\"}"}}]`,
+			},
+			[]call{{"call_1", "read", `{"path":"a.go"}`}},
+		},
+		{
+			"index reused with new id",
+			[]string{
+				`[{"index":0,"id":"call_1","function":{"name":"read","arguments":"{\"path\":\"a.go\"}"}}]`,
+				`[{"index":0,"id":"call_2","function":{"name":"read","arguments":"{\"path\":"}}]`,
+				`[{"index":0,"function":{"arguments":"\"b.go\"}"}}]`,
+			},
+			[]call{{"call_1", "read", `{"path":"a.go"}`}, {"call_2", "read", `{"path":"b.go"}`}},
+		},
+		{
+			"indexes not from 0",
+			[]string{
+				`[{"index":3,"id":"call_1","function":{"name":"read","arguments":""}},{"index":5,"id":"call_2","function":{"name":"ls","arguments":""}}]`,
+				`[{"index":5,"function":{"arguments":"{}"}},{"index":3,"function":{"arguments":"{}"}}]`,
+			},
+			[]call{{"call_1", "read", `{}`}, {"call_2", "ls", `{}`}},
+		},
+		{
+			"final chunk repeats whole arguments",
+			[]string{
+				`[{"index":0,"id":"call_1","function":{"name":"read","arguments":"{\"path\":"}}]`,
+				`[{"index":0,"function":{"arguments":"\"a.go\"}"}}]`,
+				`[{"index":0,"function":{"arguments":"{\"path\":\"a.go\"}"}}]`,
+			},
+			[]call{{"call_1", "read", `{"path":"a.go"}`}},
+		},
+		{
+			"final chunk repeats reformatted arguments",
+			[]string{
+				`[{"index":0,"id":"call_1","function":{"name":"read","arguments":"{\"path\": "}}]`,
+				`[{"index":0,"function":{"arguments":"\"a.go\"}"}}]`,
+				`[{"index":0,"function":{"arguments":"{\"path\":\"a.go\"}"}}]`,
+			},
+			[]call{{"call_1", "read", `{"path": "a.go"}`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := newTransform(nil, reasoningPassthrough)
+			var got []call
+			for _, chunk := range tt.chunks {
+				msg := map[string]json.RawMessage{"tool_calls": json.RawMessage(chunk)}
+				tr.toolDeltas(0, msg)
+				for _, delta := range decodeArray(msg["tool_calls"]) {
+					index, _ := intValue(delta["index"])
+					fn := decodeMap(delta["function"])
+					id, hasID := delta["id"]
+					switch {
+					case index == len(got):
+						if !hasID || stringValue(delta["type"], "") != "function" {
+							t.Fatalf("first delta of call %d = %v", index, delta)
+						}
+						got = append(got, call{id: stringValue(id, "")})
+					case index > len(got):
+						t.Fatalf("delta index %d skips calls, %d so far", index, len(got))
+					case hasID:
+						t.Fatalf("later delta of call %d repeats id: %v", index, delta)
+					}
+					if name := stringValue(fn["name"], ""); name != "" {
+						if got[index].name != "" {
+							t.Fatalf("call %d named twice", index)
+						}
+						got[index].name = name
+					}
+					got[index].args += stringValue(fn["arguments"], "")
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("calls = %+v, want %+v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].id == "" || want.id != "" && got[i].id != want.id {
+					t.Fatalf("call %d id = %q, want %q", i, got[i].id, want.id)
+				}
+				if got[i].name != want.name || got[i].args != want.args {
+					t.Fatalf("call %d = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
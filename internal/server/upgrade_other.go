@@ -0,0 +1,24 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+func listenConfig(reusePort bool) *net.ListenConfig {
+	if !reusePort {
+		return &net.ListenConfig{}
+	}
+	return &net.ListenConfig{Control: func(network, address string, conn syscall.RawConn) error {
+		return fmt.Errorf("reuse port: %w", errors.ErrUnsupported)
+	}}
+}
+
+// Upgrade is only supported on unix.
+func (s *Server) Upgrade() (int, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,96 @@
+//go:build unix
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// upgradeWait is how long a new process may take to listen.
+const upgradeWait = 30 * time.Second
+
+// listenConfig sets SO_REUSEPORT with reusePort, so a new freeglm can bind
+// the address while the old one still serves.
+func listenConfig(reusePort bool) *net.ListenConfig {
+	if !reusePort {
+		return &net.ListenConfig{}
+	}
+	return &net.ListenConfig{Control: func(network, address string, conn syscall.RawConn) error {
+		var sockErr error
+		err := conn.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+		})
+		return errors.Join(err, sockErr)
+	}}
+}
+
+// Upgrade starts the executable again with the same arguments and the
+// listener passed as socket activated fd, and returns the PID of the new
+// process once it listens. Connections keep queueing on the shared socket,
+// so the caller shuts this server down to finish active streams while the
+// new process accepts new ones. A new process failing to start is killed and this one
+// keeps serving.
+func (s *Server) Upgrade() (int, error) {
+	s.mu.Lock()
+	ln := s.listener
+	s.mu.Unlock()
+	if ln == nil {
+		return 0, errors.New("server is not listening")
+	}
+	filer, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return 0, fmt.Errorf("can not pass %T to new process", ln)
+	}
+	f, err := filer.File()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	// fd 3 is the listener, fd 4 the ready pipe
+	cmd.ExtraFiles = []*os.File{f, w}
+	cmd.Env = append(slices.DeleteFunc(os.Environ(), func(env string) bool {
+		return strings.HasPrefix(env, "LISTEN_") || strings.HasPrefix(env, readyFdEnv+"=")
+	}), "LISTEN_FDS=1", readyFdEnv+"="+strconv.Itoa(listenFdsStart+1))
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return 0, err
+	}
+	r.SetReadDeadline(time.Now().Add(upgradeWait))
+	if _, err := r.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		if exit := cmd.Wait(); errors.Is(err, io.EOF) && exit != nil {
+			err = exit
+		}
+		return 0, fmt.Errorf("new process did not start: %w", err)
+	}
+	// the socket file belongs to the new process now
+	if ul, ok := ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const (
+	// uploadProvider uploads to /files of the provider of the model.
+	uploadProvider = "provider"
+	uploadPurpose  = "vision"
+	uploadTimeout  = 60 * time.Second
+	// uploadTTL is how long the reference of an uploaded image is reused,
+	// clients send the same images again with every turn.
+	uploadTTL = 24 * time.Hour
+)
+
+var (
+	errImageTooLarge = errors.New("image is too large")
+	errUpload        = errors.New("image upload failed")
+)
+
+// imageUpload moves base64 data URLs of image parts over a size to an
+// upload endpoint, as inline images blow past upstream body limits.
+type imageUpload struct {
+	target string
+	over   int
+	max    int
+}
+
+func newImageUpload(target string, overKiB, maxMiB int) (*imageUpload, error) {
+	if target == "" {
+		return nil, nil
+	}
+	if overKiB < 0 || maxMiB < 0 {
+		return nil, errors.New("image upload sizes must not be negative")
+	}
+	if target != uploadProvider && !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return nil, fmt.Errorf("image upload must be %q or an http(s) URL", uploadProvider)
+	}
+	return &imageUpload{target: target, over: overKiB << 10, max: maxMiB << 20}, nil
+}
+
+// uploadImages replaces large data URLs of image parts with references
+// returned by the upload endpoint and returns how many it replaced.
+func (h *handler) uploadImages(ctx context.Context, payload map[string]json.RawMessage, c *call) (int, error) {
+	if h.upload == nil {
+		return 0, nil
+	}
+	messages := decodeArray(payload["messages"])
+	uploaded := 0
+	for idx, msg := range messages {
+		parts := decodeArray(msg["content"])
+		changed := false
+		for i, part := range parts {
+			if stringValue(part["type"], "") != "image_url" {
+				continue
+			}
+			url := mediaURL(part["image_url"])
+			if !strings.HasPrefix(url, "data:") || len(url) <= h.upload.over {
+				continue
+			}
+			ref, err := h.uploadImage(ctx, url, c)
+			if err != nil {
+				return uploaded, fmt.Errorf("messages[%d].content[%d].image_url: %w", idx, i, err)
+			}
+			part["image_url"] = mustMarshal(map[string]string{"url": ref})
+			changed = true
+			uploaded++
+		}
+		if changed {
+			msg["content"] = mustMarshal(parts)
+		}
+	}
+	if uploaded > 0 {
+		payload["messages"] = mustMarshal(messages)
+	}
+	return uploaded, nil
+}
+
+// uploadImage uploads the image of a base64 data URL once and returns its
+// reference, the "url" of the answer or its "id" for file APIs which take
+// IDs as image URLs.
+func (h *handler) uploadImage(ctx context.Context, url string, c *call) (string, error) {
+	header, encoded, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	mediaType, params, _ := strings.Cut(header, ";")
+	if !ok || !strings.Contains(params, "base64") {
+		return url, nil
+	}
+	if h.upload.max > 0 && base64.StdEncoding.DecodedLen(len(encoded)) > h.upload.max {
+		return "", fmt.Errorf("%w, %d MiB at most", errImageTooLarge, h.upload.max>>20)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("invalid base64: %v", err)
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", fmt.Errorf("%w %q, not an image", errUnsupportedMedia, mediaType)
+	}
+
+	sum := sha256.Sum256(data)
+	key := "upload:" + hex.EncodeToString(sum[:])
+	if ref, err := h.store.Get(ctx, key); err == nil {
+		return string(ref), nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		log.Println("image upload cache error:", err)
+	}
+	ref, err := h.sendUpload(ctx, data, mediaType, c)
+	if err != nil {
+		return "", err
+	}
+	if err := h.store.Set(ctx, key, []byte(ref), uploadTTL); err != nil {
+		log.Println("image upload cache error:", err)
+	}
+	logf(ctx, "uploaded %s image of %d KiB as %s", mediaType, len(data)>>10, ref)
+	return ref, nil
+}
+
+func (h *handler) sendUpload(ctx context.Context, data []byte, mediaType string, c *call) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("purpose", uploadPurpose)
+	name := "image"
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		name += exts[0]
+	}
+	file, err := form.CreatePart(map[string][]string{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename=%q`, name)},
+		"Content-Type":        {mediaType},
+	})
+	if err != nil {
+		return "", err
+	}
+	file.Write(data)
+	form.Close()
+
+	target := h.upload.target
+	if target == uploadProvider {
+		target = strings.TrimSuffix(c.config.URL, "/chat/completions") + "/files"
+	}
+	ctx, cancel := context.WithTimeout(ctx, uploadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, &body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUpload, err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if h.upload.target == uploadProvider {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errUpload, err)
+	}
+	defer resp.Body.Close()
+	answer, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("%w with %d: %s", errUpload, resp.StatusCode, upstreamMessage(resp.StatusCode, answer))
+	}
+	var uploaded struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	json.Unmarshal(answer, &uploaded)
+	if ref := cmp.Or(uploaded.URL, uploaded.ID); ref != "" {
+		return ref, nil
+	}
+	return "", fmt.Errorf("%w, answer has neither url nor id: %.200s", errUpload, answer)
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+)
+
+const limitByUser = "user"
+
+// translateUser sends the OpenAI user field as user_id to GLM providers,
+// which accept 6 to 128 characters, other IDs are sent as their hash.
+// OpenAI compatible providers get user as is.
+func translateUser(payload map[string]json.RawMessage, provider string) {
+	user := stringValue(payload["user"], "")
+	if user == "" || !slices.ContainsFunc(providers, func(p Provider) bool { return p.Name == provider }) {
+		return
+	}
+	delete(payload, "user")
+	if len(user) < 6 || len(user) > 128 {
+		sum := sha256.Sum256([]byte(user))
+		user = "user-" + hex.EncodeToString(sum[:8])
+	}
+	payload["user_id"] = rawJSON(user)
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+
+	"freeglm/internal/version"
+)
+
+// versionInfo is the answer of /version: build metadata, the API level and
+// what this proxy serves with its flags, so clients and dashboards check a
+// feature instead of guessing it from the version.
+type versionInfo struct {
+	version.Info
+	Features map[string]bool `json:"features"`
+}
+
+func (h *handler) handleVersion(w http.ResponseWriter) {
+	h.sendJSON(w, http.StatusOK, versionInfo{Info: version.Get(), Features: h.features()})
+}
+
+func (h *handler) features() map[string]bool {
+	_routes := h.routes.Load()
+	return map[string]bool{
+		"chat":         true,
+		"completions":  true,
+		"audio":        len(_routes.audio) > 0,
+		"images":       len(_routes.images) > 0,
+		"websocket":    h.webSocket,
+		"threads":      h.threads != nil,
+		"batches":      h.batches != nil,
+		"admin":        h.admin.token != "",
+		"cache":        h.cache != nil,
+		"resume":       h.resume != nil,
+		"usage_db":     h.usageDB != nil,
+		"virtual_keys": h.virtual != nil,
+		"tenants":      len(_routes.tenants) > 0,
+		"json_repair":  h.jsonRepair,
+		"context_fit":  h.contextStrategy != contextOff,
+		"affinity":     h.affinity,
+		"fair":         h.fair != nil,
+		"budget":       h.budget != nil,
+		"dedup":        h.flights != nil,
+		"prefill":      h.prefill != prefillPass,
+		"split_tools":  h.splitTools,
+		"image_upload": h.upload != nil,
+		"race":         h.race.all,
+		"shadow":       h.shadow.model != "",
+	}
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"freeglm/internal/storage"
+)
+
+const virtualKeyPrefix = "fgk-"
+
+// VirtualKey is issued by freeglm to a client, requests with it are sent
+// with pool keys within its quotas, zero quota is unlimited.
+type VirtualKey struct {
+	Name        string    `json:"name"`
+	Key         string    `json:"key"`
+	DailyTokens int       `json:"daily_tokens"`
+	RPM         int       `json:"rpm"`
+	Created     time.Time `json:"created"`
+}
+
+// VirtualUsage is the total tokens used by a virtual key on Day (UTC).
+type VirtualUsage struct {
+	Day    string `json:"day"`
+	Tokens int    `json:"tokens"`
+
+	minute   int64
+	requests int
+}
+
+type virtualKeyFile struct {
+	Keys []VirtualKey `json:"keys"`
+}
+
+func NewVirtualKey(name string, dailyTokens, rpm int) (VirtualKey, error) {
+	if name == "" {
+		return VirtualKey{}, fmt.Errorf("virtual key name is empty")
+	}
+	if dailyTokens < 0 || rpm < 0 {
+		return VirtualKey{}, fmt.Errorf("daily tokens and rpm must not be negative")
+	}
+	return VirtualKey{
+		Name:        name,
+		Key:         virtualKeyPrefix + strings.ToLower(rand.Text()+rand.Text()),
+		DailyTokens: dailyTokens,
+		RPM:         rpm,
+		Created:     time.Now().UTC().Truncate(time.Second),
+	}, nil
+}
+
+// LoadVirtualKeys reads keys written by SaveVirtualKeys, a missing file has
+// no keys.
+func LoadVirtualKeys(path string) ([]VirtualKey, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file virtualKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parse virtual keys %s: %w", path, err)
+	}
+	return file.Keys, nil
+}
+
+func SaveVirtualKeys(path string, keys []VirtualKey) error {
+	data, err := json.MarshalIndent(virtualKeyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, data)
+}
+
+// LoadVirtualUsage reads token usage of virtual keys from path (keys file)
+// saved by the server.
+func LoadVirtualUsage(path string) (map[string]*VirtualUsage, error) {
+	usage := map[string]*VirtualUsage{}
+	data, err := os.ReadFile(usagePath(path))
+	if os.IsNotExist(err) {
+		return usage, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return nil, fmt.Errorf("parse virtual keys usage: %w", err)
+	}
+	return usage, nil
+}
+
+func usagePath(path string) string {
+	return path + ".usage"
+}
+
+func writeFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type virtualKeyContext struct{}
+
+// quotas counts total tokens per day and requests per minute by name, days
+// start at midnight of loc (UTC unless set). With shared storage the counters
+// are kept there under "quota:SCOPE:NAME:" for all replicas and usage
+// mirrors them.
+type quotas struct {
+	mu    sync.Mutex
+	usage map[string]*VirtualUsage
+	dirty bool
+	scope string
+	store storage.Storage
+	loc   *time.Location
+}
+
+func newQuotas(usage map[string]*VirtualUsage, scope string, store storage.Storage) *quotas {
+	if usage == nil {
+		usage = map[string]*VirtualUsage{}
+	}
+	q := &quotas{usage: usage, scope: scope, loc: time.UTC}
+	if storage.Shared(store) {
+		q.store = store
+	}
+	return q
+}
+
+// admit counts the request of name or returns the error code (rate limit
+// or insufficient quota), message and Retry-After to reject it with, zero
+// limits are unlimited.
+func (q *quotas) admit(name string, dailyTokens, rpm int) (string, string, time.Duration) {
+	if q.store != nil {
+		code, msg, retry, err := q.admitShared(name, dailyTokens, rpm)
+		if err == nil {
+			return code, msg, retry
+		}
+		log.Println("shared quota error:", err)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	u := q.today(name, now)
+	if dailyTokens > 0 && u.Tokens >= dailyTokens {
+		return insufficientQuota, fmt.Sprintf("Daily quota of %d tokens is used up for %s", dailyTokens, name), time.Until(q.tomorrow(now))
+	}
+	if rpm > 0 {
+		if minute := now.Unix() / 60; u.minute != minute {
+			u.minute, u.requests = minute, 0
+		}
+		if u.requests >= rpm {
+			return codeRateLimit, fmt.Sprintf("Rate limit of %d requests per minute reached for %s", rpm, name), time.Duration(60-now.Unix()%60) * time.Second
+		}
+		u.requests++
+	}
+	return "", "", 0
+}
+
+func (q *quotas) day(now time.Time) string {
+	return now.In(q.loc).Format(time.DateOnly)
+}
+
+// tomorrow is the next midnight, when counters of today start over.
+func (q *quotas) tomorrow(now time.Time) time.Time {
+	year, month, day := now.In(q.loc).Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, q.loc)
+}
+
+func (q *quotas) today(name string, now time.Time) *VirtualUsage {
+	day := q.day(now)
+	u, ok := q.usage[name]
+	if !ok {
+		u = &VirtualUsage{}
+		q.usage[name] = u
+	}
+	if u.Day != day {
+		u.Day, u.Tokens = day, 0
+	}
+	return u
+}
+
+func (q *quotas) admitShared(name string, dailyTokens, rpm int) (string, string, time.Duration, error) {
+	ctx := context.Background()
+	now := time.Now()
+	if dailyTokens > 0 {
+		tokens := 0
+		data, err := q.store.Get(ctx, q.key(name, "tokens", q.day(now)))
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return "", "", 0, err
+		}
+		if err == nil {
+			if tokens, err = strconv.Atoi(string(data)); err != nil {
+				return "", "", 0, err
+			}
+			q.mirror(name, now, tokens)
+		}
+		if tokens >= dailyTokens {
+			return insufficientQuota, fmt.Sprintf("Daily quota of %d tokens is used up for %s", dailyTokens, name), time.Until(q.tomorrow(now)), nil
+		}
+	}
+	if rpm > 0 {
+		requests, err := q.store.Incr(ctx, q.key(name, "rpm", strconv.FormatInt(now.Unix()/60, 10)), 1, 2*time.Minute)
+		if err != nil {
+			return "", "", 0, err
+		}
+		if requests > int64(rpm) {
+			return codeRateLimit, fmt.Sprintf("Rate limit of %d requests per minute reached for %s", rpm, name), time.Duration(60-now.Unix()%60) * time.Second, nil
+		}
+	}
+	return "", "", 0, nil
+}
+
+func (q *quotas) used(name string, tokens int) {
+	now := time.Now()
+	q.mu.Lock()
+	q.today(name, now).Tokens += tokens
+	q.dirty = true
+	q.mu.Unlock()
+	if q.store == nil {
+		return
+	}
+	total, err := q.store.Incr(context.Background(), q.key(name, "tokens", q.day(now)), int64(tokens), 48*time.Hour)
+	if err != nil {
+		log.Println("shared quota error:", err)
+		return
+	}
+	q.mirror(name, now, int(total))
+}
+
+// mirror sets tokens used today by name on all replicas.
+func (q *quotas) mirror(name string, now time.Time, tokens int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if u := q.today(name, now); u.Tokens != tokens {
+		u.Tokens = tokens
+		q.dirty = true
+	}
+}
+
+func (q *quotas) key(name, counter, period string) string {
+	return "quota:" + q.scope + ":" + name + ":" + counter + ":" + period
+}
+
+// virtualKeys checks client keys against the keys file, which is re-read
+// when changed so "freeglm keys create" works without restart.
+type virtualKeys struct {
+	mu      sync.Mutex
+	path    string
+	checked time.Time
+	modTime time.Time
+	keys    []VirtualKey
+	quotas  *quotas
+}
+
+func newVirtualKeys(path string, store storage.Storage) (*virtualKeys, error) {
+	if path == "" {
+		return nil, nil
+	}
+	usage, err := LoadVirtualUsage(path)
+	if err != nil {
+		return nil, err
+	}
+	v := &virtualKeys{path: path, quotas: newQuotas(usage, "virtual", store)}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (v *virtualKeys) reload() error {
+	info, err := os.Stat(v.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if info != nil && info.ModTime().Equal(v.modTime) {
+		return nil
+	}
+	keys, err := LoadVirtualKeys(v.path)
+	if err != nil {
+		return err
+	}
+	v.keys = keys
+	if info != nil {
+		v.modTime = info.ModTime()
+	}
+	return nil
+}
+
+// admit returns name of the virtual key or the error code and message to
+// reject the request with.
+func (v *virtualKeys) admit(token string) (string, string, string, time.Duration) {
+	v.mu.Lock()
+	if now := time.Now(); now.Sub(v.checked) > 2*time.Second {
+		v.checked = now
+		if err := v.reload(); err != nil {
+			log.Println("virtual keys reload error:", err)
+		}
+	}
+	i := slices.IndexFunc(v.keys, func(k VirtualKey) bool {
+		return subtle.ConstantTimeCompare([]byte(k.Key), []byte(token)) == 1
+	})
+	if token == "" || i < 0 {
+		v.mu.Unlock()
+		return "", codeInvalidKey, "Invalid virtual key", 0
+	}
+	key := v.keys[i]
+	v.mu.Unlock()
+	if code, msg, retry := v.quotas.admit(key.Name, key.DailyTokens, key.RPM); code != "" {
+		return "", code, msg, retry
+	}
+	return key.Name, "", "", 0
+}
+
+func (v *virtualKeys) used(name string, tokens int) {
+	v.quotas.used(name, tokens)
+}
+
+func (v *virtualKeys) save() error {
+	q := v.quotas
+	q.mu.Lock()
+	if !q.dirty {
+		q.mu.Unlock()
+		return nil
+	}
+	q.dirty = false
+	data, err := json.MarshalIndent(q.usage, "", "  ")
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(usagePath(v.path), data)
+}
+
+func (v *virtualKeys) persist(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.save(); err != nil {
+				log.Println("virtual keys usage save error:", err)
+			}
+		}
+	}
+}
+
+// authorizeVirtual replaces a virtual key of the client with pool keys,
+// admin endpoints keep their own token.
+func (h *handler) authorizeVirtual(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if h.virtual == nil || strings.HasPrefix(r.URL.Path, "/admin/") {
+		return r, true
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer"))
+	name, code, msg, retry := h.virtual.admit(token)
+	switch code {
+	case "":
+	case codeInvalidKey:
+		h.sendErrorJSON(w, http.StatusUnauthorized, msg)
+		return nil, false
+	default:
+		h.sendLimitError(w, code, msg, retry)
+		return nil, false
+	}
+	r = r.WithContext(context.WithValue(r.Context(), virtualKeyContext{}, name))
+	r.Header.Del("Authorization")
+	return r, true
+}
+
+func virtualKeyName(ctx context.Context) string {
+	name, _ := ctx.Value(virtualKeyContext{}).(string)
+	return name
+}
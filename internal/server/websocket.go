@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/coder/websocket"
+)
+
+func isWebSocketPath(path string) bool {
+	return path == "/ws/chat" || path == "/v1/ws/chat"
+}
+
+// handleWebSocket serves chat completions over a WebSocket: every text
+// message is a request, answered with what a stream sends as SSE data, one
+// chunk per message up to "[DONE]", or with an error object. Requests on a
+// connection are served one after another.
+func (h *handler) handleWebSocket(w http.ResponseWriter, r *http.Request, tenant *tenantRoutes) {
+	if !h.webSocket {
+		h.sendErrorJSON(w, http.StatusNotFound, "WebSocket is disabled, run server with --websocket")
+		return
+	}
+	// browsers send no CORS preflight for WebSockets, so pages of other
+	// origins could spend pool keys unless allowed with --websocket-origin
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: h.wsOrigins})
+	if err != nil {
+		logf(r.Context(), "websocket: %v", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(-1)
+	if h.maxBody > 0 {
+		conn.SetReadLimit(h.maxBody)
+	}
+	// the request context ends when the connection is hijacked
+	ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+	defer cancel()
+	for {
+		typ, msg, err := conn.Read(ctx)
+		if err != nil {
+			if status := websocket.CloseStatus(err); status != websocket.StatusNormalClosure && status != websocket.StatusGoingAway {
+				logf(ctx, "websocket closed: %v", err)
+			}
+			return
+		}
+		if typ != websocket.MessageText {
+			conn.Close(websocket.StatusUnsupportedData, "requests must be text messages")
+			return
+		}
+		ww := &wsWriter{conn: conn, ctx: ctx, header: http.Header{}}
+		h.webSocketRequest(ctx, ww, r, msg, tenant)
+		if err := ww.finish(); err != nil {
+			logf(ctx, "websocket send error: %v", err)
+			return
+		}
+	}
+}
+
+// webSocketRequest runs msg through the checks of a POST to
+// /v1/chat/completions and forwards it as a stream.
+func (h *handler) webSocketRequest(ctx context.Context, w *wsWriter, r *http.Request, msg []byte, tenant *tenantRoutes) {
+	id := randomID("req_", 24)
+	w.Header().Set(requestIDHeader, id)
+	req := r.Clone(context.WithValue(ctx, requestIDKey{}, id))
+	req.Method = http.MethodPost
+	req.URL.Path = "/v1/chat/completions"
+	req.Body = http.NoBody
+	req.Header.Del("Last-Event-ID")
+	if !h.rateLimit(w, req) {
+		return
+	}
+	req, ok := h.authorizeVirtual(w, req)
+	if !ok || !h.admitTenant(w, tenant) {
+		return
+	}
+	payload, err := decodeJSONBytes(msg)
+	if err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, fmt.Sprintf("Invalid body: %v", err))
+		return
+	}
+	payload["stream"] = rawJSON(true)
+	ensureMessages(payload)
+	if err := validateMessages(payload["messages"]); err != nil {
+		h.sendErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	h.forward(w, req, payload, nil)
+}
+
+// wsWriter sends every SSE data line of a stream response as a message,
+// other responses (errors) are sent whole by finish.
+type wsWriter struct {
+	conn    *websocket.Conn
+	ctx     context.Context
+	header  http.Header
+	status  int
+	stream  bool
+	pending []byte
+	body    bytes.Buffer
+	err     error
+}
+
+func (w *wsWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *wsWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.stream = status == http.StatusOK && strings.HasPrefix(w.header.Get("Content-Type"), "text/event-stream")
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if !w.stream {
+		return w.body.Write(p)
+	}
+	w.pending = append(w.pending, p...)
+	for {
+		idx := bytes.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			return len(p), nil
+		}
+		line := bytes.TrimSpace(w.pending[:idx])
+		w.pending = w.pending[idx+1:]
+		data, ok := bytes.CutPrefix(line, []byte("data:"))
+		if !ok {
+			continue
+		}
+		if w.err = w.conn.Write(w.ctx, websocket.MessageText, bytes.TrimSpace(data)); w.err != nil {
+			return 0, w.err
+		}
+	}
+}
+
+func (w *wsWriter) Flush() {}
+
+func (w *wsWriter) finish() error {
+	if w.err != nil || w.stream || w.body.Len() == 0 {
+		return w.err
+	}
+	return w.conn.Write(w.ctx, websocket.MessageText, bytes.TrimSpace(w.body.Bytes()))
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package service
+
+// Serve is a no-op outside of Windows, systemd and launchd run the server
+// as a regular process.
+func Serve(serve func() error, shutdown func()) (bool, error) {
+	return false, nil
+}
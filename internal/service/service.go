@@ -0,0 +1,47 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const name = "freeglm"
+
+type Service struct {
+	Exec string
+	Args []string
+	Env  map[string]string
+	User bool
+}
+
+func New(args []string, user bool) (*Service, error) {
+	_exec, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+	if _exec, err = filepath.EvalSymlinks(_exec); err != nil {
+		return nil, fmt.Errorf("find executable: %w", err)
+	}
+	env := map[string]string{}
+	if key := os.Getenv("ZAI_API_KEY"); key != "" {
+		env["ZAI_API_KEY"] = key
+	}
+	return &Service{
+		Exec: _exec,
+		Args: args,
+		Env:  env,
+		User: user,
+	}, nil
+}
+
+func run(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %w: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+const label = "com.github.devil666face." + name
+
+var plist = template.Must(template.New("plist").Funcs(template.FuncMap{"escape": escape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ .Label }}</string>
+	<key>ProgramArguments</key>
+	<array>
+	{{- range .Args }}
+		<string>{{ escape . }}</string>
+	{{- end }}
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+	{{- range $key, $value := .Env }}
+		<key>{{ escape $key }}</key>
+		<string>{{ escape $value }}</string>
+	{{- end }}
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{ .Log }}</string>
+	<key>StandardErrorPath</key>
+	<string>{{ .Log }}</string>
+</dict>
+</plist>
+`))
+
+func (s *Service) path() (string, error) {
+	if !s.User {
+		return filepath.Join("/Library/LaunchDaemons", label+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", label+".plist"), nil
+}
+
+func (s *Service) Install() (string, error) {
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := plist.Execute(f, map[string]any{
+		"Label": label,
+		"Args":  slices.Concat([]string{s.Exec}, s.Args),
+		"Env":   s.Env,
+		"Log":   filepath.Join(os.TempDir(), name+".log"),
+	}); err != nil {
+		return "", err
+	}
+	return path, run("launchctl", "load", "-w", path)
+}
+
+func (s *Service) Uninstall() (string, error) {
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	if err := run("launchctl", "unload", "-w", path); err != nil {
+		return "", err
+	}
+	return path, os.Remove(path)
+}
+
+func (s *Service) Start() error {
+	return run("launchctl", "start", label)
+}
+
+func (s *Service) Stop() error {
+	return run("launchctl", "stop", label)
+}
+
+func escape(value string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(value))
+	return b.String()
+}
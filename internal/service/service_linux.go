@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+var unit = template.Must(template.New("unit").Parse(`[Unit]
+Description=FreeGLM proxy from GLM to OpenAI API
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{ .ExecStart }}
+Restart=on-failure
+RestartSec=5
+{{- range .Env }}
+Environment={{ . }}
+{{- end }}
+
+[Install]
+WantedBy={{ .WantedBy }}
+`))
+
+func (s *Service) path() (string, error) {
+	if !s.User {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+func (s *Service) systemctl(args ...string) error {
+	if s.User {
+		args = append([]string{"--user"}, args...)
+	}
+	return run("systemctl", args...)
+}
+
+func (s *Service) Install() (string, error) {
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	execStart := []string{quote(s.Exec)}
+	for _, arg := range s.Args {
+		execStart = append(execStart, quote(arg))
+	}
+	var env []string
+	for _, key := range slices.Sorted(maps.Keys(s.Env)) {
+		env = append(env, quote(key+"="+s.Env[key]))
+	}
+	wantedBy := "multi-user.target"
+	if s.User {
+		wantedBy = "default.target"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := unit.Execute(f, map[string]any{
+		"ExecStart": strings.Join(execStart, " "),
+		"Env":       env,
+		"WantedBy":  wantedBy,
+	}); err != nil {
+		return "", err
+	}
+	if err := s.systemctl("daemon-reload"); err != nil {
+		return "", err
+	}
+	return path, s.systemctl("enable", name+".service")
+}
+
+func (s *Service) Uninstall() (string, error) {
+	path, err := s.path()
+	if err != nil {
+		return "", err
+	}
+	if err := s.systemctl("disable", "--now", name+".service"); err != nil {
+		return "", err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", err
+	}
+	return path, s.systemctl("daemon-reload")
+}
+
+func (s *Service) Start() error {
+	return s.systemctl("start", name+".service")
+}
+
+func (s *Service) Stop() error {
+	return s.systemctl("stop", name+".service")
+}
+
+func quote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	if !strings.ContainsAny(arg, " \t\"'\\;$") {
+		return arg
+	}
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	return fmt.Sprintf(`"%s"`, arg)
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !windows
+
+package service
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func (s *Service) Install() (string, error) {
+	return "", fmt.Errorf("service is not supported on %s", runtime.GOOS)
+}
+
+func (s *Service) Uninstall() (string, error) {
+	return "", fmt.Errorf("service is not supported on %s", runtime.GOOS)
+}
+
+func (s *Service) Start() error {
+	return fmt.Errorf("service is not supported on %s", runtime.GOOS)
+}
+
+func (s *Service) Stop() error {
+	return fmt.Errorf("service is not supported on %s", runtime.GOOS)
+}
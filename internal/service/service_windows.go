@@ -0,0 +1,184 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Windows services are installed for the machine only, Service.User is
+// ignored.
+const serviceKey = `SYSTEM\CurrentControlSet\Services\` + name
+
+func (s *Service) Install() (string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("connect to service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+	if _svc, err := m.OpenService(name); err == nil {
+		_svc.Close()
+		return "", fmt.Errorf("service %s already exists, uninstall it first", name)
+	}
+	_svc, err := m.CreateService(name, s.Exec, mgr.Config{
+		DisplayName: "FreeGLM",
+		Description: "FreeGLM proxy from GLM to OpenAI API",
+		StartType:   mgr.StartAutomatic,
+	}, s.Args...)
+	if err != nil {
+		return "", err
+	}
+	defer _svc.Close()
+	if err := _svc.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+	}, uint32((24 * time.Hour).Seconds())); err != nil {
+		return "", err
+	}
+	if len(s.Env) != 0 {
+		key, err := registry.OpenKey(registry.LOCAL_MACHINE, serviceKey, registry.SET_VALUE)
+		if err != nil {
+			return "", err
+		}
+		defer key.Close()
+		var env []string
+		for _, k := range slices.Sorted(maps.Keys(s.Env)) {
+			env = append(env, k+"="+s.Env[k])
+		}
+		if err := key.SetStringsValue("Environment", env); err != nil {
+			return "", err
+		}
+	}
+	return `HKLM\` + serviceKey, nil
+}
+
+func (s *Service) Uninstall() (string, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("connect to service manager (run as Administrator): %w", err)
+	}
+	defer m.Disconnect()
+	_svc, err := m.OpenService(name)
+	if err != nil {
+		return "", err
+	}
+	defer _svc.Close()
+	if err := stop(_svc); err != nil {
+		return "", err
+	}
+	return `HKLM\` + serviceKey, _svc.Delete()
+}
+
+func (s *Service) Start() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	_svc, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer _svc.Close()
+	return _svc.Start()
+}
+
+func (s *Service) Stop() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+	_svc, err := m.OpenService(name)
+	if err != nil {
+		return err
+	}
+	defer _svc.Close()
+	return stop(_svc)
+}
+
+// stop asks the service to stop and waits up to 30 sec. for it.
+func stop(_svc *mgr.Service) error {
+	status, err := _svc.Control(svc.Stop)
+	if errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop in 30 sec.", name)
+		}
+		time.Sleep(300 * time.Millisecond)
+		if status, err = _svc.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Serve runs serve under the service manager when the process is started
+// by it, shutdown is called on stop. Logs go to %TEMP%\freeglm.log as the
+// service has no console. ok is false for a process started from console.
+func Serve(serve func() error, shutdown func()) (ok bool, err error) {
+	if ok, err := svc.IsWindowsService(); !ok || err != nil {
+		return false, err
+	}
+	if f, err := os.OpenFile(filepath.Join(os.TempDir(), name+".log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err == nil {
+		defer f.Close()
+		log.SetOutput(f)
+		os.Stdout, os.Stderr = f, f
+	}
+	h := &handler{serve: serve, shutdown: shutdown}
+	if err := svc.Run(name, h); err != nil {
+		return true, err
+	}
+	return true, h.err
+}
+
+type handler struct {
+	serve    func() error
+	shutdown func()
+	err      error
+}
+
+func (h *handler) Execute(_ []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.serve()
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case h.err = <-done:
+			// the server failed on its own, non-zero exit code triggers recovery restart
+			if h.err != nil {
+				log.Println("server error:", h.err)
+				return true, 1
+			}
+			return false, 0
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.shutdown()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
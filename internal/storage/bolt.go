@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketState = []byte("state")
+
+// Bolt keeps state in a bbolt file, values are prefixed with their expiry
+// in Unix nanoseconds, zero never expires.
+type Bolt struct {
+	db   *bolt.DB
+	stop chan struct{}
+}
+
+func OpenBolt(path string) (*Bolt, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketState)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	b := &Bolt{db: db, stop: make(chan struct{})}
+	go sweep(b.stop, b.expire)
+	return b, nil
+}
+
+func (b *Bolt) Get(_ context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v, ok := decode(tx.Bucket(bucketState).Get([]byte(key)), time.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		value = bytes.Clone(v)
+		return nil
+	})
+	return value, err
+}
+
+func (b *Bolt) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketState).Put([]byte(key), encode(value, expiry(ttl)))
+	})
+}
+
+func (b *Bolt) Delete(_ context.Context, key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketState).Delete([]byte(key))
+	})
+}
+
+func (b *Bolt) Incr(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	var v int64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketState)
+		data := bucket.Get([]byte(key))
+		expires := expiry(ttl)
+		if value, ok := decode(data, time.Now()); ok {
+			var err error
+			if v, err = strconv.ParseInt(string(value), 10, 64); err != nil {
+				return err
+			}
+			expires = expiresAt(data)
+		}
+		v += n
+		return bucket.Put([]byte(key), encode(strconv.AppendInt(nil, v, 10), expires))
+	})
+	return v, err
+}
+
+func (b *Bolt) Scan(_ context.Context, prefix string, fn func(key string, value []byte) error) error {
+	type pair struct {
+		key   string
+		value []byte
+	}
+	var found []pair
+	err := b.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		c := tx.Bucket(bucketState).Cursor()
+		for k, data := c.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, data = c.Next() {
+			if v, ok := decode(data, now); ok {
+				found = append(found, pair{string(k), bytes.Clone(v)})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// fn may write, which would deadlock inside the read transaction
+	for _, p := range found {
+		if err := fn(p.key, p.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Bolt) Close() error {
+	close(b.stop)
+	return b.db.Close()
+}
+
+func (b *Bolt) expire() {
+	b.db.Update(func(tx *bolt.Tx) error {
+		now := time.Now()
+		bucket := tx.Bucket(bucketState)
+		var expired [][]byte
+		bucket.ForEach(func(k, data []byte) error {
+			if _, ok := decode(data, now); !ok {
+				expired = append(expired, bytes.Clone(k))
+			}
+			return nil
+		})
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func encode(value []byte, expires time.Time) []byte {
+	var nanos int64
+	if !expires.IsZero() {
+		nanos = expires.UnixNano()
+	}
+	return append(binary.BigEndian.AppendUint64(nil, uint64(nanos)), value...)
+}
+
+// decode returns the value of data unless it expired before now.
+func decode(data []byte, now time.Time) ([]byte, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	if expires := expiresAt(data); !expires.IsZero() && now.After(expires) {
+		return nil, false
+	}
+	return data[8:], true
+}
+
+func expiresAt(data []byte) time.Time {
+	nanos := int64(binary.BigEndian.Uint64(data))
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
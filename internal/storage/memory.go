@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type item struct {
+	value   []byte
+	expires time.Time
+}
+
+func (i item) expired(now time.Time) bool {
+	return !i.expires.IsZero() && now.After(i.expires)
+}
+
+// Memory keeps state in the process, it is lost on restart.
+type Memory struct {
+	mu    sync.Mutex
+	items map[string]item
+	stop  chan struct{}
+}
+
+func NewMemory() *Memory {
+	m := &Memory{items: map[string]item{}, stop: make(chan struct{})}
+	go sweep(m.stop, m.expire)
+	return m
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.items[key]
+	if !ok || i.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return slices.Clone(i.value), nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.items[key] = item{value: slices.Clone(value), expires: expiry(ttl)}
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.items, key)
+	return nil
+}
+
+func (m *Memory) Incr(_ context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.items[key]
+	if !ok || i.expired(time.Now()) {
+		i = item{value: []byte("0"), expires: expiry(ttl)}
+	}
+	v, err := strconv.ParseInt(string(i.value), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	v += n
+	i.value = strconv.AppendInt(nil, v, 10)
+	m.items[key] = i
+	return v, nil
+}
+
+func (m *Memory) Scan(_ context.Context, prefix string, fn func(key string, value []byte) error) error {
+	m.mu.Lock()
+	now := time.Now()
+	found := map[string][]byte{}
+	for key, i := range m.items {
+		if strings.HasPrefix(key, prefix) && !i.expired(now) {
+			found[key] = slices.Clone(i.value)
+		}
+	}
+	m.mu.Unlock()
+	for _, key := range slices.Sorted(maps.Keys(found)) {
+		if err := fn(key, found[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Close() error {
+	close(m.stop)
+	return nil
+}
+
+func (m *Memory) expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	maps.DeleteFunc(m.items, func(_ string, i item) bool { return i.expired(now) })
+}
+
+// sweep drops expired keys every minute until stop is closed.
+func sweep(stop chan struct{}, expire func()) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			expire()
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPrefix namespaces keys of freeglm in a shared Redis database.
+const redisPrefix = "freeglm:"
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// Redis keeps state in a Redis server, so replicas behind a load balancer
+// share it.
+type Redis struct {
+	client *redis.Client
+}
+
+func OpenRedis(url string) (*Redis, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("storage %s: %w", url, err)
+	}
+	client := redis.NewClient(opts)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to redis %s: %w", opts.Addr, err)
+	}
+	return &Redis{client: client}, nil
+}
+
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := r.client.Get(ctx, redisPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, redisPrefix+key, value, max(0, ttl)).Err()
+}
+
+func (r *Redis) Delete(ctx context.Context, key string) error {
+	return r.client.Del(ctx, redisPrefix+key).Err()
+}
+
+func (r *Redis) Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error) {
+	v, err := r.client.IncrBy(ctx, redisPrefix+key, n).Result()
+	if err != nil {
+		return 0, err
+	}
+	if v == n && ttl > 0 {
+		err = r.client.Expire(ctx, redisPrefix+key, ttl).Err()
+	}
+	return v, err
+}
+
+func (r *Redis) Scan(ctx context.Context, prefix string, fn func(key string, value []byte) error) error {
+	var keys []string
+	iter := r.client.Scan(ctx, 0, redisPrefix+globEscaper.Replace(prefix)+"*", 256).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+	for batch := range slices.Chunk(keys, 256) {
+		values, err := r.client.MGet(ctx, batch...).Result()
+		if err != nil {
+			return err
+		}
+		for i, value := range values {
+			// the key expired or was deleted since the scan
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			if err := fn(strings.TrimPrefix(batch[i], redisPrefix), []byte(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
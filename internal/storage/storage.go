@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// Storage keeps state which outlives a request: usage counters, key state,
+// cached responses and conversations. Memory keeps it in the process, bbolt
+// in a file and Redis shares it between replicas. Zero ttl never expires,
+// counters of Incr are stored as decimal text.
+type Storage interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Incr adds n to the counter at key and returns the new value, ttl is
+	// set when the counter is created.
+	Incr(ctx context.Context, key string, n int64, ttl time.Duration) (int64, error)
+	// Scan calls fn for keys with prefix in key order.
+	Scan(ctx context.Context, prefix string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// Open returns the storage of url: "memory" (or empty), "bolt:PATH" or
+// "redis://[user:password@]host:port/db".
+func Open(url string) (Storage, error) {
+	switch scheme, rest, _ := strings.Cut(url, ":"); scheme {
+	case "", "memory":
+		return NewMemory(), nil
+	case "bolt", "bbolt":
+		path := strings.TrimPrefix(rest, "//")
+		if path == "" {
+			return nil, fmt.Errorf("storage %s: file path is empty", url)
+		}
+		return OpenBolt(path)
+	case "redis", "rediss":
+		return OpenRedis(url)
+	}
+	return nil, fmt.Errorf("storage must be memory, bolt:PATH or redis://HOST:PORT, got %q", url)
+}
+
+// Shared reports whether replicas see the state of each other.
+func Shared(s Storage) bool {
+	_, ok := s.(*Redis)
+	return ok
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
@@ -0,0 +1,110 @@
+package threads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"freeglm/internal/storage"
+)
+
+// kvStore keeps threads in a shared storage: the thread at "thread:ID",
+// a message counter at "thread:ID/seq" and messages at "thread:ID/msg/SEQ".
+type kvStore struct {
+	kv storage.Storage
+}
+
+// New returns the store in kv, which stays open on Close.
+func New(kv storage.Storage) Store {
+	return &kvStore{kv: kv}
+}
+
+func (s *kvStore) Close() error {
+	return nil
+}
+
+func (s *kvStore) Create(owner string, metadata map[string]string, messages ...Message) (Thread, error) {
+	t := newThread(owner, metadata)
+	data, err := json.Marshal(t)
+	if err != nil {
+		return t, err
+	}
+	if err := s.kv.Set(context.Background(), threadKey(t.ID), data, 0); err != nil {
+		return t, err
+	}
+	return t, s.append(t.ID, messages)
+}
+
+func (s *kvStore) Get(id string) (Thread, error) {
+	var t Thread
+	data, err := s.kv.Get(context.Background(), threadKey(id))
+	if errors.Is(err, storage.ErrNotFound) {
+		return t, ErrNotFound
+	}
+	if err != nil {
+		return t, err
+	}
+	return t, json.Unmarshal(data, &t)
+}
+
+func (s *kvStore) Delete(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := s.kv.Delete(ctx, threadKey(id)); err != nil {
+		return err
+	}
+	return s.kv.Scan(ctx, threadKey(id)+"/", func(key string, _ []byte) error {
+		return s.kv.Delete(ctx, key)
+	})
+}
+
+func (s *kvStore) Messages(id string) ([]Message, error) {
+	if _, err := s.Get(id); err != nil {
+		return nil, err
+	}
+	var messages []Message
+	err := s.kv.Scan(context.Background(), threadKey(id)+"/msg/", func(_ string, value []byte) error {
+		var m Message
+		if err := json.Unmarshal(value, &m); err != nil {
+			return err
+		}
+		messages = append(messages, m)
+		return nil
+	})
+	return messages, err
+}
+
+func (s *kvStore) Append(id string, messages ...Message) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return s.append(id, messages)
+}
+
+func (s *kvStore) append(id string, messages []Message) error {
+	ctx := context.Background()
+	for i := range messages {
+		m := &messages[i]
+		m.stamp(id)
+		seq, err := s.kv.Incr(ctx, threadKey(id)+"/seq", 1, 0)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		// fixed width keeps messages in order of the key scan
+		if err := s.kv.Set(ctx, fmt.Sprintf("%s/msg/%016x", threadKey(id), seq), data, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func threadKey(id string) string {
+	return "thread:" + id
+}
@@ -0,0 +1,200 @@
+package threads
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+var (
+	ErrNotFound = errors.New("thread not found")
+
+	bucketThreads  = []byte("threads")
+	bucketMessages = []byte("messages")
+	keyThread      = []byte("thread")
+)
+
+type Thread struct {
+	ID        string            `json:"id"`
+	Object    string            `json:"object"`
+	CreatedAt int64             `json:"created_at"`
+	Metadata  map[string]string `json:"metadata"`
+	// Owner is the virtual key which created the thread.
+	Owner string `json:"owner,omitempty"`
+}
+
+type Message struct {
+	ID         string          `json:"id"`
+	Object     string          `json:"object"`
+	CreatedAt  int64           `json:"created_at"`
+	ThreadID   string          `json:"thread_id"`
+	Role       string          `json:"role"`
+	Content    json.RawMessage `json:"content"`
+	Name       string          `json:"name,omitempty"`
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+}
+
+// Store keeps threads and their messages.
+type Store interface {
+	Create(owner string, metadata map[string]string, messages ...Message) (Thread, error)
+	Get(id string) (Thread, error)
+	Delete(id string) error
+	Messages(id string) ([]Message, error)
+	// Append stores messages in the thread and fills their id, object,
+	// created_at and thread_id.
+	Append(id string, messages ...Message) error
+	Close() error
+}
+
+// boltStore keeps a bucket with the thread and its messages by thread id.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// Open returns the store in the bbolt file at path.
+func Open(path string) (Store, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketThreads)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *boltStore) Create(owner string, metadata map[string]string, messages ...Message) (Thread, error) {
+	t := newThread(owner, metadata)
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(bucketThreads).CreateBucket([]byte(t.ID))
+		if err != nil {
+			return err
+		}
+		if _, err := b.CreateBucket(bucketMessages); err != nil {
+			return err
+		}
+		data, err := json.Marshal(t)
+		if err != nil {
+			return err
+		}
+		if err := b.Put(keyThread, data); err != nil {
+			return err
+		}
+		return appendMessages(b, t.ID, messages)
+	})
+	return t, err
+}
+
+func (s *boltStore) Get(id string) (Thread, error) {
+	var t Thread
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketThreads).Bucket([]byte(id))
+		if b == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(b.Get(keyThread), &t)
+	})
+	return t, err
+}
+
+func (s *boltStore) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(bucketThreads).DeleteBucket([]byte(id))
+		if errors.Is(err, bolt.ErrBucketNotFound) {
+			return ErrNotFound
+		}
+		return err
+	})
+}
+
+func (s *boltStore) Messages(id string) ([]Message, error) {
+	var messages []Message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketThreads).Bucket([]byte(id))
+		if b == nil {
+			return ErrNotFound
+		}
+		return b.Bucket(bucketMessages).ForEach(func(_, v []byte) error {
+			var m Message
+			if err := json.Unmarshal(v, &m); err != nil {
+				return err
+			}
+			messages = append(messages, m)
+			return nil
+		})
+	})
+	return messages, err
+}
+
+func (s *boltStore) Append(id string, messages ...Message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucketThreads).Bucket([]byte(id))
+		if b == nil {
+			return ErrNotFound
+		}
+		return appendMessages(b, id, messages)
+	})
+}
+
+func appendMessages(b *bolt.Bucket, threadID string, messages []Message) error {
+	mb := b.Bucket(bucketMessages)
+	for i := range messages {
+		m := &messages[i]
+		m.stamp(threadID)
+		seq, err := mb.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if err := mb.Put(binary.BigEndian.AppendUint64(nil, seq), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newThread(owner string, metadata map[string]string) Thread {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	return Thread{
+		ID:        randomID("thread_", 24),
+		Object:    "thread",
+		CreatedAt: time.Now().Unix(),
+		Metadata:  metadata,
+		Owner:     owner,
+	}
+}
+
+func (m *Message) stamp(threadID string) {
+	m.ID = randomID("msg_", 24)
+	m.Object = "thread.message"
+	m.CreatedAt = time.Now().Unix()
+	m.ThreadID = threadID
+}
+
+func randomID(prefix string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = letters[rand.IntN(len(letters))]
+	}
+	return prefix + string(b)
+}
@@ -0,0 +1,91 @@
+package usagedb
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS usage (
+	day        TEXT    NOT NULL,
+	key        TEXT    NOT NULL,
+	model      TEXT    NOT NULL,
+	requests   INTEGER NOT NULL DEFAULT 0,
+	prompt     INTEGER NOT NULL DEFAULT 0,
+	completion INTEGER NOT NULL DEFAULT 0,
+	cached     INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (day, key, model)
+)`
+
+// Row is token usage of a key and model on Day (UTC, YYYY-MM-DD).
+type Row struct {
+	Day        string
+	Key        string
+	Model      string
+	Requests   int
+	Prompt     int
+	Completion int
+	Cached     int
+}
+
+func (r Row) Total() int {
+	return r.Prompt + r.Completion
+}
+
+type Store struct {
+	db *sql.DB
+}
+
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// one connection serializes writers instead of failing with SQLITE_BUSY
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open usage db %s: %w", path, err)
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Add counts one request of key and model at t.
+func (s *Store) Add(t time.Time, key, model string, prompt, completion, cached int) error {
+	_, err := s.db.Exec(`
+INSERT INTO usage (day, key, model, requests, prompt, completion, cached) VALUES (?, ?, ?, 1, ?, ?, ?)
+ON CONFLICT (day, key, model) DO UPDATE SET
+	requests = requests + 1,
+	prompt = prompt + excluded.prompt,
+	completion = completion + excluded.completion,
+	cached = cached + excluded.cached`,
+		t.UTC().Format(time.DateOnly), key, model, prompt, completion, cached)
+	return err
+}
+
+// Rows returns usage from the day of since on, ordered by day, key and model.
+func (s *Store) Rows(since time.Time) ([]Row, error) {
+	rows, err := s.db.Query(`
+SELECT day, key, model, requests, prompt, completion, cached FROM usage
+WHERE day >= ? ORDER BY day, key, model`, since.UTC().Format(time.DateOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []Row
+	for rows.Next() {
+		var r Row
+		if err := rows.Scan(&r.Day, &r.Key, &r.Model, &r.Requests, &r.Prompt, &r.Completion, &r.Cached); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
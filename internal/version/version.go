@@ -0,0 +1,67 @@
+// Package version is the build metadata of freeglm, set at link time:
+//
+//	go build -ldflags "-X freeglm/internal/version.Version=v1.2.0 -X freeglm/internal/version.Commit=$(git rev-parse HEAD) -X freeglm/internal/version.Date=$(date -u +%FT%TZ)"
+//
+// Values left empty are taken from the VCS stamp of go build.
+package version
+
+import (
+	"cmp"
+	"runtime"
+	"runtime/debug"
+)
+
+var (
+	Version string
+	Commit  string
+	Date    string
+)
+
+// API is the level of the proxy API, it grows when an endpoint or request
+// field is added so clients can tell what a proxy understands.
+const API = 1
+
+type Info struct {
+	Version  string `json:"version"`
+	Commit   string `json:"commit"`
+	Date     string `json:"date"`
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+	API      int    `json:"api"`
+}
+
+func Get() Info {
+	info := Info{
+		Version:  Version,
+		Commit:   Commit,
+		Date:     Date,
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		API:      API,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		if v := build.Main.Version; v != "" && v != "(devel)" {
+			info.Version = cmp.Or(info.Version, v)
+		}
+		for _, setting := range build.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				info.Commit = cmp.Or(info.Commit, setting.Value)
+			case "vcs.time":
+				info.Date = cmp.Or(info.Date, setting.Value)
+			case "vcs.modified":
+				if setting.Value == "true" && Commit == "" && info.Commit != "" {
+					info.Commit += "-dirty"
+				}
+			}
+		}
+	}
+	info.Version = cmp.Or(info.Version, "dev")
+	info.Commit = cmp.Or(info.Commit, "unknown")
+	info.Date = cmp.Or(info.Date, "unknown")
+	return info
+}
+
+func (i Info) String() string {
+	return "freeglm " + i.Version + " (" + i.Commit + ", " + i.Date + ", " + i.Go + " " + i.Platform + ")"
+}
@@ -0,0 +1,186 @@
+// Package freeglmclient is a Go client of freeglm proxy, it also embeds the
+// proxy in process to talk to z.ai directly.
+package freeglmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultBaseURL = "http://127.0.0.1:5000/v1"
+
+	defaultRetries = 2
+	defaultBackoff = 500 * time.Millisecond
+)
+
+type Config struct {
+	// BaseURL of the proxy, DefaultBaseURL when empty.
+	BaseURL string
+	// APIKey is sent as bearer token, the proxy uses its keys when empty.
+	APIKey     string
+	HTTPClient *http.Client
+	// Retries of connection errors, 429 and 5xx responses, -1 disables
+	// retries and 0 means 2.
+	Retries int
+	// RetryBackoff is doubled on every attempt, Retry-After of the response
+	// is used when it is longer.
+	RetryBackoff time.Duration
+}
+
+type Client struct {
+	baseURL string
+	apiKey  string
+	http    *http.Client
+	retries int
+	backoff time.Duration
+}
+
+func New(config Config) *Client {
+	c := &Client{
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
+		apiKey:  config.APIKey,
+		http:    config.HTTPClient,
+		retries: config.Retries,
+		backoff: config.RetryBackoff,
+	}
+	if c.baseURL == "" {
+		c.baseURL = DefaultBaseURL
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	switch {
+	case c.retries == 0:
+		c.retries = defaultRetries
+	case c.retries < 0:
+		c.retries = 0
+	}
+	if c.backoff <= 0 {
+		c.backoff = defaultBackoff
+	}
+	return c
+}
+
+func (c *Client) ChatCompletion(ctx context.Context, req ChatRequest) (*ChatResponse, error) {
+	req.Stream = false
+	resp, err := c.do(ctx, http.MethodPost, "/chat/completions", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("freeglm: decode response: %w", err)
+	}
+	return &out, nil
+}
+
+// Stream starts a streaming completion, retries happen only before the
+// first chunk.
+func (c *Client) Stream(ctx context.Context, req ChatRequest) (*Stream, error) {
+	req.Stream = true
+	resp, err := c.do(ctx, http.MethodPost, "/chat/completions", req)
+	if err != nil {
+		return nil, err
+	}
+	return newStream(resp.Body), nil
+}
+
+func (c *Client) Models(ctx context.Context) ([]Model, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		Data []Model `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("freeglm: decode models: %w", err)
+	}
+	return out.Data, nil
+}
+
+// Version returns build metadata and features of the proxy.
+func (c *Client) Version(ctx context.Context) (*Version, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/version", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var out Version
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("freeglm: decode version: %w", err)
+	}
+	return &out, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+c.apiKey)
+		}
+		resp, err := c.http.Do(req)
+		if err == nil && resp.StatusCode < 400 {
+			return resp, nil
+		}
+		if err == nil && !retryable(resp.StatusCode) || attempt >= c.retries || ctx.Err() != nil {
+			if err != nil {
+				return nil, err
+			}
+			return nil, readError(resp)
+		}
+		wait := c.backoff << attempt
+		if resp != nil {
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && time.Duration(seconds)*time.Second > wait {
+				wait = time.Duration(seconds) * time.Second
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var out struct {
+		Error APIError `json:"error"`
+	}
+	if json.Unmarshal(data, &out) != nil || out.Error.Message == "" {
+		out.Error.Message = strings.TrimSpace(string(data))
+	}
+	out.Error.Status = resp.StatusCode
+	return &out.Error
+}
@@ -0,0 +1,93 @@
+package freeglmclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"freeglm/internal/server"
+)
+
+// ServerOptions configure the embedded proxy, see freeglm server --help for
+// the meaning of the fields.
+type ServerOptions = server.Options
+
+// Provider is an OpenAI compatible upstream of ServerOptions.Providers.
+type Provider = server.Provider
+
+// NewEmbedded runs the proxy handler in process with upstream keys from
+// opts.Keys, requests get the same normalization without a listener.
+func NewEmbedded(opts ServerOptions, config Config) (*Client, error) {
+	handler, err := server.Handler(opts)
+	if err != nil {
+		return nil, err
+	}
+	config.BaseURL = "http://freeglm/v1"
+	config.HTTPClient = &http.Client{Transport: handlerTransport{handler}}
+	return New(config), nil
+}
+
+// handlerTransport serves requests with handler, the response body is piped
+// so streams are read while the handler writes them.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r := req.Clone(req.Context())
+	r.RemoteAddr = "127.0.0.1:0"
+	r.RequestURI = r.URL.RequestURI()
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	body, pw := io.Pipe()
+	w := &pipeWriter{
+		header: http.Header{},
+		body:   pw,
+		ready:  make(chan struct{}),
+		resp:   &http.Response{Proto: "HTTP/1.1", ProtoMajor: 1, ProtoMinor: 1, Body: body, ContentLength: -1, Request: req},
+	}
+	go func() {
+		defer pw.Close()
+		defer w.WriteHeader(http.StatusOK)
+		t.handler.ServeHTTP(w, r)
+	}()
+	select {
+	case <-w.ready:
+		return w.resp, nil
+	case <-req.Context().Done():
+		body.Close()
+		return nil, req.Context().Err()
+	}
+}
+
+type pipeWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+	ready  chan struct{}
+	once   sync.Once
+	resp   *http.Response
+}
+
+func (w *pipeWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.resp.StatusCode = status
+		w.resp.Status = fmt.Sprintf("%d %s", status, http.StatusText(status))
+		w.resp.Header = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+func (w *pipeWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,54 @@
+package freeglmclient
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Stream reads chunks of a streaming completion.
+type Stream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+}
+
+func newStream(body io.ReadCloser) *Stream {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	return &Stream{body: body, scanner: scanner}
+}
+
+// Next returns the next chunk, io.EOF after the last one.
+func (s *Stream) Next() (*ChatChunk, error) {
+	for s.scanner.Scan() {
+		data, ok := bytes.CutPrefix(s.scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil, io.EOF
+		}
+		var errorEvent struct {
+			Error *APIError `json:"error"`
+		}
+		if json.Unmarshal(data, &errorEvent) == nil && errorEvent.Error != nil {
+			return nil, errorEvent.Error
+		}
+		var chunk ChatChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return nil, fmt.Errorf("freeglm: decode chunk: %w", err)
+		}
+		return &chunk, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.ErrUnexpectedEOF
+}
+
+func (s *Stream) Close() error {
+	return s.body.Close()
+}
@@ -0,0 +1,130 @@
+package freeglmclient
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type Message struct {
+	Role             string     `json:"role"`
+	Content          string     `json:"content"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	Name             string     `json:"name,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string     `json:"tool_call_id,omitempty"`
+}
+
+type Tool struct {
+	Type     string   `json:"type"`
+	Function Function `json:"function"`
+}
+
+type Function struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type ToolCall struct {
+	// Index is set on stream deltas only.
+	Index    *int         `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type ChatRequest struct {
+	Model          string    `json:"model"`
+	Messages       []Message `json:"messages"`
+	MaxTokens      int       `json:"max_tokens,omitempty"`
+	Temperature    *float64  `json:"temperature,omitempty"`
+	TopP           *float64  `json:"top_p,omitempty"`
+	Stop           []string  `json:"stop,omitempty"`
+	Tools          []Tool    `json:"tools,omitempty"`
+	ToolChoice     any       `json:"tool_choice,omitempty"`
+	ResponseFormat any       `json:"response_format,omitempty"`
+	// Stream is set by Client.Stream.
+	Stream bool `json:"stream"`
+}
+
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type ChatResponse struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []Choice `json:"choices"`
+	Usage   *Usage   `json:"usage,omitempty"`
+}
+
+type Choice struct {
+	Index        int     `json:"index"`
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type ChatChunk struct {
+	ID      string        `json:"id"`
+	Object  string        `json:"object"`
+	Created int64         `json:"created"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+}
+
+type ChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        Message `json:"delta"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type Model struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// Version of a running proxy, proxies older than /version answer it with
+// 404.
+type Version struct {
+	Version  string          `json:"version"`
+	Commit   string          `json:"commit"`
+	Date     string          `json:"date"`
+	Go       string          `json:"go"`
+	Platform string          `json:"platform"`
+	API      int             `json:"api"`
+	Features map[string]bool `json:"features"`
+}
+
+// Supports reports whether the proxy serves feature with its current flags,
+// unknown features are not supported.
+func (v *Version) Supports(feature string) bool {
+	return v.Features[feature]
+}
+
+// APIError is an error response of the proxy or upstream.
+type APIError struct {
+	Status  int
+	Message string `json:"message"`
+	// Type is like "invalid_request_error", "rate_limit_error" or
+	// "insufficient_quota", Code is more specific like "context_length_exceeded"
+	// and Param names the request field at fault.
+	Type  string `json:"type"`
+	Code  string `json:"code"`
+	Param string `json:"param"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("freeglm: %d %s", e.Status, e.Message)
+}
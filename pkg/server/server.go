@@ -0,0 +1,37 @@
+// Package server exports freeglm proxy handler for other Go services.
+package server
+
+import (
+	"net/http"
+
+	"freeglm/internal/server"
+)
+
+type (
+	// Options mirror flags of freeglm server, see freeglm server --help.
+	Options   = server.Options
+	Provider  = server.Provider
+	Profile   = server.Profile
+	Transport = server.Transport
+	// Rewrite changes payloads of requests matching RewriteMatch.
+	Rewrite      = server.Rewrite
+	RewriteMatch = server.RewriteMatch
+	// Tenant is a logical proxy selected by Host header or path prefix.
+	Tenant = server.Tenant
+
+	// Hook is any value implementing one or more of RequestHook,
+	// ResponseHook and StreamChunkHook.
+	Hook            = server.Hook
+	RequestHook     = server.RequestHook
+	ResponseHook    = server.ResponseHook
+	StreamChunkHook = server.StreamChunkHook
+)
+
+// Handler returns the proxy handler serving /v1/chat/completions and the
+// other freeglm routes, mount it with http.StripPrefix under a path prefix:
+//
+//	handler, err := server.Handler(server.Options{Keys: keys, Model: "glm-4.7-flash"})
+//	mux.Handle("/glm/", http.StripPrefix("/glm", handler))
+func Handler(opts Options) (http.Handler, error) {
+	return server.Handler(opts)
+}